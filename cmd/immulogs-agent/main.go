@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/agent"
+	"github.com/lootek/go-immulogs/pkg/parser"
+	cli "github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name: "immulogs-agent",
+		Flags: []cli.Flag{
+			// Files to follow
+			&cli.StringSliceFlag{Name: "path", Required: true}, // glob pattern, may be repeated
+			&cli.DurationFlag{Name: "poll-interval", Value: time.Second},
+
+			// Parsing
+			&cli.StringFlag{Name: "parser", Value: "none"}, // none|json|logfmt|combined|cri|gopanic|regex
+			&cli.StringFlag{Name: "parser-regex", Value: ""},
+
+			// Shipping
+			&cli.StringFlag{Name: "server", Value: "http://localhost:8000"},
+			&cli.StringFlag{Name: "bucket", Value: ""},
+			&cli.IntFlag{Name: "batch-size", Value: 500},
+			&cli.Int64Flag{Name: "rest-timeout", Value: int64(3 * time.Second)},
+			&cli.IntFlag{Name: "retries", Value: 3},
+			&cli.DurationFlag{Name: "retry-backoff", Value: 500 * time.Millisecond},
+
+			// State
+			&cli.StringFlag{Name: "state-dir", Value: "./immulogs-agent"},
+			&cli.Int64Flag{Name: "buffer-max-bytes", Value: 256 << 20},
+		},
+		Action: func(cliCtx *cli.Context) error {
+			p, err := parser.New(cliCtx.String("parser"), cliCtx.String("parser-regex"))
+			if err != nil {
+				return err
+			}
+
+			a, err := agent.New(agent.Config{
+				Patterns:       cliCtx.StringSlice("path"),
+				Server:         cliCtx.String("server"),
+				Bucket:         cliCtx.String("bucket"),
+				Parser:         p,
+				StateDir:       cliCtx.String("state-dir"),
+				BatchSize:      cliCtx.Int("batch-size"),
+				PollInterval:   cliCtx.Duration("poll-interval"),
+				Timeout:        time.Duration(cliCtx.Int64("rest-timeout")),
+				Retries:        cliCtx.Int("retries"),
+				Backoff:        cliCtx.Duration("retry-backoff"),
+				BufferMaxBytes: cliCtx.Int64("buffer-max-bytes"),
+			})
+			if err != nil {
+				return err
+			}
+
+			ctx, cancelFn := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancelFn()
+
+			return a.Run(ctx)
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/lootek/go-immulogs/pkg/archive"
+	"github.com/lootek/go-immulogs/pkg/checkpoint"
+	cli "github.com/urfave/cli/v2"
+)
+
+// failure is an entry which didn't verify, Record is its position within the archive starting at 1
+type failure struct {
+	Record uint64 `json:"record"`
+	Key    string `json:"key,omitempty"`
+	Tx     uint64 `json:"tx,omitempty"`
+	Error  string `json:"error"`
+}
+
+type report struct {
+	Verified uint64    `json:"verified"`
+	Failed   []failure `json:"failed"`
+	// Manifest is set if the archive doesn't match its manifest
+	Manifest string `json:"manifest_error,omitempty"`
+}
+
+func main() {
+	app := &cli.App{
+		Name:      "immulogs-verify",
+		Usage:     "verify the proofs of an exported archive against a trusted immudb state, offline",
+		ArgsUsage: "<archive>",
+		Flags: []cli.Flag{
+			// the trusted state is either given as is...
+			&cli.Uint64Flag{Name: "tx-id"},
+			&cli.StringFlag{Name: "tx-hash"}, // hex
+			&cli.StringFlag{Name: "state"},   // JSON file with database, tx_id and tx_hash (base64)
+			// ...or as a checkpoint signed by immulogsd
+			&cli.StringFlag{Name: "checkpoint"},
+			&cli.StringFlag{Name: "public-key"}, // PEM
+			&cli.BoolFlag{Name: "json"},
+		},
+		Action: verify,
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func verify(cliCtx *cli.Context) error {
+	trusted, err := trustedState(cliCtx)
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if path := cliCtx.Args().First(); path != "" && path != "-" {
+		in, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	ar, err := archive.NewReader(in)
+	if err != nil {
+		return err
+	}
+
+	rep := report{Failed: []failure{}}
+	for n := uint64(1); ; n++ {
+		r, err := ar.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading record %d: %w", n, err)
+		}
+
+		if err := archive.VerifyRecord(r, trusted); err != nil {
+			rep.Failed = append(rep.Failed, failure{Record: n, Key: r.Key, Tx: r.Tx, Error: err.Error()})
+			continue
+		}
+
+		rep.Verified++
+	}
+
+	if _, err := ar.Manifest(); err != nil {
+		rep.Manifest = err.Error()
+	}
+
+	if cliCtx.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			return err
+		}
+	} else {
+		for _, f := range rep.Failed {
+			fmt.Printf("record %d, key %s, tx %d: %s\n", f.Record, f.Key, f.Tx, f.Error)
+		}
+		if rep.Manifest != "" {
+			fmt.Printf("manifest: %s\n", rep.Manifest)
+		}
+		fmt.Printf("verified: %d\nfailed: %d\n", rep.Verified, len(rep.Failed))
+	}
+
+	if len(rep.Failed) > 0 || rep.Manifest != "" {
+		return cli.Exit("", 1)
+	}
+
+	return nil
+}
+
+func trustedState(cliCtx *cli.Context) (archive.State, error) {
+	switch {
+	case cliCtx.IsSet("checkpoint"):
+		if !cliCtx.IsSet("public-key") {
+			return archive.State{}, errors.New("a checkpoint can't be trusted without --public-key")
+		}
+
+		key, err := checkpoint.LoadPublicKey(cliCtx.String("public-key"))
+		if err != nil {
+			return archive.State{}, err
+		}
+
+		var c checkpoint.Checkpoint
+		if err := readJSON(cliCtx.String("checkpoint"), &c); err != nil {
+			return archive.State{}, err
+		}
+
+		if err := c.Verify(key); err != nil {
+			return archive.State{}, err
+		}
+
+		return c.State(), nil
+
+	case cliCtx.IsSet("state"):
+		var s archive.State
+		err := readJSON(cliCtx.String("state"), &s)
+		return s, err
+
+	case cliCtx.IsSet("tx-id") && cliCtx.IsSet("tx-hash"):
+		hash, err := hex.DecodeString(cliCtx.String("tx-hash"))
+		if err != nil {
+			return archive.State{}, fmt.Errorf("malformed tx hash: %w", err)
+		}
+
+		return archive.State{TxID: cliCtx.Uint64("tx-id"), TxHash: hash}, nil
+
+	default:
+		return archive.State{}, errors.New("a trusted state is required: --checkpoint and --public-key, --state, or --tx-id and --tx-hash")
+	}
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
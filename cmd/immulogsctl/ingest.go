@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/client"
+	"github.com/lootek/go-immulogs/pkg/multiline"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	cli "github.com/urfave/cli/v2"
+)
+
+var ingestFlags = []cli.Flag{
+	&cli.StringFlag{Name: "bucket", Required: true},
+	&cli.StringFlag{Name: "continuation", Value: `^\s`}, // lines matching it are joined with the previous one, empty disables joining
+	&cli.IntFlag{Name: "max-lines", Value: 500},
+	&cli.IntFlag{Name: "batch-size", Value: 100},
+	&cli.DurationFlag{Name: "flush-interval", Value: time.Second},
+	&cli.DurationFlag{Name: "idle-timeout", Value: time.Second}, // how long a pending entry waits for its continuation lines
+}
+
+var ingestCommand = &cli.Command{
+	Name:  "ingest",
+	Usage: "ship the lines read from stdin to a bucket",
+	Flags: ingestFlags,
+	Action: func(cliCtx *cli.Context) error {
+		s, err := newShipper(cliCtx)
+		if err != nil {
+			return err
+		}
+
+		if err := s.ingest(cliCtx.Context, os.Stdin, nil); err != nil {
+			return err
+		}
+
+		if stats := s.close(); stats.Failed > 0 || stats.Dropped > 0 {
+			return cli.Exit(fmt.Sprintf("failed to ship %d entries", stats.Failed+stats.Dropped), 1)
+		}
+
+		return nil
+	},
+}
+
+var runCommand = &cli.Command{
+	Name:      "run",
+	Usage:     "run a command, shipping its combined output to a bucket, and exit with its status",
+	ArgsUsage: "-- <command> [args...]",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{Name: "quiet", Aliases: []string{"q"}}, // don't echo the output of the command
+	}, ingestFlags...),
+	Action: func(cliCtx *cli.Context) error {
+		if cliCtx.NArg() == 0 {
+			return errors.New("expected a command to run")
+		}
+
+		s, err := newShipper(cliCtx)
+		if err != nil {
+			return err
+		}
+
+		// both streams share a single pipe, so the lines of the command stay in order
+		r, w, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		cmd := exec.CommandContext(cliCtx.Context, cliCtx.Args().First(), cliCtx.Args().Tail()...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = w
+		cmd.Stderr = w
+
+		if err := cmd.Start(); err != nil {
+			w.Close()
+			return err
+		}
+		w.Close()
+
+		var echo io.Writer
+		if !cliCtx.Bool("quiet") {
+			echo = os.Stdout
+		}
+
+		if err := s.ingest(cliCtx.Context, r, echo); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		waitErr := cmd.Wait()
+
+		if stats := s.close(); stats.Failed > 0 || stats.Dropped > 0 {
+			fmt.Fprintf(os.Stderr, "failed to ship %d entries\n", stats.Failed+stats.Dropped)
+		}
+
+		var exitErr *exec.ExitError
+		switch {
+		case waitErr == nil:
+			return nil
+		case errors.As(waitErr, &exitErr) && exitErr.ExitCode() > 0:
+			return cli.Exit("", exitErr.ExitCode())
+		default:
+			// killed by a signal
+			return cli.Exit(waitErr.Error(), 1)
+		}
+	},
+}
+
+type shipper struct {
+	writer      *client.Writer
+	joiner      *multiline.Joiner
+	idleTimeout time.Duration
+}
+
+func newShipper(cliCtx *cli.Context) (*shipper, error) {
+	opts, err := clientOptions(cliCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := client.New(cliCtx.String("server"), append(opts, client.WithRetries(3, time.Second))...)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := multiline.New(cliCtx.String("continuation"), cliCtx.Int("max-lines"))
+	if err != nil {
+		return nil, err
+	}
+
+	w := c.NewWriter(cliCtx.String("bucket"), client.WriterOptions{
+		BatchSize:     cliCtx.Int("batch-size"),
+		FlushInterval: cliCtx.Duration("flush-interval"),
+		OnError: func(err error, entries []log.Entry) {
+			fmt.Fprintf(os.Stderr, "shipping %d entries: %v\n", len(entries), err)
+		},
+	})
+
+	return &shipper{writer: w, joiner: j, idleTimeout: cliCtx.Duration("idle-timeout")}, nil
+}
+
+// ingest ships the entries read from r until it's drained, echoing the raw lines to echo unless it's nil
+func (s *shipper) ingest(ctx context.Context, r io.Reader, echo io.Writer) error {
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+
+	go func() {
+		defer close(lines)
+
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				if echo != nil {
+					io.WriteString(echo, line)
+				}
+
+				select {
+				case lines <- strings.TrimRight(line, "\r\n"):
+				case <-ctx.Done():
+					readErr <- ctx.Err()
+					return
+				}
+			}
+
+			if err != nil {
+				if err != io.EOF {
+					readErr <- err
+				}
+				return
+			}
+		}
+	}()
+
+	// a pending entry is shipped once no continuation lines arrive for a while
+	idle := time.NewTimer(s.idleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if entry, ok := s.joiner.Flush(); ok {
+					s.write(entry)
+				}
+
+				select {
+				case err := <-readErr:
+					return err
+				default:
+					return nil
+				}
+			}
+
+			if entry, ok := s.joiner.Add(line); ok {
+				s.write(entry)
+			}
+
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+				}
+			}
+			idle.Reset(s.idleTimeout)
+
+		case <-idle.C:
+			if entry, ok := s.joiner.Flush(); ok {
+				s.write(entry)
+			}
+		}
+	}
+}
+
+func (s *shipper) write(entry string) {
+	if err := s.writer.WriteEntry(log.FromString(entry)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func (s *shipper) close() client.WriterStats {
+	s.writer.Close()
+	return s.writer.Stats()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/client"
+	cli "github.com/urfave/cli/v2"
+)
+
+var keysCommand = &cli.Command{
+	Name:  "keys",
+	Usage: "manage the API keys, requires a key with the admin permission on their buckets",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "create",
+			Usage:     "create a key and print its secret, which can't be shown again",
+			ArgsUsage: "<name>",
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{Name: "bucket", Required: true},     // pattern, e.g. jobs/*
+				&cli.StringSliceFlag{Name: "permission", Required: true}, // read|write|admin
+			},
+			Action: func(cliCtx *cli.Context) error {
+				c, p, err := setup(cliCtx)
+				if err != nil {
+					return err
+				}
+
+				key, secret, err := c.CreateKey(cliCtx.Context, cliCtx.Args().First(), cliCtx.StringSlice("bucket"), cliCtx.StringSlice("permission"))
+				if err != nil {
+					return err
+				}
+
+				if p.format == "json" || p.format == "ndjson" {
+					return p.value(map[string]any{"key": key, "secret": secret})
+				}
+
+				printKey(key)
+				fmt.Fprintf(os.Stdout, "secret: %s\n", secret)
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "list the keys, revoked ones included",
+			Action: func(cliCtx *cli.Context) error {
+				c, p, err := setup(cliCtx)
+				if err != nil {
+					return err
+				}
+
+				keys, err := c.Keys(cliCtx.Context)
+				if err != nil {
+					return err
+				}
+
+				if p.format == "json" || p.format == "ndjson" {
+					return p.value(keys)
+				}
+
+				for _, k := range keys {
+					printKey(k)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "revoke",
+			Usage:     "disable a key for good",
+			ArgsUsage: "<id>",
+			Action: func(cliCtx *cli.Context) error {
+				c, p, err := setup(cliCtx)
+				if err != nil {
+					return err
+				}
+
+				key, err := c.RevokeKey(cliCtx.Context, cliCtx.Args().First())
+				if err != nil {
+					return err
+				}
+
+				if p.format == "json" || p.format == "ndjson" {
+					return p.value(key)
+				}
+
+				printKey(key)
+				return nil
+			},
+		},
+	},
+}
+
+func printKey(k client.Key) {
+	status := "active"
+	if k.RevokedAt != nil {
+		status = "revoked " + k.RevokedAt.Format(time.RFC3339)
+	}
+
+	fmt.Fprintf(os.Stdout, "%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, strings.Join(k.Buckets, ","), strings.Join(k.Permissions, ","), status)
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/certs"
+	"github.com/lootek/go-immulogs/pkg/checkpoint"
+	"github.com/lootek/go-immulogs/pkg/client"
+	"github.com/mattn/go-isatty"
+	cli "github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := &cli.App{
+		Name:  "immulogsctl",
+		Usage: "query and manage immulogsd",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "server", Value: "http://localhost:8000", EnvVars: []string{"IMMULOGS_SERVER"}},
+			&cli.DurationFlag{Name: "timeout", Value: 10 * time.Second},
+			&cli.StringFlag{Name: "format", Aliases: []string{"o"}, Value: "plain"}, // plain|json|ndjson|table
+			&cli.StringFlag{Name: "color", Value: "auto"},                           // auto|always|never
+			&cli.StringFlag{Name: "api-key", EnvVars: []string{"IMMULOGS_API_KEY"}},
+			&cli.StringFlag{Name: "token", EnvVars: []string{"IMMULOGS_TOKEN"}}, // bearer JWT
+			&cli.StringFlag{Name: "tenant", EnvVars: []string{"IMMULOGS_TENANT"}},
+			// https servers are verified against the CAs if given, the certificate is presented for mutual TLS
+			&cli.StringFlag{Name: "tls-ca", EnvVars: []string{"IMMULOGS_TLS_CA"}},
+			&cli.StringFlag{Name: "tls-cert", EnvVars: []string{"IMMULOGS_TLS_CERT"}},
+			&cli.StringFlag{Name: "tls-key", EnvVars: []string{"IMMULOGS_TLS_KEY"}},
+			// entries written are signed when a key is given
+			&cli.StringFlag{Name: "producer", EnvVars: []string{"IMMULOGS_PRODUCER"}},
+			&cli.StringFlag{Name: "signing-key", EnvVars: []string{"IMMULOGS_SIGNING_KEY"}}, // PEM ed25519 private key
+		},
+		Commands: []*cli.Command{
+			{
+				Name:      "tail",
+				Usage:     "print the last entries of a bucket, optionally following new ones",
+				ArgsUsage: "<bucket>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "follow", Aliases: []string{"f"}},
+					&cli.Int64Flag{Name: "n", Value: 10},
+					&cli.DurationFlag{Name: "interval", Value: time.Second},
+				},
+				Action: tail,
+			},
+			{
+				Name:      "last",
+				Usage:     "print the last n entries of a bucket, all of them if n isn't positive",
+				ArgsUsage: "<bucket>",
+				Flags: []cli.Flag{
+					&cli.Int64Flag{Name: "n", Value: 10},
+				},
+				Action: func(cliCtx *cli.Context) error {
+					c, p, err := setup(cliCtx)
+					if err != nil {
+						return err
+					}
+
+					entries, err := c.Last(cliCtx.Context, cliCtx.Args().First(), cliCtx.Int64("n"))
+					if err != nil {
+						return err
+					}
+
+					if err := p.print(entries...); err != nil {
+						return err
+					}
+
+					return p.flush()
+				},
+			},
+			{
+				Name:      "count",
+				Usage:     "print the number of entries of a bucket, or of all of them",
+				ArgsUsage: "[bucket]",
+				Action: func(cliCtx *cli.Context) error {
+					c, p, err := setup(cliCtx)
+					if err != nil {
+						return err
+					}
+
+					cnt, err := c.Count(cliCtx.Context, cliCtx.Args().First())
+					if err != nil {
+						return err
+					}
+
+					return p.value(cnt)
+				},
+			},
+			{
+				Name:      "search",
+				Usage:     "print the most recent entries containing the query, case-insensitively",
+				ArgsUsage: "<bucket> <query>",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "limit", Value: 100},
+				},
+				Action: func(cliCtx *cli.Context) error {
+					c, p, err := setup(cliCtx)
+					if err != nil {
+						return err
+					}
+
+					if cliCtx.NArg() != 2 {
+						return errors.New("expected a bucket and a query")
+					}
+
+					entries, err := c.Search(cliCtx.Context, cliCtx.Args().Get(0), cliCtx.Args().Get(1), cliCtx.Int("limit"))
+					if err != nil {
+						return err
+					}
+
+					if err := p.print(entries...); err != nil {
+						return err
+					}
+
+					return p.flush()
+				},
+			},
+			{
+				Name:  "buckets",
+				Usage: "list all the buckets",
+				Action: func(cliCtx *cli.Context) error {
+					c, p, err := setup(cliCtx)
+					if err != nil {
+						return err
+					}
+
+					buckets, err := c.Buckets(cliCtx.Context)
+					if err != nil {
+						return err
+					}
+
+					if p.format == "json" || p.format == "ndjson" {
+						return p.value(buckets)
+					}
+
+					for _, b := range buckets {
+						if err := p.value(b); err != nil {
+							return err
+						}
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      "verify",
+				Usage:     "verify the proofs of all the entries of a bucket, fails if any of them doesn't verify",
+				ArgsUsage: "<bucket>",
+				Action: func(cliCtx *cli.Context) error {
+					c, p, err := setup(cliCtx)
+					if err != nil {
+						return err
+					}
+
+					res, err := c.Verify(cliCtx.Context, cliCtx.Args().First())
+					if err != nil {
+						return err
+					}
+
+					if p.format == "json" || p.format == "ndjson" {
+						if err := p.value(res); err != nil {
+							return err
+						}
+					} else {
+						fmt.Fprintf(os.Stdout, "verified: %d\nfailed: %d\n", res.Verified, len(res.Failed))
+						for _, key := range res.Failed {
+							fmt.Fprintf(os.Stdout, "  %s\n", key)
+						}
+					}
+
+					if len(res.Failed) > 0 {
+						return cli.Exit("", 1)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      "shred",
+				Usage:     "destroy the encryption keys of a bucket, which makes its entries unreadable for good",
+				ArgsUsage: "<bucket>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "yes"}, // required, as there's no way back
+				},
+				Action: func(cliCtx *cli.Context) error {
+					c, p, err := setup(cliCtx)
+					if err != nil {
+						return err
+					}
+
+					if cliCtx.NArg() != 1 {
+						return errors.New("expected a bucket")
+					}
+					if !cliCtx.Bool("yes") {
+						return errors.New("the entries of the bucket can't be read anymore once shredded, confirm with --yes")
+					}
+
+					n, err := c.Shred(cliCtx.Context, cliCtx.Args().First())
+					if err != nil {
+						return err
+					}
+
+					if p.format == "json" || p.format == "ndjson" {
+						return p.value(map[string]int{"shredded": n})
+					}
+
+					fmt.Fprintf(os.Stdout, "shredded: %d\n", n)
+					return nil
+				},
+			},
+			{
+				Name:      "export",
+				Usage:     "write an archive of a bucket, with the proofs of its entries if the storage has any",
+				ArgsUsage: "<bucket>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "archive", Value: "ndjson"}, // ndjson|tar.gz
+					&cli.StringFlag{Name: "output", Aliases: []string{"out"}, Value: "-"},
+					&cli.Uint64Flag{Name: "tx"}, // the proofs lead to it, e.g. the tx of a checkpoint, to the current state if 0
+				},
+				Action: export,
+			},
+			importCommand,
+			migrateCommand,
+			ingestCommand,
+			runCommand,
+			keysCommand,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setup(cliCtx *cli.Context) (*client.Client, *printer, error) {
+	opts, err := clientOptions(cliCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := client.New(cliCtx.String("server"), opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var color bool
+	switch cliCtx.String("color") {
+	case "always":
+		color = true
+	case "never":
+	case "auto":
+		color = isatty.IsTerminal(os.Stdout.Fd())
+	default:
+		return nil, nil, fmt.Errorf("unknown color mode %q", cliCtx.String("color"))
+	}
+
+	p, err := newPrinter(os.Stdout, cliCtx.String("format"), color)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, p, nil
+}
+
+// clientOptions are the options of the client according to the global flags
+func clientOptions(cliCtx *cli.Context) ([]client.Option, error) {
+	httpClient := &http.Client{
+		Timeout: cliCtx.Duration("timeout"),
+	}
+	if cliCtx.String("tls-ca") != "" || cliCtx.String("tls-cert") != "" {
+		tlsConfig, err := certs.NewClientConfig(cliCtx.String("tls-ca"), cliCtx.String("tls-cert"), cliCtx.String("tls-key"), "")
+		if err != nil {
+			return nil, err
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		httpClient.Transport = transport
+	}
+
+	opts := []client.Option{client.WithHTTPClient(httpClient)}
+
+	if key := cliCtx.String("api-key"); key != "" {
+		opts = append(opts, client.WithAPIKey(key))
+	}
+	if token := cliCtx.String("token"); token != "" {
+		opts = append(opts, client.WithToken(token))
+	}
+	if tenant := cliCtx.String("tenant"); tenant != "" {
+		opts = append(opts, client.WithTenant(tenant))
+	}
+
+	if path := cliCtx.String("signing-key"); path != "" {
+		if cliCtx.String("producer") == "" {
+			return nil, errors.New("signing entries requires --producer")
+		}
+
+		key, err := checkpoint.LoadPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, client.WithSigner(cliCtx.String("producer"), key))
+	}
+
+	return opts, nil
+}
+
+func tail(cliCtx *cli.Context) error {
+	c, p, err := setup(cliCtx)
+	if err != nil {
+		return err
+	}
+
+	b := cliCtx.Args().First()
+
+	// buffered formats would never print anything while following
+	if cliCtx.Bool("follow") {
+		switch p.format {
+		case "json":
+			p.format = "ndjson"
+		case "table":
+			p.format = "plain"
+		}
+	}
+
+	seen, err := c.Count(cliCtx.Context, b)
+	if err != nil {
+		return err
+	}
+
+	if n := cliCtx.Int64("n"); n > 0 && seen > 0 {
+		entries, err := c.Last(cliCtx.Context, b, n)
+		if err != nil {
+			return err
+		}
+
+		if err := p.print(entries...); err != nil {
+			return err
+		}
+	}
+
+	if !cliCtx.Bool("follow") {
+		return p.flush()
+	}
+
+	ticker := time.NewTicker(cliCtx.Duration("interval"))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cliCtx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		cnt, err := c.Count(cliCtx.Context, b)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+
+		if cnt <= seen {
+			continue
+		}
+
+		entries, err := c.Last(cliCtx.Context, b, int64(cnt-seen))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+
+		if err := p.print(entries...); err != nil {
+			return err
+		}
+		seen = cnt
+	}
+}
+
+func export(cliCtx *cli.Context) error {
+	c, _, err := setup(cliCtx)
+	if err != nil {
+		return err
+	}
+
+	body, err := c.ExportAt(cliCtx.Context, cliCtx.Args().First(), cliCtx.String("archive"), cliCtx.Uint64("tx"))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	path := cliCtx.String("output")
+	if path == "-" {
+		_, err = io.Copy(os.Stdout, body)
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, body); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/client"
+	cli "github.com/urfave/cli/v2"
+)
+
+var importCommand = &cli.Command{
+	Name:      "import",
+	Usage:     "replay an archive written by export, or plain NDJSON entries, into a bucket",
+	ArgsUsage: "<bucket>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "input", Aliases: []string{"in"}, Value: "-"},
+		&cli.BoolFlag{Name: "ndjson"}, // plain entries rather than an archive
+		&cli.Uint64Flag{Name: "skip"},
+		&cli.IntFlag{Name: "chunk"},
+	},
+	Action: func(cliCtx *cli.Context) error {
+		c, p, err := setup(cliCtx)
+		if err != nil {
+			return err
+		}
+
+		in := os.Stdin
+		if path := cliCtx.String("input"); path != "-" {
+			in, err = os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+		}
+
+		summary, err := c.Import(cliCtx.Context, cliCtx.Args().First(), in, client.ImportOptions{
+			NDJSON: cliCtx.Bool("ndjson"),
+			Skip:   cliCtx.Uint64("skip"),
+			Chunk:  cliCtx.Int("chunk"),
+		})
+		if p.format == "json" || p.format == "ndjson" {
+			_ = p.value(summary)
+		} else {
+			fmt.Fprintf(os.Stdout, "written: %d\nfailed: %d\nskipped: %d\n", summary.Written, summary.Failed, summary.Skipped)
+		}
+
+		if err != nil {
+			// resuming with --skip written+skipped continues where the import stopped
+			return fmt.Errorf("%w (resume with --skip %d)", err, summary.Skipped+summary.Written)
+		}
+
+		return nil
+	},
+}
+
+var migrateCommand = &cli.Command{
+	Name: "migrate",
+	Usage: "copy buckets from the server to another one, e.g. from a memory storage to an immudb one, " +
+		"an interrupted migration resumes from its state file",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "to", Required: true},
+		&cli.StringFlag{Name: "to-api-key", EnvVars: []string{"IMMULOGS_TO_API_KEY"}},
+		&cli.StringSliceFlag{Name: "bucket"}, // all the buckets if none
+		&cli.StringFlag{Name: "state", Value: "immulogs-migrate.json"},
+		&cli.IntFlag{Name: "chunk"},
+		&cli.DurationFlag{Name: "progress-interval", Value: 5 * time.Second},
+	},
+	Action: migrate,
+}
+
+// migrationState is persisted after every bucket, and after failures, so that a migration can be resumed
+type migrationState struct {
+	path    string
+	Buckets map[string]*bucketMigration `json:"buckets"`
+}
+
+type bucketMigration struct {
+	Imported uint64 `json:"imported"`
+	Done     bool   `json:"done"`
+}
+
+func loadMigrationState(path string) (*migrationState, error) {
+	s := &migrationState{path: path, Buckets: map[string]*bucketMigration{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *migrationState) bucket(name string) *bucketMigration {
+	if s.Buckets[name] == nil {
+		s.Buckets[name] = &bucketMigration{}
+	}
+
+	return s.Buckets[name]
+}
+
+func (s *migrationState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func migrate(cliCtx *cli.Context) error {
+	src, _, err := setup(cliCtx)
+	if err != nil {
+		return err
+	}
+
+	dst, err := client.New(cliCtx.String("to"), client.WithHTTPClient(&http.Client{
+		Timeout: cliCtx.Duration("timeout"),
+	}), client.WithAPIKey(cliCtx.String("to-api-key")))
+	if err != nil {
+		return err
+	}
+
+	state, err := loadMigrationState(cliCtx.String("state"))
+	if err != nil {
+		return err
+	}
+
+	buckets := cliCtx.StringSlice("bucket")
+	if len(buckets) == 0 {
+		buckets, err = src.Buckets(cliCtx.Context)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, b := range buckets {
+		progress := state.bucket(b)
+		if progress.Done {
+			fmt.Fprintf(os.Stderr, "%s: already migrated\n", b)
+			continue
+		}
+
+		total, err := src.Count(cliCtx.Context, b)
+		if err != nil {
+			return err
+		}
+
+		body, err := src.Export(cliCtx.Context, b, "ndjson")
+		if err != nil {
+			return err
+		}
+
+		counter := &lineCounter{r: body}
+		stopProgress := reportProgress(b, counter, progress.Imported, total, cliCtx.Duration("progress-interval"))
+
+		summary, err := dst.Import(cliCtx.Context, b, counter, client.ImportOptions{
+			Skip:  progress.Imported,
+			Chunk: cliCtx.Int("chunk"),
+		})
+		stopProgress()
+		body.Close()
+
+		progress.Imported += summary.Written
+		if err != nil {
+			if saveErr := state.save(); saveErr != nil {
+				return errors.Join(err, saveErr)
+			}
+			return fmt.Errorf("%s: migrated %d of %d entries: %w", b, progress.Imported, total, err)
+		}
+
+		progress.Done = true
+		if err := state.save(); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: migrated %d entries\n", b, progress.Imported)
+	}
+
+	return nil
+}
+
+// lineCounter counts the lines, i.e. the records of an NDJSON archive, read through it
+type lineCounter struct {
+	r     io.Reader
+	lines atomic.Uint64
+}
+
+func (l *lineCounter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.lines.Add(uint64(bytes.Count(p[:n], []byte{'\n'})))
+	return n, err
+}
+
+func reportProgress(b string, counter *lineCounter, skip, total uint64, interval time.Duration) func() {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// the last line is the manifest
+				read := min(counter.lines.Load(), total)
+				fmt.Fprintf(os.Stderr, "%s: %d/%d entries (%d already migrated)\n", b, read, total, skip)
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
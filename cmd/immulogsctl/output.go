@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+var levelColors = map[string]string{
+	"debug":     "\033[90m",
+	"info":      "\033[36m",
+	"notice":    "\033[34m",
+	"warning":   "\033[33m",
+	"error":     "\033[31m",
+	"critical":  "\033[1;31m",
+	"alert":     "\033[1;31m",
+	"emergency": "\033[1;31m",
+}
+
+const colorReset = "\033[0m"
+
+// printer renders entries in one of the output formats: plain, json, ndjson or table
+// json and table output is buffered until flush, streaming commands should use the others
+type printer struct {
+	w      io.Writer
+	format string
+	color  bool
+
+	buffered []any
+	table    *tabwriter.Writer
+}
+
+func newPrinter(w io.Writer, format string, color bool) (*printer, error) {
+	p := &printer{w: w, format: format, color: color}
+
+	switch format {
+	case "plain", "json", "ndjson":
+	case "table":
+		p.table = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(p.table, "TIMESTAMP\tLEVEL\tMESSAGE\tFIELDS")
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	return p, nil
+}
+
+func (p *printer) print(entries ...log.Entry) error {
+	for _, e := range entries {
+		fields := fieldsOf(e)
+
+		switch p.format {
+		case "plain":
+			if fields == nil {
+				fmt.Fprintln(p.w, e.String())
+				continue
+			}
+
+			var parts []string
+			for _, s := range []string{str(fields[log.TimestampKey]), p.level(fields), str(fields[log.MessageKey]), rest(fields)} {
+				if s != "" {
+					parts = append(parts, s)
+				}
+			}
+			fmt.Fprintln(p.w, strings.Join(parts, " "))
+
+		case "table":
+			if fields == nil {
+				fmt.Fprintf(p.table, "\t\t%s\t\n", e.String())
+				continue
+			}
+
+			fmt.Fprintf(p.table, "%s\t%s\t%s\t%s\n", str(fields[log.TimestampKey]), p.level(fields), str(fields[log.MessageKey]), rest(fields))
+
+		case "json", "ndjson":
+			var v any = e.String()
+			if fields != nil {
+				v = fields
+			}
+
+			if p.format == "json" {
+				p.buffered = append(p.buffered, v)
+				continue
+			}
+
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(p.w, string(data))
+		}
+	}
+
+	return nil
+}
+
+// value prints a single non-entry result, e.g. a count
+func (p *printer) value(v any) error {
+	if p.format == "json" || p.format == "ndjson" {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(p.w, string(data))
+		return err
+	}
+
+	_, err := fmt.Fprintln(p.w, v)
+	return err
+}
+
+func (p *printer) flush() error {
+	switch p.format {
+	case "json":
+		if p.buffered == nil {
+			p.buffered = []any{}
+		}
+
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p.buffered)
+	case "table":
+		return p.table.Flush()
+	}
+
+	return nil
+}
+
+func (p *printer) level(fields map[string]any) string {
+	level := str(fields[log.LevelKey])
+	if level == "" {
+		return ""
+	}
+
+	label := strings.ToUpper(level)
+	if color, ok := levelColors[strings.ToLower(level)]; ok && p.color {
+		return color + label + colorReset
+	}
+
+	return label
+}
+
+// fieldsOf returns the fields of structured entries, including the ones read back as JSON strings
+func fieldsOf(e log.Entry) map[string]any {
+	if s, ok := e.(log.Structured); ok {
+		return s.Fields()
+	}
+
+	str := strings.TrimSpace(e.String())
+	if !strings.HasPrefix(str, "{") {
+		return nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(str), &fields); err != nil {
+		return nil
+	}
+
+	return fields
+}
+
+// rest formats the fields other than the well-known ones as sorted key=value pairs
+func rest(fields map[string]any) string {
+	var keys []string
+	for k := range fields {
+		if k != log.TimestampKey && k != log.LevelKey && k != log.MessageKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := str(fields[k])
+		if strings.ContainsAny(v, " \t\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		parts = append(parts, k+"="+v)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func str(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]any, []any:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
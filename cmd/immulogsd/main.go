@@ -2,15 +2,29 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	immudb "github.com/codenotary/immudb/pkg/client"
 	"github.com/lootek/go-immulogs"
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/certs"
+	"github.com/lootek/go-immulogs/pkg/checkpoint"
+	"github.com/lootek/go-immulogs/pkg/pipeline"
+	"github.com/lootek/go-immulogs/pkg/quota"
+	"github.com/lootek/go-immulogs/pkg/redact"
 	"github.com/lootek/go-immulogs/pkg/service"
+	"github.com/lootek/go-immulogs/pkg/signing"
 	"github.com/lootek/go-immulogs/pkg/storage"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
 	cli "github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
@@ -20,12 +34,72 @@ func main() {
 			// Storage mode
 			&cli.StringFlag{Name: "storage", Value: "memory"}, // memory|immudb
 
+			// Encryption at rest
+			&cli.StringFlag{Name: "encryption-master-key", Value: ""}, // 32 bytes key file wrapping the data keys, disabled when empty
+			&cli.StringFlag{Name: "encryption-keyring", Value: ""},    // JSON file of the wrapped data keys, shredded ones are removed from it
+			&cli.StringSliceFlag{Name: "encryption-bucket"},           // patterns of the buckets to encrypt, all of them by default
+
+			// Tenants
+			&cli.StringSliceFlag{Name: "tenant"},              // name=database, or name for a database of the same name
+			&cli.StringFlag{Name: "tenant-domain", Value: ""}, // e.g. logs.example.com to resolve acme.logs.example.com to the acme tenant
+
+			// Quotas
+			&cli.StringFlag{Name: "quotas", Value: ""}, // JSON file of the limits per bucket and tenant, disabled when empty
+
+			// Processing
+			&cli.StringFlag{Name: "pipeline", Value: ""}, // JSON file of the processors per bucket, disabled when empty
+
+			// Redaction
+			&cli.StringFlag{Name: "redaction", Value: ""}, // JSON file of the redaction rules per bucket, disabled when empty
+
+			// Admission control, disabled when zero
+			&cli.Float64Flag{Name: "client-rate", Value: 0}, // requests per second of every client, by API key, token or IP
+			&cli.IntFlag{Name: "client-burst", Value: 0},
+			&cli.IntFlag{Name: "max-in-flight-writes", Value: 0},
+			&cli.DurationFlag{Name: "shed-latency", Value: 0}, // writes are shed while the average storage latency is above it
+
 			// Service API mode
 			&cli.StringFlag{Name: "api", Value: "rest"}, // rest
 
 			// REST
 			&cli.StringFlag{Name: "rest-address", Value: "0.0.0.0:8000"},
 			&cli.Int64Flag{Name: "rest-timeout", Value: int64(3 * time.Second)},
+			&cli.StringFlag{Name: "tls-cert", Value: ""}, // PEM certificate reloaded on change, HTTPS is disabled when empty
+			&cli.StringFlag{Name: "tls-key", Value: ""},
+			&cli.StringFlag{Name: "tls-client-ca", Value: ""},       // PEM CAs the client certificates are verified against
+			&cli.StringFlag{Name: "tls-client-auth", Value: "none"}, // none|optional|require
+			&cli.StringFlag{Name: "tls-client-rules", Value: ""},    // JSON file of rules mapping certificate subjects to buckets and permissions
+
+			// Fluent Forward
+			&cli.StringFlag{Name: "forward-address", Value: ""}, // e.g. 0.0.0.0:24224, disabled when empty
+			&cli.Int64Flag{Name: "forward-timeout", Value: int64(3 * time.Second)},
+
+			// GELF
+			&cli.StringFlag{Name: "gelf-udp-address", Value: ""}, // e.g. 0.0.0.0:12201, disabled when empty
+			&cli.StringFlag{Name: "gelf-tcp-address", Value: ""}, // e.g. 0.0.0.0:12201, disabled when empty
+			&cli.StringFlag{Name: "gelf-bucket-field", Value: "_container_name"},
+			&cli.IntFlag{Name: "gelf-max-message-size", Value: 1 << 20}, // in bytes, decompressed
+
+			// Signed checkpoints
+			&cli.StringFlag{Name: "checkpoint-key", Value: ""}, // PEM ed25519 private key, disabled when empty
+			&cli.DurationFlag{Name: "checkpoint-interval", Value: time.Minute},
+			&cli.StringFlag{Name: "checkpoint-bucket", Value: "checkpoints"},
+
+			// Entry signatures
+			&cli.StringFlag{Name: "producer-keys", Value: ""}, // directory of PEM ed25519 public keys named after their producers, disabled when empty
+			&cli.StringSliceFlag{Name: "signature-policy"},    // off|flag|require for all the buckets, or bucket=off|flag|require
+
+			// API keys
+			&cli.StringFlag{Name: "auth-keys", Value: ""},                                    // JSON file the keys are saved to, authentication is disabled when empty
+			&cli.StringFlag{Name: "auth-admin-key", EnvVars: []string{"IMMULOGS_ADMIN_KEY"}}, // never saved key allowed everything, e.g. to create the first keys
+			&cli.StringFlag{Name: "audit-bucket", Value: "audit"},
+
+			// JWT bearer tokens
+			&cli.StringFlag{Name: "jwt-jwks", Value: ""}, // JSON Web Key Set file, e.g. downloaded from the jwks_uri of an OIDC provider
+			&cli.StringSliceFlag{Name: "jwt-key"},        // PEM public key, tokens are disabled without any key
+			&cli.StringFlag{Name: "jwt-issuer", Value: ""},
+			&cli.StringFlag{Name: "jwt-audience", Value: "immulogs"},
+			&cli.StringFlag{Name: "jwt-rules", Value: ""}, // JSON file of rules mapping claims to buckets and permissions
 
 			// ImmuDB
 			&cli.IntFlag{Name: "immudb-port", Value: 3322},
@@ -34,9 +108,19 @@ func main() {
 			&cli.StringFlag{Name: "immudb-password", Value: "immudb"},
 			&cli.StringFlag{Name: "immudb-database", Value: "defaultdb"},
 			&cli.Int64Flag{Name: "immudb-timeout", Value: int64(3 * time.Second)},
+			&cli.StringFlag{Name: "immudb-tls-ca", Value: ""},   // PEM CAs the server is verified against, TLS is disabled unless this or a certificate is set
+			&cli.StringFlag{Name: "immudb-tls-cert", Value: ""}, // PEM client certificate reloaded on change, for mutual TLS
+			&cli.StringFlag{Name: "immudb-tls-key", Value: ""},
+			&cli.StringFlag{Name: "immudb-tls-server-name", Value: ""},
 		},
 		Action: func(cliCtx *cli.Context) error {
+			databases, err := tenantDatabases(cliCtx.StringSlice("tenant"))
+			if err != nil {
+				return err
+			}
+
 			var storageService service.Storage
+			var tenants service.Tenants
 			switch cliCtx.String("storage") {
 			case "immudb":
 				immudbOpts := immudb.DefaultOptions().
@@ -46,18 +130,194 @@ func main() {
 					WithPassword(cliCtx.String("immudb-password")).
 					WithDatabase(cliCtx.String("immudb-database"))
 
-				storageService = storage.NewImmuDB(immudbOpts)
+				if cliCtx.String("immudb-tls-ca") != "" || cliCtx.String("immudb-tls-cert") != "" {
+					tlsConfig, err := certs.NewClientConfig(cliCtx.String("immudb-tls-ca"), cliCtx.String("immudb-tls-cert"), cliCtx.String("immudb-tls-key"), cliCtx.String("immudb-tls-server-name"))
+					if err != nil {
+						return err
+					}
+
+					// instead of immudb MTLs options, so that the client certificate is reloaded on change
+					immudbOpts = immudbOpts.WithDialOptions([]grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))})
+				}
+
+				db := storage.NewImmuDB(immudbOpts).WithTenants(databases)
+				storageService = db
+				tenants = func(name string) (service.Storage, bool) {
+					return db.Tenant(name)
+				}
 			case "memory":
-				storageService = storage.NewMemory()
+				m := storage.NewMemory().WithTenants(databases)
+				storageService = m
+				tenants = func(name string) (service.Storage, bool) {
+					return m.Tenant(name)
+				}
+			}
+
+			if path := cliCtx.String("encryption-master-key"); path != "" {
+				if cliCtx.String("encryption-keyring") == "" {
+					return errors.New("encryption requires a keyring file, or the entries can't be read after a restart")
+				}
+
+				master, err := storage.LoadMasterKey(path)
+				if err != nil {
+					return err
+				}
+
+				keys, err := storage.NewKeyring(cliCtx.String("encryption-keyring"), master)
+				if err != nil {
+					return err
+				}
+
+				encrypted := storage.NewEncrypted(storageService, keys, cliCtx.StringSlice("encryption-bucket"))
+				storageService = encrypted
+				tenantStorage := tenants
+				tenants = func(name string) (service.Storage, bool) {
+					s, ok := tenantStorage(name)
+					if !ok {
+						return nil, false
+					}
+					return encrypted.ForTenant(name, s), true
+				}
+			}
+
+			var ioServices []immulogs.Service
+			var restOpts []service.RESTOption
+
+			if path := cliCtx.String("checkpoint-key"); path != "" {
+				key, err := checkpoint.LoadPrivateKey(path)
+				if err != nil {
+					return err
+				}
+
+				b := bucket.NewBucket(cliCtx.String("checkpoint-bucket"))
+				ioServices = append(ioServices, service.NewCheckpointer(storageService, key, b, cliCtx.Duration("checkpoint-interval")))
+				restOpts = append(restOpts, service.WithCheckpoints(b, key.Public().(ed25519.PublicKey)))
+			}
+
+			if dir := cliCtx.String("producer-keys"); dir != "" {
+				registry, err := signing.LoadRegistry(dir)
+				if err != nil {
+					return err
+				}
+
+				policies, err := signing.ParsePolicies(cliCtx.StringSlice("signature-policy"))
+				if err != nil {
+					return err
+				}
+
+				restOpts = append(restOpts, service.WithSignatures(registry, policies))
+			}
+
+			if path := cliCtx.String("auth-keys"); path != "" {
+				store, err := auth.NewStore(path)
+				if err != nil {
+					return err
+				}
+
+				if key := cliCtx.String("auth-admin-key"); key != "" {
+					store.Bootstrap(key)
+				}
+
+				restOpts = append(restOpts, service.WithAuth(store, bucket.NewBucket(cliCtx.String("audit-bucket"))))
+			}
+
+			if cliCtx.String("jwt-jwks") != "" || len(cliCtx.StringSlice("jwt-key")) > 0 {
+				v, err := tokenVerifier(cliCtx)
+				if err != nil {
+					return err
+				}
+
+				restOpts = append(restOpts, service.WithTokens(v, bucket.NewBucket(cliCtx.String("audit-bucket"))))
+			}
+
+			if path := cliCtx.String("tls-cert"); path != "" {
+				tlsConfig, err := certs.NewServerConfig(path, cliCtx.String("tls-key"), cliCtx.String("tls-client-ca"), certs.ClientAuth(cliCtx.String("tls-client-auth")))
+				if err != nil {
+					return err
+				}
+
+				restOpts = append(restOpts, service.WithTLS(tlsConfig))
+			}
+
+			if path := cliCtx.String("tls-client-rules"); path != "" {
+				rules, err := auth.LoadRules(path)
+				if err != nil {
+					return err
+				}
+
+				restOpts = append(restOpts, service.WithClientCertificates(rules, bucket.NewBucket(cliCtx.String("audit-bucket"))))
+			}
+
+			if len(databases) > 0 {
+				restOpts = append(restOpts, service.WithTenants(tenants, cliCtx.String("tenant-domain")))
+			}
+
+			admission := service.AdmissionConfig{
+				ClientRate:        cliCtx.Float64("client-rate"),
+				ClientBurst:       cliCtx.Int("client-burst"),
+				MaxInFlightWrites: cliCtx.Int("max-in-flight-writes"),
+				LatencyThreshold:  cliCtx.Duration("shed-latency"),
+			}
+			if admission != (service.AdmissionConfig{}) {
+				restOpts = append(restOpts, service.WithAdmission(admission))
+			}
+
+			// the other services write to the default storage, within the quotas, redacted and processed as well
+			writeStorage := storageService
+			if path := cliCtx.String("quotas"); path != "" {
+				cfg, err := quota.Load(path)
+				if err != nil {
+					return err
+				}
+
+				q := quota.New(cfg, service.StorageTotals(storageService, tenants))
+				restOpts = append(restOpts, service.WithQuotas(q))
+				writeStorage = service.LimitStorage(storageService, q, "")
+			}
+			if path := cliCtx.String("redaction"); path != "" {
+				cfg, err := redact.Load(path)
+				if err != nil {
+					return err
+				}
+
+				red, err := redact.New(cfg)
+				if err != nil {
+					return err
+				}
+
+				restOpts = append(restOpts, service.WithRedaction(red))
+				writeStorage = service.RedactStorage(writeStorage, red)
+			}
+			if path := cliCtx.String("pipeline"); path != "" {
+				cfg, err := pipeline.Load(path)
+				if err != nil {
+					return err
+				}
+
+				p, err := pipeline.New(cfg)
+				if err != nil {
+					return err
+				}
+
+				restOpts = append(restOpts, service.WithPipeline(p))
+				writeStorage = service.ProcessStorage(writeStorage, p, bucket.NewBucket(cliCtx.String("audit-bucket")), bucket.NewBucket(cliCtx.String("checkpoint-bucket")))
 			}
 
-			var ioService immulogs.Service
 			switch cliCtx.String("api") {
 			case "rest":
-				ioService = service.NewREST(storageService, cliCtx.String("rest-address"), time.Duration(cliCtx.Int64("rest-timeout")))
+				ioServices = append(ioServices, service.NewREST(storageService, cliCtx.String("rest-address"), time.Duration(cliCtx.Int64("rest-timeout")), restOpts...))
+			}
+
+			if addr := cliCtx.String("forward-address"); addr != "" {
+				ioServices = append(ioServices, service.NewForward(writeStorage, addr, time.Duration(cliCtx.Int64("forward-timeout"))))
+			}
+
+			if udpAddr, tcpAddr := cliCtx.String("gelf-udp-address"), cliCtx.String("gelf-tcp-address"); udpAddr != "" || tcpAddr != "" {
+				ioServices = append(ioServices, service.NewGELF(writeStorage, udpAddr, tcpAddr, cliCtx.String("gelf-bucket-field")).
+					WithMaxMessageSize(cliCtx.Int("gelf-max-message-size")))
 			}
 
-			srv := immulogs.NewService(storageService, ioService)
+			srv := immulogs.NewService(storageService, ioServices...)
 
 			ctx := context.Background()
 			if err := srv.Run(ctx); err != nil {
@@ -72,3 +332,50 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// tenantDatabases maps the tenants to their databases
+func tenantDatabases(flags []string) (map[string]string, error) {
+	databases := make(map[string]string, len(flags))
+	for _, f := range flags {
+		name, database, found := strings.Cut(f, "=")
+		if !found {
+			database = name
+		}
+		if name == "" || database == "" {
+			return nil, fmt.Errorf("invalid tenant %q, expected name=database", f)
+		}
+
+		databases[name] = database
+	}
+
+	return databases, nil
+}
+
+func tokenVerifier(cliCtx *cli.Context) (*auth.TokenVerifier, error) {
+	var keys []auth.VerificationKey
+	if path := cliCtx.String("jwt-jwks"); path != "" {
+		jwks, err := auth.LoadJWKS(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jwks...)
+	}
+
+	for _, path := range cliCtx.StringSlice("jwt-key") {
+		key, err := auth.LoadVerificationKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	var rules []auth.Rule
+	if path := cliCtx.String("jwt-rules"); path != "" {
+		var err error
+		if rules, err = auth.LoadRules(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return auth.NewTokenVerifier(keys, cliCtx.String("jwt-issuer"), cliCtx.String("jwt-audience"), rules)
+}
@@ -1,16 +1,15 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"time"
 
 	lorem "github.com/drhodes/golorem"
+	"github.com/lootek/go-immulogs/pkg/client"
+	immulog "github.com/lootek/go-immulogs/pkg/storage/log"
 	cli "github.com/urfave/cli/v2"
 )
 
@@ -18,7 +17,8 @@ func main() {
 	app := &cli.App{
 		Name: "logs-gen",
 		Flags: []cli.Flag{
-			&cli.StringFlag{Name: "rest-address", Value: "http://localhost:8000/generator/add"},
+			&cli.StringFlag{Name: "server", Value: "http://localhost:8000"},
+			&cli.StringFlag{Name: "bucket", Value: "generator"},
 			&cli.Int64Flag{Name: "rest-timeout", Value: int64(3 * time.Second)},
 
 			&cli.Int64Flag{Name: "log-interval", Value: int64(10 * time.Second)},
@@ -27,8 +27,11 @@ func main() {
 		Action: func(cliCtx *cli.Context) error {
 			ticker := time.NewTicker(time.Duration(cliCtx.Int64("log-interval")))
 
-			c := &http.Client{
+			c, err := client.New(cliCtx.String("server"), client.WithHTTPClient(&http.Client{
 				Timeout: time.Duration(cliCtx.Int64("rest-timeout")),
+			}))
+			if err != nil {
+				return err
 			}
 
 			for {
@@ -37,11 +40,13 @@ func main() {
 					return nil
 				case <-ticker.C:
 					entries := generateLogEntries(cliCtx.Int64("log-count"))
-					err := sendLogEntry(c, cliCtx.String("rest-address"), entries...)
+					res, err := c.Batch(cliCtx.Context, cliCtx.String("bucket"), entries)
 					if err != nil {
 						fmt.Println(err)
 						continue
 					}
+
+					fmt.Println(res)
 				}
 			}
 		},
@@ -52,39 +57,11 @@ func main() {
 	}
 }
 
-func sendLogEntry(cli *http.Client, uri string, entries ...string) error {
-	jsonData, err := json.Marshal(entries)
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
-	}
-
-	resp, err := cli.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	responseBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	fmt.Println(resp.Status)
-	fmt.Println(string(responseBody))
-
-	return nil
-}
-
-func generateLogEntries(count int64) []string {
-	var entries []string
+func generateLogEntries(count int64) []immulog.Entry {
+	var entries []immulog.Entry
 
 	for i := int64(0); i < count; i++ {
-		entries = append(entries, lorem.Sentence(5, 10))
+		entries = append(entries, immulog.FromString(lorem.Sentence(5, 10)))
 	}
 
 	return entries
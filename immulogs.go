@@ -11,16 +11,16 @@ type Service interface {
 
 type service struct {
 	storageService Service
-	ioService      Service
+	ioServices     []Service
 
 	ctx      context.Context
 	cancelFn context.CancelFunc
 }
 
-func NewService(storage Service, io Service) *service {
+func NewService(storage Service, io ...Service) *service {
 	return &service{
 		storageService: storage,
-		ioService:      io,
+		ioServices:     io,
 	}
 }
 
@@ -37,12 +37,14 @@ func (s *service) Run(ctx context.Context) error {
 		}
 	}()
 
-	go func() {
-		if err := s.ioService.Start(ctx); err != nil {
-			errCh <- err
-			return
-		}
-	}()
+	for _, ioService := range s.ioServices {
+		go func(ioService Service) {
+			if err := ioService.Start(ctx); err != nil {
+				errCh <- err
+				return
+			}
+		}(ioService)
+	}
 
 	select {
 	case <-ctx.Done():
@@ -53,8 +55,10 @@ func (s *service) Run(ctx context.Context) error {
 }
 
 func (s *service) Stop() error {
-	if err := s.ioService.Stop(); err != nil {
-		return err
+	for _, ioService := range s.ioServices {
+		if err := ioService.Stop(); err != nil {
+			return err
+		}
 	}
 
 	if err := s.storageService.Stop(); err != nil {
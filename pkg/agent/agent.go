@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	stdlog "log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/parser"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+const (
+	offsetsFile = "offsets.json"
+	bufferFile  = "buffer.ndjson"
+
+	// maxReadBytes limits how much of a single file is read on every poll
+	maxReadBytes = 1 << 20
+)
+
+type Config struct {
+	// Patterns are globs of the files to follow
+	Patterns []string
+	// Server is the base URL of immulogsd, e.g. http://localhost:8000
+	Server string
+	Bucket string
+	// Parser turns lines into entries, lines it fails to parse are shipped as plain strings
+	Parser parser.Parser
+	// StateDir keeps the offsets and the on-disk buffer
+	StateDir string
+
+	BatchSize    int
+	PollInterval time.Duration
+	Timeout      time.Duration
+	Retries      int
+	Backoff      time.Duration
+
+	// BufferMaxBytes caps the on-disk buffer, tailing pauses once it's full
+	BufferMaxBytes int64
+}
+
+// Agent tails files and ships their lines to immulogsd in batches
+type Agent struct {
+	cfg     Config
+	offsets *offsets
+	tailer  *tailer
+	buffer  *buffer
+	sender  *sender
+}
+
+func New(cfg Config) (*Agent, error) {
+	if cfg.Parser == nil {
+		cfg.Parser = parser.None{}
+	}
+
+	if cfg.BatchSize < 1 {
+		return nil, errors.New("batch size must be positive")
+	}
+
+	if err := os.MkdirAll(cfg.StateDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	o, err := loadOffsets(filepath.Join(cfg.StateDir, offsetsFile))
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newSender(cfg.Server, cfg.Bucket, cfg.Timeout, cfg.Retries, cfg.Backoff)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		cfg:     cfg,
+		offsets: o,
+		tailer:  newTailer(cfg.Patterns, o),
+		buffer:  &buffer{path: filepath.Join(cfg.StateDir, bufferFile), maxBytes: cfg.BufferMaxBytes},
+		sender:  s,
+	}, nil
+}
+
+func (a *Agent) Run(ctx context.Context) error {
+	defer a.tailer.closeAll()
+
+	ticker := time.NewTicker(a.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.poll(ctx); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll ships whatever was buffered or appended to the followed files since the last poll
+func (a *Agent) poll(ctx context.Context) error {
+	if err := a.flushBuffer(ctx); err != nil {
+		stdlog.Printf("flushing buffer: %v", err)
+	}
+
+	files, err := a.tailer.scan()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		for ctx.Err() == nil {
+			lines, next, err := f.read(maxReadBytes)
+			if err != nil {
+				return err
+			}
+
+			if len(lines) == 0 {
+				f.offset = next
+				break
+			}
+
+			if err := a.deliver(ctx, a.encode(lines)); err != nil {
+				// the lines stay unshipped and are read again on the next poll
+				stdlog.Printf("shipping %s: %v", f.path, err)
+				return a.offsets.save()
+			}
+
+			f.offset = next
+			a.offsets.set(f.path, f.id, next)
+		}
+	}
+
+	return a.offsets.save()
+}
+
+func (a *Agent) encode(lines []string) [][]byte {
+	encoded := make([][]byte, 0, len(lines))
+	for _, line := range lines {
+		e, err := a.cfg.Parser.Parse(line)
+		if err != nil {
+			e = log.FromString(line)
+		}
+
+		data, _ := json.Marshal(e)
+		encoded = append(encoded, data)
+	}
+
+	return encoded
+}
+
+// deliver sends the entries in batches, falling back to the on-disk buffer when the server is unreachable
+// once anything is buffered, new entries are buffered too so that they are shipped in order
+func (a *Agent) deliver(ctx context.Context, lines [][]byte) error {
+	size, err := a.buffer.size()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(lines); i += a.cfg.BatchSize {
+		batch := lines[i:min(i+a.cfg.BatchSize, len(lines))]
+
+		if size == 0 {
+			err := a.sender.send(ctx, batch)
+			if permanent(err) {
+				stdlog.Printf("dropping %d entries: %v", len(batch), err)
+				continue
+			}
+			if err == nil {
+				continue
+			}
+		}
+
+		if err := a.buffer.append(lines[i:]); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+func (a *Agent) flushBuffer(ctx context.Context) error {
+	lines, err := a.buffer.read()
+	if err != nil || len(lines) == 0 {
+		return err
+	}
+
+	for len(lines) > 0 {
+		batch := lines[:min(a.cfg.BatchSize, len(lines))]
+
+		err := a.sender.send(ctx, batch)
+		if permanent(err) {
+			stdlog.Printf("dropping %d buffered entries: %v", len(batch), err)
+		} else if err != nil {
+			return errors.Join(err, a.buffer.replace(lines))
+		}
+
+		lines = lines[len(batch):]
+	}
+
+	return a.buffer.replace(nil)
+}
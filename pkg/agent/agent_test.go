@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/parser"
+	"github.com/stretchr/testify/require"
+)
+
+type serverMock struct {
+	mu     sync.Mutex
+	status int
+	paths  []string
+	lines  []string
+}
+
+func (s *serverMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status != http.StatusOK {
+		w.WriteHeader(s.status)
+		return
+	}
+
+	s.paths = append(s.paths, r.URL.Path)
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		s.lines = append(s.lines, scanner.Text())
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *serverMock) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *serverMock) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := s.lines
+	s.lines = nil
+	return lines
+}
+
+func TestAgent(t *testing.T) {
+	setup := func(t *testing.T, p parser.Parser) (*serverMock, string, func() *Agent) {
+		srv := &serverMock{status: http.StatusOK}
+		httpSrv := httptest.NewServer(srv)
+		t.Cleanup(httpSrv.Close)
+
+		dir := t.TempDir()
+		newAgent := func() *Agent {
+			a, err := New(Config{
+				Patterns:  []string{filepath.Join(dir, "logs", "*.log")},
+				Server:    httpSrv.URL,
+				Bucket:    "my-bucket-name",
+				Parser:    p,
+				StateDir:  filepath.Join(dir, "state"),
+				BatchSize: 2,
+				Timeout:   time.Second,
+				Retries:   1,
+				Backoff:   time.Millisecond,
+			})
+			require.NoError(t, err)
+			t.Cleanup(a.tailer.closeAll)
+			return a
+		}
+
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "logs"), 0o700))
+		return srv, filepath.Join(dir, "logs"), newAgent
+	}
+
+	write := func(t *testing.T, path, data string) {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		require.NoError(t, err)
+		_, err = f.WriteString(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	ctx := context.Background()
+
+	t.Run("tail appended lines", func(t *testing.T) {
+		srv, dir, newAgent := setup(t, nil)
+		a := newAgent()
+		log := filepath.Join(dir, "app.log")
+
+		write(t, log, "line #1\nline #2\nline #3\npartial")
+		require.NoError(t, a.poll(ctx))
+		require.Equal(t, []string{`"line #1"`, `"line #2"`, `"line #3"`}, srv.received())
+		require.Equal(t, []string{"/my-bucket-name/batch", "/my-bucket-name/batch"}, srv.paths)
+
+		require.NoError(t, a.poll(ctx))
+		require.Empty(t, srv.received())
+
+		write(t, log, " line #4\r\nline #5\n")
+		require.NoError(t, a.poll(ctx))
+		require.Equal(t, []string{`"partial line #4"`, `"line #5"`}, srv.received())
+
+		write(t, filepath.Join(dir, "other.log"), "other #1\n")
+		write(t, filepath.Join(dir, "ignored.txt"), "ignored\n")
+		require.NoError(t, a.poll(ctx))
+		require.Equal(t, []string{`"other #1"`}, srv.received())
+	})
+
+	t.Run("resume from persisted offsets", func(t *testing.T) {
+		srv, dir, newAgent := setup(t, nil)
+		log := filepath.Join(dir, "app.log")
+
+		write(t, log, "line #1\n")
+		require.NoError(t, newAgent().poll(ctx))
+		require.Equal(t, []string{`"line #1"`}, srv.received())
+
+		write(t, log, "line #2\n")
+		require.NoError(t, newAgent().poll(ctx))
+		require.Equal(t, []string{`"line #2"`}, srv.received())
+	})
+
+	t.Run("truncation", func(t *testing.T) {
+		srv, dir, newAgent := setup(t, nil)
+		a := newAgent()
+		log := filepath.Join(dir, "app.log")
+
+		write(t, log, "a rather long line #1\n")
+		require.NoError(t, a.poll(ctx))
+		require.Equal(t, []string{`"a rather long line #1"`}, srv.received())
+
+		require.NoError(t, os.Truncate(log, 0))
+		write(t, log, "line #2\n")
+		require.NoError(t, a.poll(ctx))
+		require.Equal(t, []string{`"line #2"`}, srv.received())
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		srv, dir, newAgent := setup(t, nil)
+		a := newAgent()
+		log := filepath.Join(dir, "app.log")
+
+		write(t, log, "line #1\n")
+		require.NoError(t, a.poll(ctx))
+		require.Equal(t, []string{`"line #1"`}, srv.received())
+
+		write(t, log, "line #2\n")
+		require.NoError(t, os.Rename(log, filepath.Join(dir, "app.log.1")))
+		write(t, log, "line #3\n")
+
+		require.NoError(t, a.poll(ctx))
+		require.NoError(t, a.poll(ctx))
+		require.Equal(t, []string{`"line #2"`, `"line #3"`}, srv.received())
+	})
+
+	t.Run("buffer while the server is unreachable", func(t *testing.T) {
+		srv, dir, newAgent := setup(t, nil)
+		a := newAgent()
+		log := filepath.Join(dir, "app.log")
+
+		srv.setStatus(http.StatusServiceUnavailable)
+		write(t, log, "line #1\nline #2\nline #3\n")
+		require.NoError(t, a.poll(ctx))
+		write(t, log, "line #4\n")
+		require.NoError(t, a.poll(ctx))
+		require.Empty(t, srv.received())
+
+		srv.setStatus(http.StatusOK)
+		write(t, log, "line #5\n")
+		require.NoError(t, a.poll(ctx))
+		require.Equal(t, []string{`"line #1"`, `"line #2"`, `"line #3"`, `"line #4"`, `"line #5"`}, srv.received())
+
+		size, err := a.buffer.size()
+		require.NoError(t, err)
+		require.Zero(t, size)
+	})
+
+	t.Run("pause when the buffer is full", func(t *testing.T) {
+		srv, dir, newAgent := setup(t, nil)
+		a := newAgent()
+		a.buffer.maxBytes = 8
+		log := filepath.Join(dir, "app.log")
+
+		srv.setStatus(http.StatusServiceUnavailable)
+		write(t, log, "line #1\n")
+		require.NoError(t, a.poll(ctx))
+
+		srv.setStatus(http.StatusOK)
+		require.NoError(t, a.poll(ctx))
+		require.Equal(t, []string{`"line #1"`}, srv.received())
+	})
+
+	t.Run("drop rejected batches", func(t *testing.T) {
+		srv, dir, newAgent := setup(t, nil)
+		a := newAgent()
+		log := filepath.Join(dir, "app.log")
+
+		srv.setStatus(http.StatusBadRequest)
+		write(t, log, "line #1\n")
+		require.NoError(t, a.poll(ctx))
+
+		srv.setStatus(http.StatusOK)
+		write(t, log, "line #2\n")
+		require.NoError(t, a.poll(ctx))
+		require.Equal(t, []string{`"line #2"`}, srv.received())
+	})
+
+	t.Run("parse lines", func(t *testing.T) {
+		srv, dir, newAgent := setup(t, parser.Logfmt{})
+		a := newAgent()
+
+		write(t, filepath.Join(dir, "app.log"), "level=info msg=hello\n\"unparsable\n")
+		require.NoError(t, a.poll(ctx))
+		require.Equal(t, []string{`{"level":"info","msg":"hello"}`, `"\"unparsable"`}, srv.received())
+	})
+}
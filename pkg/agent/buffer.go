@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// buffer keeps NDJSON-encoded entries on disk while the server is unreachable
+type buffer struct {
+	path     string
+	maxBytes int64
+}
+
+var errBufferFull = errors.New("buffer is full")
+
+func (b *buffer) size() (int64, error) {
+	fi, err := os.Stat(b.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+func (b *buffer) append(lines [][]byte) error {
+	size, err := b.size()
+	if err != nil {
+		return err
+	}
+
+	data := append(bytes.Join(lines, []byte{'\n'}), '\n')
+	if b.maxBytes > 0 && size+int64(len(data)) > b.maxBytes {
+		return errBufferFull
+	}
+
+	f, err := os.OpenFile(b.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (b *buffer) read() ([][]byte, error) {
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// replace atomically swaps the buffer contents for the given lines
+func (b *buffer) replace(lines [][]byte) error {
+	if len(lines) == 0 {
+		if err := os.Remove(b.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, append(bytes.Join(lines, []byte{'\n'}), '\n'), 0o600); err != nil {
+		return fmt.Errorf("rewriting buffer: %w", err)
+	}
+
+	return os.Rename(tmp, b.path)
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type offset struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+}
+
+// offsets persists how far every tailed file has been shipped
+type offsets struct {
+	path  string
+	files map[string]offset
+}
+
+func loadOffsets(path string) (*offsets, error) {
+	o := &offsets{path: path, files: map[string]offset{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &o.files); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (o *offsets) get(path, id string) int64 {
+	off, ok := o.files[path]
+	if !ok || off.ID != id {
+		return 0
+	}
+
+	return off.Offset
+}
+
+func (o *offsets) set(path, id string, n int64) {
+	o.files[path] = offset{ID: id, Offset: n}
+}
+
+func (o *offsets) remove(path string) {
+	delete(o.files, path)
+}
+
+// save writes the offsets atomically, so that a crash never leaves a half-written file behind
+func (o *offsets) save() error {
+	data, err := json.Marshal(o.files)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), o.path)
+}
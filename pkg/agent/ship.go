@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/client"
+)
+
+// sender posts NDJSON batches to the /:bucket/batch endpoint
+type sender struct {
+	client *client.Client
+	bucket string
+}
+
+func newSender(server, bucket string, timeout time.Duration, retries int, backoff time.Duration) (*sender, error) {
+	c, err := client.New(server,
+		client.WithHTTPClient(&http.Client{Timeout: timeout}),
+		client.WithRetries(retries, backoff),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sender{client: c, bucket: bucket}, nil
+}
+
+func (s *sender) send(ctx context.Context, lines [][]byte) error {
+	body := append(bytes.Join(lines, []byte{'\n'}), '\n')
+	_, err := s.client.BatchRaw(ctx, s.bucket, "application/x-ndjson", body)
+	return err
+}
+
+// permanent tells whether the server rejected a batch in a way retrying can't fix
+func permanent(err error) bool {
+	var apiErr *client.Error
+	return errors.As(err, &apiErr) && !apiErr.Temporary()
+}
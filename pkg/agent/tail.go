@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// tailedFile is a file being followed, offset points right after the last shipped line
+type tailedFile struct {
+	path   string
+	file   *os.File
+	id     string
+	offset int64
+
+	// rotated is set once path points to another file, the old one is then read to its end and closed
+	rotated bool
+}
+
+// read returns the complete lines available past the offset along with the offset following them,
+// the offset itself is only advanced by the caller once the lines are shipped
+func (f *tailedFile) read(maxBytes int) ([]string, int64, error) {
+	buf := make([]byte, maxBytes)
+	n, err := f.file.ReadAt(buf, f.offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, f.offset, err
+	}
+	buf = buf[:n]
+
+	end := bytes.LastIndexByte(buf, '\n') + 1
+	if end == 0 && n == maxBytes {
+		// a line longer than maxBytes is split rather than blocking the file forever
+		end = n
+	}
+
+	var lines []string
+	for _, line := range bytes.Split(buf[:end], []byte{'\n'}) {
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		if len(line) > 0 {
+			lines = append(lines, string(line))
+		}
+	}
+
+	return lines, f.offset + int64(end), nil
+}
+
+// tailer follows all the files matching glob patterns, noticing new files, rotations and truncations
+type tailer struct {
+	patterns []string
+	offsets  *offsets
+	files    map[string]*tailedFile
+}
+
+func newTailer(patterns []string, o *offsets) *tailer {
+	return &tailer{
+		patterns: patterns,
+		offsets:  o,
+		files:    map[string]*tailedFile{},
+	}
+}
+
+// scan refreshes the set of followed files and returns them ordered by path
+func (t *tailer) scan() ([]*tailedFile, error) {
+	for path, f := range t.files {
+		fi, err := os.Stat(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		openFi, err := f.file.Stat()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case fi == nil || !os.SameFile(fi, openFi):
+			// renamed or removed: finish reading the old file and switch over once it's drained
+			f.rotated = true
+			if f.offset >= openFi.Size() {
+				t.close(f)
+				if fi == nil {
+					t.offsets.remove(path)
+				}
+			}
+		case fi.Size() < f.offset:
+			// truncated in place
+			f.offset = 0
+			t.offsets.set(f.path, f.id, 0)
+		}
+	}
+
+	for _, pattern := range t.patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range matches {
+			if _, ok := t.files[path]; ok {
+				continue
+			}
+
+			if err := t.open(path); err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				return nil, err
+			}
+		}
+	}
+
+	var files []*tailedFile
+	for _, f := range t.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+
+	return files, nil
+}
+
+func (t *tailer) open(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if fi.IsDir() {
+		file.Close()
+		return nil
+	}
+
+	id := fileID(fi)
+	offset := t.offsets.get(path, id)
+	if offset > fi.Size() {
+		offset = 0
+	}
+
+	t.files[path] = &tailedFile{path: path, file: file, id: id, offset: offset}
+	return nil
+}
+
+func (t *tailer) close(f *tailedFile) {
+	_ = f.file.Close()
+	delete(t.files, f.path)
+}
+
+func (t *tailer) closeAll() {
+	for _, f := range t.files {
+		t.close(f)
+	}
+}
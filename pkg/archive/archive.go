@@ -0,0 +1,57 @@
+package archive
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Version of the archive format
+const Version = 1
+
+const (
+	FormatNDJSON = "ndjson"
+	FormatTarGz  = "tar.gz"
+
+	// ManifestFile is the name of the manifest within tar.gz archives, it's always the last file
+	ManifestFile = "manifest.json"
+)
+
+// Record is a single exported entry
+type Record struct {
+	// Key is the storage key, empty for storages without keys
+	Key string `json:"key,omitempty"`
+	// Tx is the immudb transaction of the entry, 0 for storages without transactions
+	Tx uint64 `json:"tx,omitempty"`
+	// Value is the entry exactly as stored
+	Value string `json:"value"`
+	// Proof is the immudb VerifiableEntry, in protojson, proving the entry up to the manifest's state
+	Proof json.RawMessage `json:"proof,omitempty"`
+}
+
+// State is the immudb state all the proofs of an archive lead to
+type State struct {
+	Database string `json:"database"`
+	TxID     uint64 `json:"tx_id"`
+	TxHash   []byte `json:"tx_hash"`
+}
+
+// File is a chunk of records within a tar.gz archive
+type File struct {
+	Name   string `json:"name"`
+	Count  uint64 `json:"count"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes the content of an archive, an archive without a manifest is incomplete
+type Manifest struct {
+	Version   int       `json:"version"`
+	Bucket    string    `json:"bucket"`
+	CreatedAt time.Time `json:"created_at"`
+	Count     uint64    `json:"count"`
+	FirstTx   uint64    `json:"first_tx,omitempty"`
+	LastTx    uint64    `json:"last_tx,omitempty"`
+	// SHA256 is the digest of all the record lines, in order, regardless of the format
+	SHA256 string `json:"sha256"`
+	Files  []File `json:"files,omitempty"`
+	State  *State `json:"state,omitempty"`
+}
@@ -0,0 +1,189 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// ErrIncomplete is returned by Manifest for archives cut short, i.e. without a manifest
+var ErrIncomplete = errors.New("the archive has no manifest, it's incomplete")
+
+// Reader reads the records of an archive in either format, checking them against the manifest
+type Reader struct {
+	br *bufio.Reader
+	tr *tar.Reader
+
+	// file is the tar entry being read, if any
+	file     *File
+	fileHash hash.Hash
+
+	digest   hash.Hash
+	count    uint64
+	files    []File
+	manifest *Manifest
+	line     int
+}
+
+// NewReader detects the format of the archive, tar.gz archives are gzip compressed while NDJSON ones are not
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	ar := &Reader{digest: sha256.New()}
+
+	magic, err := br.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		ar.br = br
+		return ar, nil
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	ar.tr = tar.NewReader(gz)
+
+	return ar, nil
+}
+
+// Next returns the next record, or io.EOF once all of them have been read
+func (r *Reader) Next() (Record, error) {
+	for {
+		if r.br == nil {
+			if err := r.nextFile(); err != nil {
+				return Record{}, err
+			}
+			continue
+		}
+
+		raw, err := r.br.ReadBytes('\n')
+		if len(raw) == 0 && errors.Is(err, io.EOF) {
+			if r.tr == nil {
+				return Record{}, io.EOF
+			}
+
+			r.closeFile()
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return Record{}, err
+		}
+
+		r.line++
+		if r.fileHash != nil {
+			r.fileHash.Write(raw)
+		}
+
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+
+		var line struct {
+			Record
+			Manifest *Manifest `json:"manifest"`
+		}
+		if err := json.Unmarshal(raw, &line); err != nil {
+			return Record{}, fmt.Errorf("line %d: %w", r.line, err)
+		}
+
+		if r.manifest != nil {
+			return Record{}, fmt.Errorf("line %d: unexpected content after the manifest", r.line)
+		}
+
+		if line.Manifest != nil {
+			r.manifest = line.Manifest
+			continue
+		}
+
+		r.digest.Write(raw)
+		r.count++
+		if r.file != nil {
+			r.file.Count++
+		}
+
+		return line.Record, nil
+	}
+}
+
+// nextFile moves on to the next records file of a tar.gz archive, reading the manifest on the way
+func (r *Reader) nextFile() error {
+	for {
+		h, err := r.tr.Next()
+		if err != nil {
+			return err
+		}
+
+		if h.Name == ManifestFile {
+			var m Manifest
+			if err := json.NewDecoder(r.tr).Decode(&m); err != nil {
+				return fmt.Errorf("%s: %w", ManifestFile, err)
+			}
+
+			r.manifest = &m
+			continue
+		}
+
+		if r.manifest != nil {
+			return fmt.Errorf("%s: unexpected file after the manifest", h.Name)
+		}
+
+		if !strings.HasSuffix(h.Name, ".ndjson") {
+			continue
+		}
+
+		r.br = bufio.NewReader(r.tr)
+		r.file = &File{Name: h.Name}
+		r.fileHash = sha256.New()
+		r.line = 0
+		return nil
+	}
+}
+
+func (r *Reader) closeFile() {
+	r.file.SHA256 = hex.EncodeToString(r.fileHash.Sum(nil))
+	r.files = append(r.files, *r.file)
+	r.br, r.file, r.fileHash = nil, nil, nil
+}
+
+// Manifest returns the manifest once all the records have been read,
+// failing if it's missing or if it doesn't match the records
+func (r *Reader) Manifest() (*Manifest, error) {
+	if r.manifest == nil {
+		return nil, ErrIncomplete
+	}
+
+	m := r.manifest
+	if m.Count != r.count {
+		return m, fmt.Errorf("the manifest lists %d records, the archive has %d", m.Count, r.count)
+	}
+
+	if sum := hex.EncodeToString(r.digest.Sum(nil)); m.SHA256 != sum {
+		return m, fmt.Errorf("the manifest digest %s doesn't match the records digest %s", m.SHA256, sum)
+	}
+
+	if r.tr != nil {
+		if len(m.Files) != len(r.files) {
+			return m, fmt.Errorf("the manifest lists %d files, the archive has %d", len(m.Files), len(r.files))
+		}
+
+		for i, f := range r.files {
+			if m.Files[i] != f {
+				return m, fmt.Errorf("%s doesn't match the manifest", f.Name)
+			}
+		}
+	}
+
+	return m, nil
+}
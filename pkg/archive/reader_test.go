@@ -0,0 +1,104 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	var records []Record
+	for i := 1; i <= 3; i++ {
+		records = append(records, Record{Key: fmt.Sprintf("b_%d", i), Tx: uint64(i), Value: fmt.Sprintf("#%d", i)})
+	}
+
+	archive := func(t *testing.T, format string) []byte {
+		var buf bytes.Buffer
+		w, err := NewWriter(format, &buf, "b")
+		require.NoError(t, err)
+
+		for _, r := range records {
+			require.NoError(t, w.Write(r))
+		}
+
+		_, err = w.Close(&State{Database: "db", TxID: 3})
+		require.NoError(t, err)
+
+		return buf.Bytes()
+	}
+
+	readAll := func(t *testing.T, data []byte) ([]Record, *Reader) {
+		r, err := NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		var got []Record
+		for {
+			rec, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				return got, r
+			}
+			require.NoError(t, err)
+			got = append(got, rec)
+		}
+	}
+
+	for _, format := range []string{FormatNDJSON, FormatTarGz} {
+		t.Run(format, func(t *testing.T) {
+			got, r := readAll(t, archive(t, format))
+			require.Equal(t, records, got)
+
+			m, err := r.Manifest()
+			require.NoError(t, err)
+			require.Equal(t, uint64(3), m.Count)
+			require.Equal(t, &State{Database: "db", TxID: 3}, m.State)
+		})
+	}
+
+	t.Run("incomplete", func(t *testing.T) {
+		data := archive(t, FormatNDJSON)
+		cut := data[:bytes.LastIndex(data[:len(data)-1], []byte("\n"))+1]
+
+		got, r := readAll(t, cut)
+		require.Equal(t, records, got)
+
+		_, err := r.Manifest()
+		require.ErrorIs(t, err, ErrIncomplete)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		data := bytes.Replace(archive(t, FormatNDJSON), []byte(`"#2"`), []byte(`"#9"`), 1)
+
+		_, r := readAll(t, data)
+		_, err := r.Manifest()
+		require.ErrorContains(t, err, "doesn't match the records digest")
+	})
+
+	t.Run("missing records", func(t *testing.T) {
+		data := archive(t, FormatNDJSON)
+		lines := strings.SplitAfter(string(data), "\n")
+
+		_, r := readAll(t, []byte(lines[0]+lines[2]+lines[3]))
+		_, err := r.Manifest()
+		require.ErrorContains(t, err, "the manifest lists 3 records, the archive has 2")
+	})
+
+	t.Run("content after the manifest", func(t *testing.T) {
+		data := append(archive(t, FormatNDJSON), []byte("{\"value\":\"#4\"}\n")...)
+
+		r, err := NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			_, err := r.Next()
+			require.NoError(t, err)
+		}
+
+		_, err = r.Next()
+		require.ErrorContains(t, err, "line 5: unexpected content after the manifest")
+	})
+}
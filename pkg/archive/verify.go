@@ -0,0 +1,80 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/codenotary/immudb/embedded/store"
+	"github.com/codenotary/immudb/pkg/api/schema"
+	"github.com/codenotary/immudb/pkg/database"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var ErrNoProof = errors.New("the record has no proof")
+
+// VerifyRecord checks, offline, that the record was committed by immudb in its transaction
+// and that the transaction leads to the trusted state
+func VerifyRecord(r Record, trusted State) error {
+	if len(r.Proof) == 0 {
+		return ErrNoProof
+	}
+
+	var ve schema.VerifiableEntry
+	if err := protojson.Unmarshal(r.Proof, &ve); err != nil {
+		return fmt.Errorf("malformed proof: %w", err)
+	}
+
+	if ve.Entry == nil || ve.VerifiableTx == nil || ve.VerifiableTx.Tx == nil || ve.VerifiableTx.Tx.Header == nil ||
+		ve.VerifiableTx.DualProof == nil || ve.InclusionProof == nil {
+		return errors.New("incomplete proof")
+	}
+
+	if ve.Entry.ReferencedBy != nil {
+		return errors.New("proofs of references are not supported")
+	}
+
+	// the proof has to be about the record itself
+	if !bytes.Equal(ve.Entry.Key, []byte(r.Key)) || !bytes.Equal(ve.Entry.Value, []byte(r.Value)) || ve.Entry.Tx != r.Tx {
+		return errors.New("the proof is about another entry")
+	}
+
+	if r.Tx > trusted.TxID {
+		return fmt.Errorf("the record was committed in tx %d, after the trusted state at tx %d", r.Tx, trusted.TxID)
+	}
+
+	entrySpecDigest, err := store.EntrySpecDigestFor(int(ve.VerifiableTx.Tx.Header.Version))
+	if err != nil {
+		return err
+	}
+
+	dualProof := schema.DualProofFromProto(ve.VerifiableTx.DualProof)
+	if dualProof.SourceTxHeader == nil || dualProof.TargetTxHeader == nil {
+		return errors.New("incomplete proof")
+	}
+
+	// the record's tx is the source of the dual proof, the trusted state its target
+	if dualProof.SourceTxHeader.ID != r.Tx || dualProof.TargetTxHeader.ID != trusted.TxID {
+		return fmt.Errorf("the proof leads from tx %d to tx %d, not from tx %d to the trusted state at tx %d",
+			dualProof.SourceTxHeader.ID, dualProof.TargetTxHeader.ID, r.Tx, trusted.TxID)
+	}
+
+	e := database.EncodeEntrySpec(ve.Entry.Key, schema.KVMetadataFromProto(ve.Entry.Metadata), ve.Entry.Value)
+	if !store.VerifyInclusion(schema.InclusionProofFromProto(ve.InclusionProof), entrySpecDigest(e), dualProof.SourceTxHeader.Eh) {
+		return errors.New("the entry isn't included in its tx")
+	}
+
+	if len(trusted.TxHash) != sha256.Size {
+		return fmt.Errorf("the trusted tx hash must be %d bytes long", sha256.Size)
+	}
+
+	var trustedAlh [sha256.Size]byte
+	copy(trustedAlh[:], trusted.TxHash)
+
+	if !store.VerifyDualProof(dualProof, r.Tx, trusted.TxID, dualProof.SourceTxHeader.Alh(), trustedAlh) {
+		return errors.New("the tx doesn't lead to the trusted state")
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package archive
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/codenotary/immudb/pkg/api/schema"
+	"github.com/codenotary/immudb/pkg/database"
+	"github.com/codenotary/immudb/pkg/logger"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// provenRecords commits n entries, one per tx, and exports them along with the state their proofs lead to
+func provenRecords(t *testing.T, n int) ([]Record, State) {
+	db, err := database.NewDB("db", nil, database.DefaultOption().WithDBRootPath(t.TempDir()), logger.NewMemoryLogger())
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	for i := 1; i <= n; i++ {
+		_, err := db.Set(&schema.SetRequest{KVs: []*schema.KeyValue{{
+			Key:   []byte(fmt.Sprintf("b_%d", i)),
+			Value: []byte(fmt.Sprintf("#%d", i)),
+		}}})
+		require.NoError(t, err)
+	}
+
+	state, err := db.CurrentState()
+	require.NoError(t, err)
+
+	var records []Record
+	for i := 1; i <= n; i++ {
+		key := []byte(fmt.Sprintf("b_%d", i))
+		ve, err := db.VerifiableGet(&schema.VerifiableGetRequest{
+			KeyRequest:   &schema.KeyRequest{Key: key},
+			ProveSinceTx: state.TxId,
+		})
+		require.NoError(t, err)
+
+		proof, err := protojson.Marshal(ve)
+		require.NoError(t, err)
+
+		records = append(records, Record{Key: string(key), Tx: ve.Entry.Tx, Value: string(ve.Entry.Value), Proof: proof})
+	}
+
+	return records, State{Database: "db", TxID: state.TxId, TxHash: state.TxHash}
+}
+
+func TestVerifyRecord(t *testing.T) {
+	records, state := provenRecords(t, 5)
+
+	t.Run("valid", func(t *testing.T) {
+		for _, r := range records {
+			require.NoError(t, VerifyRecord(r, state), r.Key)
+		}
+	})
+
+	t.Run("tampered value", func(t *testing.T) {
+		r := records[1]
+		r.Value = "#9"
+		require.EqualError(t, VerifyRecord(r, state), "the proof is about another entry")
+	})
+
+	t.Run("tampered proof", func(t *testing.T) {
+		r := records[1]
+		var ve schema.VerifiableEntry
+		require.NoError(t, protojson.Unmarshal(r.Proof, &ve))
+		ve.Entry.Value = []byte(r.Value + "!")
+		r.Value += "!"
+		r.Proof, _ = protojson.Marshal(&ve)
+
+		require.EqualError(t, VerifyRecord(r, state), "the entry isn't included in its tx")
+	})
+
+	t.Run("untrusted state", func(t *testing.T) {
+		forged := state
+		forged.TxHash = make([]byte, len(state.TxHash))
+		require.EqualError(t, VerifyRecord(records[0], forged), "the tx doesn't lead to the trusted state")
+	})
+
+	t.Run("another state", func(t *testing.T) {
+		older := State{TxID: state.TxID - 1, TxHash: state.TxHash}
+		require.ErrorContains(t, VerifyRecord(records[0], older), "not from tx")
+		require.ErrorContains(t, VerifyRecord(records[len(records)-1], older), "after the trusted state")
+	})
+
+	t.Run("no proof", func(t *testing.T) {
+		require.ErrorIs(t, VerifyRecord(Record{Value: "#1"}, state), ErrNoProof)
+	})
+}
@@ -0,0 +1,186 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// recordsPerFile bounds the records kept in memory while writing a tar.gz archive
+const recordsPerFile = 10000
+
+// Writer writes the records of a single bucket, followed by the manifest written by Close
+type Writer interface {
+	Write(r Record) error
+	Close(state *State) (Manifest, error)
+}
+
+func NewWriter(format string, w io.Writer, bucket string) (Writer, error) {
+	m := manifestBuilder{
+		manifest: Manifest{Version: Version, Bucket: bucket, CreatedAt: time.Now().UTC()},
+		digest:   sha256.New(),
+	}
+
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonWriter{manifestBuilder: m, w: w}, nil
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{manifestBuilder: m, gz: gz, tw: tar.NewWriter(gz)}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive format %q", format)
+	}
+}
+
+type manifestBuilder struct {
+	manifest Manifest
+	digest   hash.Hash
+}
+
+// add encodes a record as a single line and accounts for it in the manifest
+func (m *manifestBuilder) add(r Record) ([]byte, error) {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	line = append(line, '\n')
+
+	m.digest.Write(line)
+	m.manifest.Count++
+	if r.Tx != 0 && (m.manifest.FirstTx == 0 || r.Tx < m.manifest.FirstTx) {
+		m.manifest.FirstTx = r.Tx
+	}
+	if r.Tx > m.manifest.LastTx {
+		m.manifest.LastTx = r.Tx
+	}
+
+	return line, nil
+}
+
+func (m *manifestBuilder) finish(state *State) Manifest {
+	m.manifest.SHA256 = hex.EncodeToString(m.digest.Sum(nil))
+	m.manifest.State = state
+	return m.manifest
+}
+
+// ndjsonWriter writes a record per line and the manifest as the last line, wrapped in {"manifest": ...}
+type ndjsonWriter struct {
+	manifestBuilder
+	w io.Writer
+}
+
+func (n *ndjsonWriter) Write(r Record) error {
+	line, err := n.add(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.w.Write(line)
+	return err
+}
+
+func (n *ndjsonWriter) Close(state *State) (Manifest, error) {
+	m := n.finish(state)
+
+	line, err := json.Marshal(map[string]any{"manifest": m})
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	_, err = n.w.Write(append(line, '\n'))
+	return m, err
+}
+
+// tarWriter writes the records in files of at most recordsPerFile records, followed by the manifest
+type tarWriter struct {
+	manifestBuilder
+	gz *gzip.Writer
+	tw *tar.Writer
+
+	buf   bytes.Buffer
+	count uint64
+}
+
+func (t *tarWriter) Write(r Record) error {
+	line, err := t.add(r)
+	if err != nil {
+		return err
+	}
+
+	t.buf.Write(line)
+	t.count++
+
+	if t.count >= recordsPerFile {
+		return t.flushFile()
+	}
+
+	return nil
+}
+
+func (t *tarWriter) flushFile() error {
+	if t.count == 0 {
+		return nil
+	}
+
+	sum := sha256.Sum256(t.buf.Bytes())
+	f := File{
+		Name:   fmt.Sprintf("entries-%06d.ndjson", len(t.manifest.Files)+1),
+		Count:  t.count,
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+
+	if err := t.writeFile(f.Name, t.buf.Bytes()); err != nil {
+		return err
+	}
+
+	t.manifest.Files = append(t.manifest.Files, f)
+	t.buf.Reset()
+	t.count = 0
+
+	return nil
+}
+
+func (t *tarWriter) writeFile(name string, data []byte) error {
+	err := t.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: t.manifest.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = t.tw.Write(data)
+	return err
+}
+
+func (t *tarWriter) Close(state *State) (Manifest, error) {
+	if err := t.flushFile(); err != nil {
+		return Manifest{}, err
+	}
+
+	m := t.finish(state)
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	if err := t.writeFile(ManifestFile, data); err != nil {
+		return Manifest{}, err
+	}
+
+	if err := t.tw.Close(); err != nil {
+		return Manifest{}, err
+	}
+
+	return m, t.gz.Close()
+}
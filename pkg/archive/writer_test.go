@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	records := []Record{
+		{Key: "b_1", Tx: 7, Value: "plain"},
+		{Key: "b_2", Tx: 5, Value: `{"level":"info"}`, Proof: json.RawMessage(`{"entry":{}}`)},
+	}
+	state := &State{Database: "defaultdb", TxID: 9, TxHash: []byte{0xca, 0xfe}}
+
+	wantLines := "{\"key\":\"b_1\",\"tx\":7,\"value\":\"plain\"}\n" +
+		"{\"key\":\"b_2\",\"tx\":5,\"value\":\"{\\\"level\\\":\\\"info\\\"}\",\"proof\":{\"entry\":{}}}\n"
+	sum := sha256.Sum256([]byte(wantLines))
+	wantSHA256 := hex.EncodeToString(sum[:])
+
+	write := func(t *testing.T, format string) ([]byte, Manifest) {
+		var buf bytes.Buffer
+		w, err := NewWriter(format, &buf, "b")
+		require.NoError(t, err)
+
+		for _, r := range records {
+			require.NoError(t, w.Write(r))
+		}
+
+		m, err := w.Close(state)
+		require.NoError(t, err)
+
+		require.Equal(t, Version, m.Version)
+		require.Equal(t, "b", m.Bucket)
+		require.Equal(t, uint64(2), m.Count)
+		require.Equal(t, uint64(5), m.FirstTx)
+		require.Equal(t, uint64(7), m.LastTx)
+		require.Equal(t, wantSHA256, m.SHA256)
+		require.Equal(t, state, m.State)
+
+		return buf.Bytes(), m
+	}
+
+	t.Run("ndjson", func(t *testing.T) {
+		data, m := write(t, FormatNDJSON)
+
+		lines := strings.SplitAfter(string(data), "\n")
+		require.Equal(t, wantLines, strings.Join(lines[:2], ""))
+
+		var trailer struct {
+			Manifest Manifest `json:"manifest"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(lines[2]), &trailer))
+		require.Equal(t, m.SHA256, trailer.Manifest.SHA256)
+		require.Empty(t, m.Files)
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		data, m := write(t, FormatTarGz)
+		require.Equal(t, []File{{Name: "entries-000001.ndjson", Count: 2, SHA256: wantSHA256}}, m.Files)
+
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		tr := tar.NewReader(gz)
+
+		files := map[string]string{}
+		var names []string
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			content, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			files[h.Name] = string(content)
+			names = append(names, h.Name)
+		}
+
+		require.Equal(t, []string{"entries-000001.ndjson", ManifestFile}, names)
+		require.Equal(t, wantLines, files["entries-000001.ndjson"])
+
+		var got Manifest
+		require.NoError(t, json.Unmarshal([]byte(files[ManifestFile]), &got))
+		require.Equal(t, m.Files, got.Files)
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		_, err := NewWriter("zip", io.Discard, "b")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/x509"
+)
+
+// CertificatePrincipal is the client presenting the certificate, already verified by the TLS handshake,
+// its grants being given by the rules matching any of the certificate attributes:
+// cn, o, ou, subject (the whole distinguished name, e.g. CN=billing,O=acme), dns and email
+func CertificatePrincipal(cert *x509.Certificate, rules []Rule) Principal {
+	attributes := map[string]any{
+		"cn":      cert.Subject.CommonName,
+		"o":       anyList(cert.Subject.Organization),
+		"ou":      anyList(cert.Subject.OrganizationalUnit),
+		"subject": cert.Subject.String(),
+		"dns":     anyList(cert.DNSNames),
+		"email":   anyList(cert.EmailAddresses),
+	}
+
+	p := Principal{ID: "cert:" + cert.Subject.String(), Name: cert.Subject.CommonName}
+	p.apply(rules, attributes)
+
+	return p
+}
+
+// anyList is a list of strings as decoded from JSON claims
+func anyList(values []string) []any {
+	items := make([]any, len(values))
+	for i, v := range values {
+		items[i] = v
+	}
+
+	return items
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificatePrincipal(t *testing.T) {
+	rules := []Rule{
+		{Claim: "ou", Value: "sre", Grant: Grant{Buckets: []string{"*"}, Permissions: []Permission{PermissionRead}}},
+		{Claim: "cn", Value: "billing-*", Tenant: "acme", Grant: Grant{Buckets: []string{"billing"}, Permissions: []Permission{PermissionWrite}}},
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing-worker", Organization: []string{"acme"}}}
+	p := CertificatePrincipal(cert, rules)
+	require.Equal(t, "cert:CN=billing-worker,O=acme", p.ID)
+	require.Equal(t, "billing-worker", p.Name)
+	require.Equal(t, "acme", p.Tenant)
+	require.True(t, p.Allows(PermissionWrite, "billing"))
+	require.False(t, p.Allows(PermissionRead, "billing"))
+
+	cert = &x509.Certificate{Subject: pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"dev", "sre"}}}
+	p = CertificatePrincipal(cert, rules)
+	require.Empty(t, p.Tenant)
+	require.True(t, p.Allows(PermissionRead, "billing"))
+	require.False(t, p.Allows(PermissionWrite, "billing"))
+}
@@ -0,0 +1,467 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// tokenLeeway tolerates clocks slightly off between the issuer and immulogsd
+const tokenLeeway = 30 * time.Second
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// VerificationKey is a public key tokens are signed with, ID matches the kid header of the tokens if set
+type VerificationKey struct {
+	ID  string
+	Key crypto.PublicKey
+}
+
+// Rule grants permissions on buckets to the subjects of tokens whose claim matches the value,
+// claims holding lists, e.g. groups, match if any of their items does, and the value may be a pattern as in Match,
+// the first matching rule naming a tenant binds the subject to it
+type Rule struct {
+	Claim  string `json:"claim"`
+	Value  string `json:"value"`
+	Tenant string `json:"tenant,omitempty"`
+	Grant
+}
+
+// TokenVerifier authenticates bearer JWTs issued by an identity provider, e.g. an OIDC one
+type TokenVerifier struct {
+	keys     []VerificationKey
+	issuer   string
+	audience string
+	rules    []Rule
+
+	now func() time.Time
+}
+
+// NewTokenVerifier accepts tokens signed with any of the keys, for the audience and, if set, from the issuer
+func NewTokenVerifier(keys []VerificationKey, issuer, audience string, rules []Rule) (*TokenVerifier, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("verifying tokens requires at least one key")
+	}
+	if audience == "" {
+		return nil, errors.New("verifying tokens requires an audience")
+	}
+
+	return &TokenVerifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		rules:    rules,
+		now:      time.Now,
+	}, nil
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature and the claims of the token and maps them to a principal according to the rules
+func (v *TokenVerifier) Verify(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Principal{}, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	if !v.verifySignature(header, []byte(parts[0]+"."+parts[1]), sig) {
+		return Principal{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	sub, _ := claims["sub"].(string)
+	p := Principal{ID: "jwt:" + sub, Name: sub}
+	p.apply(v.rules, claims)
+
+	return p, nil
+}
+
+func (v *TokenVerifier) verifySignature(header tokenHeader, signed, sig []byte) bool {
+	for _, k := range v.keys {
+		if header.Kid != "" && k.ID != "" && header.Kid != k.ID {
+			continue
+		}
+
+		if verifyJWS(header.Alg, k.Key, signed, sig) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *TokenVerifier) checkClaims(claims map[string]any) error {
+	now := v.now()
+
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return errors.New("no expiration")
+	}
+	if now.After(exp.Add(tokenLeeway)) {
+		return errors.New("expired")
+	}
+
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(tokenLeeway).Before(nbf) {
+		return errors.New("not valid yet")
+	}
+
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return errors.New("wrong issuer")
+	}
+
+	if !claimMatches(claims["aud"], v.audience) {
+		return errors.New("wrong audience")
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return errors.New("no subject")
+	}
+
+	return nil
+}
+
+// claimMatches tells whether the claim, or any of its items for lists, matches the pattern
+func claimMatches(claim any, pattern string) bool {
+	switch c := claim.(type) {
+	case string:
+		return Match(pattern, c)
+	case []any:
+		for _, item := range c {
+			if s, ok := item.(string); ok && Match(pattern, s) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func numericDate(v any) (time.Time, bool) {
+	n, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(n), 0), true
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// jwsHash is the hash of the RSA and ECDSA algorithms, the others aren't supported, "none" and HMAC in particular
+func jwsHash(alg string) (crypto.Hash, bool) {
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		return crypto.SHA256, true
+	case "384":
+		return crypto.SHA384, true
+	case "512":
+		return crypto.SHA512, true
+	default:
+		return 0, false
+	}
+}
+
+func verifyJWS(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	if alg == "EdDSA" {
+		k, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(k, signed, sig)
+	}
+
+	h, ok := jwsHash(alg)
+	if !ok {
+		return false
+	}
+	hasher := h.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		k, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(k, h, digest, sig) == nil
+	case strings.HasPrefix(alg, "PS"):
+		k, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPSS(k, h, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+	case strings.HasPrefix(alg, "ES"):
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 2*curveBytes(k.Curve) {
+			return false
+		}
+
+		r := new(big.Int).SetBytes(sig[:len(sig)/2])
+		s := new(big.Int).SetBytes(sig[len(sig)/2:])
+		return ecdsa.Verify(k, digest, r, s)
+	default:
+		return false
+	}
+}
+
+func curveBytes(c elliptic.Curve) int {
+	return (c.Params().BitSize + 7) / 8
+}
+
+// SignToken issues a token signed with an ed25519 (EdDSA), ECDSA (ES256, ES384 or ES512 according to the curve)
+// or RSA (RS256) key, e.g. to test the verification of tokens with locally generated keys
+func SignToken(key crypto.Signer, kid string, claims map[string]any) (string, error) {
+	var alg string
+	var h crypto.Hash
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		alg = "EdDSA"
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			alg, h = "ES256", crypto.SHA256
+		case elliptic.P384():
+			alg, h = "ES384", crypto.SHA384
+		case elliptic.P521():
+			alg, h = "ES512", crypto.SHA512
+		default:
+			return "", errors.New("unsupported curve")
+		}
+	case *rsa.PrivateKey:
+		alg, h = "RS256", crypto.SHA256
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := []byte(signed)
+	if h != 0 {
+		hasher := h.New()
+		hasher.Write(digest)
+		digest = hasher.Sum(nil)
+	}
+
+	sig, err := key.Sign(rand.Reader, digest, h)
+	if err != nil {
+		return "", err
+	}
+
+	// JWS wants ECDSA signatures as the fixed-size r and s rather than ASN.1
+	if k, ok := key.(*ecdsa.PrivateKey); ok {
+		r, s, err := parseASN1Signature(sig)
+		if err != nil {
+			return "", err
+		}
+
+		size := curveBytes(k.Curve)
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func parseASN1Signature(sig []byte) (*big.Int, *big.Int, error) {
+	var parsed struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sig, &parsed); err != nil {
+		return nil, nil, err
+	}
+
+	return parsed.R, parsed.S, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS reads the keys of a JSON Web Key Set file, as published by identity providers at their jwks_uri,
+// RSA, EC and Ed25519 keys are supported, encryption keys are skipped
+func LoadJWKS(path string) ([]VerificationKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var keys []VerificationKey
+	for i, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %d: %w", path, i+1, err)
+		}
+
+		keys = append(keys, VerificationKey{ID: k.Kid, Key: key})
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) ([]byte, error) {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("not a point of the curve")
+		}
+
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("malformed Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// LoadVerificationKey reads a PEM encoded public key, of any type supported for tokens
+func LoadVerificationKey(path string) (VerificationKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return VerificationKey{}, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return VerificationKey{}, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return VerificationKey{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return VerificationKey{Key: key}, nil
+}
+
+// LoadRules reads the rules mapping claims to buckets from a JSON file holding a list of them
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i, r := range rules {
+		if r.Claim == "" || len(r.Buckets) == 0 || len(r.Permissions) == 0 {
+			return nil, fmt.Errorf("%s: rule %d needs a claim, buckets and permissions", path, i+1)
+		}
+	}
+
+	return rules, nil
+}
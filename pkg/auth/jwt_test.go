@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenVerifier(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	// the EC and RSA keys are published in a JWKS, the ed25519 one as a static PEM key
+	jwksPath := filepath.Join(dir, "jwks.json")
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "-", "e": "-"},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(jwksPath, jwks, 0o600))
+
+	keys, err := LoadJWKS(jwksPath)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+
+	der, err := x509.MarshalPKIXPublicKey(edKey.Public())
+	require.NoError(t, err)
+	pemPath := filepath.Join(dir, "ed25519.pem")
+	require.NoError(t, os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	edVerificationKey, err := LoadVerificationKey(pemPath)
+	require.NoError(t, err)
+	keys = append(keys, edVerificationKey)
+
+	rulesPath := filepath.Join(dir, "rules.json")
+	require.NoError(t, os.WriteFile(rulesPath, []byte(`[
+		{"claim": "groups", "value": "sre", "buckets": ["*"], "permissions": ["read"]},
+		{"claim": "groups", "value": "team-*", "buckets": ["app"], "permissions": ["write"]},
+		{"claim": "sub", "value": "alice", "buckets": ["audit"], "permissions": ["read"]}
+	]`), 0o600))
+
+	rules, err := LoadRules(rulesPath)
+	require.NoError(t, err)
+
+	v, err := NewTokenVerifier(keys, "https://sso.example.com", "immulogs", rules)
+	require.NoError(t, err)
+
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	v.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":    "https://sso.example.com",
+			"aud":    []string{"immulogs", "grafana"},
+			"sub":    "alice",
+			"groups": []string{"team-billing"},
+			"exp":    now.Add(time.Hour).Unix(),
+		}
+		for k, val := range overrides {
+			if val == nil {
+				delete(c, k)
+			} else {
+				c[k] = val
+			}
+		}
+		return c
+	}
+
+	sign := func(t *testing.T, key crypto.Signer, kid string, c map[string]any) string {
+		token, err := SignToken(key, kid, c)
+		require.NoError(t, err)
+		return token
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		for name, token := range map[string]string{
+			"ES256": sign(t, ecKey, "ec", claims(nil)),
+			"RS256": sign(t, rsaKey, "rsa", claims(nil)),
+			"EdDSA": sign(t, edKey, "", claims(nil)),
+		} {
+			p, err := v.Verify(token)
+			require.NoError(t, err, name)
+			require.Equal(t, "jwt:alice", p.ID)
+			require.True(t, p.Allows(PermissionWrite, "app"))
+			require.True(t, p.Allows(PermissionRead, "audit"))
+			require.False(t, p.Allows(PermissionRead, "billing"))
+		}
+	})
+
+	t.Run("claims mapped to grants", func(t *testing.T) {
+		p, err := v.Verify(sign(t, ecKey, "ec", claims(map[string]any{"sub": "bob", "groups": []string{"sre"}})))
+		require.NoError(t, err)
+		require.True(t, p.Allows(PermissionRead, "billing"))
+		require.False(t, p.Allows(PermissionWrite, "app"))
+		require.False(t, p.Allows(PermissionAdmin, "app"))
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		_, otherKey, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+
+		for name, token := range map[string]string{
+			"expired":        sign(t, ecKey, "ec", claims(map[string]any{"exp": now.Add(-time.Minute).Unix()})),
+			"no expiration":  sign(t, ecKey, "ec", claims(map[string]any{"exp": nil})),
+			"not valid yet":  sign(t, ecKey, "ec", claims(map[string]any{"nbf": now.Add(time.Minute).Unix()})),
+			"wrong audience": sign(t, ecKey, "ec", claims(map[string]any{"aud": "grafana"})),
+			"wrong issuer":   sign(t, ecKey, "ec", claims(map[string]any{"iss": "https://evil.example.com"})),
+			"no subject":     sign(t, ecKey, "ec", claims(map[string]any{"sub": nil})),
+			"unknown key":    sign(t, otherKey, "", claims(nil)),
+			"wrong kid":      sign(t, ecKey, "rsa", claims(nil)),
+			"malformed":      "not.a.token",
+		} {
+			_, err := v.Verify(token)
+			require.ErrorIs(t, err, ErrInvalidToken, name)
+		}
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		header := b64([]byte(`{"alg":"none"}`))
+		payload, _ := json.Marshal(claims(nil))
+
+		_, err := v.Verify(header + "." + b64(payload) + ".")
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("within leeway", func(t *testing.T) {
+		_, err := v.Verify(sign(t, ecKey, "ec", claims(map[string]any{"exp": now.Add(-10 * time.Second).Unix()})))
+		require.NoError(t, err)
+	})
+}
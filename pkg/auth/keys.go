@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyPrefix starts every API key, which tells them apart from other bearer tokens
+const KeyPrefix = "imk_"
+
+var (
+	ErrUnauthenticated = errors.New("unknown API key")
+	ErrRevoked         = errors.New("revoked API key")
+	ErrNotFound        = errors.New("no such API key")
+)
+
+type Permission string
+
+const (
+	PermissionRead  Permission = "read"
+	PermissionWrite Permission = "write"
+	// PermissionAdmin allows importing archives and managing the keys of the buckets
+	PermissionAdmin Permission = "admin"
+)
+
+// Key is an API key as stored, only the hash of its secret is kept
+type Key struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Tenant      string       `json:"tenant,omitempty"`
+	Hash        string       `json:"hash,omitempty"`
+	Buckets     []string     `json:"buckets"`
+	Permissions []Permission `json:"permissions"`
+	CreatedAt   time.Time    `json:"created_at"`
+	RevokedAt   *time.Time   `json:"revoked_at,omitempty"`
+}
+
+// Principal is who a request is authenticated as, along with what it's allowed to do
+func (k Key) Principal() Principal {
+	return Principal{
+		ID:     k.ID,
+		Name:   k.Name,
+		Tenant: k.Tenant,
+		Grants: []Grant{{Buckets: k.Buckets, Permissions: k.Permissions}},
+	}
+}
+
+// Match matches a bucket name against a pattern in which * stands for any sequence of characters, slashes included
+// patterns themselves can be matched, as names, to tell whether a pattern is narrower than another one
+func Match(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
+
+// Store keeps the API keys, in a JSON file unless its path is empty
+type Store struct {
+	path string
+
+	mu   sync.RWMutex
+	keys []*Key
+}
+
+// NewStore loads the keys saved at path, if any
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Create adds a key and returns it along with its secret, which can't be recovered later on,
+// the key is bound to the tenant if set, or else allowed in all of them
+func (s *Store) Create(name, tenant string, buckets []string, permissions []Permission) (Key, string, error) {
+	if name == "" {
+		return Key{}, "", errors.New("a key needs a name")
+	}
+	if len(buckets) == 0 {
+		return Key{}, "", errors.New("a key needs at least one bucket pattern")
+	}
+	if len(permissions) == 0 {
+		return Key{}, "", errors.New("a key needs at least one permission")
+	}
+	for _, p := range permissions {
+		switch p {
+		case PermissionRead, PermissionWrite, PermissionAdmin:
+		default:
+			return Key{}, "", fmt.Errorf("unknown permission %q", p)
+		}
+	}
+
+	id, err := randomString(8)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		return Key{}, "", err
+	}
+	secret = KeyPrefix + secret
+
+	k := &Key{
+		ID:          id,
+		Name:        name,
+		Tenant:      tenant,
+		Hash:        hash(secret),
+		Buckets:     buckets,
+		Permissions: permissions,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append(s.keys, k)
+	if err := s.save(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return Key{}, "", err
+	}
+
+	return k.public(), secret, nil
+}
+
+// Revoke disables a key for good, it's kept for the record
+func (s *Store) Revoke(id string) (Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.keys {
+		if k.ID != id {
+			continue
+		}
+
+		if k.RevokedAt == nil {
+			now := time.Now().UTC().Truncate(time.Second)
+			k.RevokedAt = &now
+
+			if err := s.save(); err != nil {
+				k.RevokedAt = nil
+				return Key{}, err
+			}
+		}
+
+		return k.public(), nil
+	}
+
+	return Key{}, ErrNotFound
+}
+
+// Get returns the key with the given ID
+func (s *Store) Get(id string) (Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, k := range s.keys {
+		if k.ID == id {
+			return k.public(), nil
+		}
+	}
+
+	return Key{}, ErrNotFound
+}
+
+// Keys lists all the keys, revoked ones included, by creation time
+func (s *Store) Keys() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k.public())
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	return keys
+}
+
+// Authenticate returns the key the secret belongs to
+func (s *Store) Authenticate(secret string) (Key, error) {
+	h := hash(secret)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(h)) != 1 {
+			continue
+		}
+
+		if k.RevokedAt != nil {
+			return Key{}, ErrRevoked
+		}
+
+		return k.public(), nil
+	}
+
+	return Key{}, ErrUnauthenticated
+}
+
+// Bootstrap adds a key with all the permissions on all the buckets which is never saved, e.g. to create the first keys
+func (s *Store) Bootstrap(secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append(s.keys, &Key{
+		ID:          "bootstrap",
+		Name:        "bootstrap",
+		Hash:        hash(secret),
+		Buckets:     []string{"*"},
+		Permissions: []Permission{PermissionRead, PermissionWrite, PermissionAdmin},
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	})
+}
+
+// save writes the keys to a temporary file first so that they're never left half written, the caller holds the lock
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	var saved []*Key
+	for _, k := range s.keys {
+		if k.ID != "bootstrap" {
+			saved = append(saved, k)
+		}
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// public is a copy of the key without its hash
+func (k *Key) public() Key {
+	c := *k
+	c.Hash = ""
+	return c
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
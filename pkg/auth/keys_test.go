@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"app", "app", true},
+		{"app", "apps", false},
+		{"*", "", true},
+		{"*", "jobs/nightly", true},
+		{"jobs/*", "jobs/nightly", true},
+		{"jobs/*", "jobs", false},
+		{"app-*-prod", "app-billing-prod", true},
+		{"app-*-prod", "app-billing-dev", false},
+		{"*-prod", "billing-prod", true},
+		// patterns narrower than the pattern
+		{"jobs/*", "jobs/nightly-*", true},
+		{"jobs/nightly-*", "jobs/*", false},
+	} {
+		require.Equal(t, tt.want, Match(tt.pattern, tt.name), "%s %s", tt.pattern, tt.name)
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	k := Key{Buckets: []string{"jobs/*", "app"}, Permissions: []Permission{PermissionRead, PermissionAdmin}}.Principal()
+
+	require.True(t, k.Allows(PermissionRead, "jobs/nightly"))
+	require.True(t, k.Allows(PermissionRead, "app"))
+	require.False(t, k.Allows(PermissionRead, "billing"))
+	require.False(t, k.Allows(PermissionWrite, "app"))
+
+	require.True(t, k.Administers([]string{"jobs/nightly-*", "app"}))
+	require.False(t, k.Administers([]string{"*"}))
+	require.False(t, k.Administers(nil))
+
+	// grants don't add up
+	p := Principal{Grants: []Grant{
+		{Buckets: []string{"*"}, Permissions: []Permission{PermissionRead}},
+		{Buckets: []string{"app"}, Permissions: []Permission{PermissionWrite}},
+	}}
+	require.True(t, p.Allows(PermissionRead, "billing"))
+	require.True(t, p.Allows(PermissionWrite, "app"))
+	require.False(t, p.Allows(PermissionWrite, "billing"))
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	s, err := NewStore(path)
+	require.NoError(t, err)
+
+	k, secret, err := s.Create("ci", "", []string{"jobs/*"}, []Permission{PermissionWrite})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(secret, KeyPrefix))
+	require.Empty(t, k.Hash)
+
+	t.Run("authenticate", func(t *testing.T) {
+		got, err := s.Authenticate(secret)
+		require.NoError(t, err)
+		require.Equal(t, k, got)
+
+		_, err = s.Authenticate(secret + "x")
+		require.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, _, err := s.Create("", "", []string{"*"}, []Permission{PermissionRead})
+		require.Error(t, err)
+
+		_, _, err = s.Create("ci", "", nil, []Permission{PermissionRead})
+		require.Error(t, err)
+
+		_, _, err = s.Create("ci", "", []string{"*"}, []Permission{"delete"})
+		require.Error(t, err)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		reloaded, err := NewStore(path)
+		require.NoError(t, err)
+		require.Equal(t, s.Keys(), reloaded.Keys())
+
+		got, err := reloaded.Authenticate(secret)
+		require.NoError(t, err)
+		require.Equal(t, k.ID, got.ID)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		got, err := s.Revoke(k.ID)
+		require.NoError(t, err)
+		require.NotNil(t, got.RevokedAt)
+
+		_, err = s.Authenticate(secret)
+		require.ErrorIs(t, err, ErrRevoked)
+
+		reloaded, err := NewStore(path)
+		require.NoError(t, err)
+		_, err = reloaded.Authenticate(secret)
+		require.ErrorIs(t, err, ErrRevoked)
+
+		_, err = s.Revoke("nope")
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("bootstrap", func(t *testing.T) {
+		s.Bootstrap("imk_bootstrap")
+
+		got, err := s.Authenticate("imk_bootstrap")
+		require.NoError(t, err)
+		require.True(t, got.Principal().Administers([]string{"*"}))
+
+		// never saved
+		_, _, err = s.Create("another", "", []string{"*"}, []Permission{PermissionRead})
+		require.NoError(t, err)
+
+		reloaded, err := NewStore(path)
+		require.NoError(t, err)
+		_, err = reloaded.Authenticate("imk_bootstrap")
+		require.ErrorIs(t, err, ErrUnauthenticated)
+		require.Len(t, reloaded.Keys(), 2)
+	})
+}
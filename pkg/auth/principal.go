@@ -0,0 +1,81 @@
+package auth
+
+// Principal is an authenticated caller, an API key or the subject of a token
+type Principal struct {
+	ID   string
+	Name string
+	// Tenant the principal is bound to, it's allowed in all of them if empty
+	Tenant string
+	Grants []Grant
+}
+
+// Grant gives permissions on the buckets matching any of the patterns
+type Grant struct {
+	Buckets     []string     `json:"buckets"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Allows tells whether any grant gives the permission on the bucket
+func (p Principal) Allows(permission Permission, bucket string) bool {
+	for _, g := range p.Grants {
+		if g.Allows(permission, bucket) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Administers tells whether the principal has the admin permission on all the buckets matched by the patterns,
+// i.e. whether it may manage keys scoped to these patterns
+func (p Principal) Administers(patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		if !p.Allows(PermissionAdmin, pattern) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// apply adds the grants, and the tenant if any, of the rules matching the claims
+func (p *Principal) apply(rules []Rule, claims map[string]any) {
+	for _, r := range rules {
+		if !claimMatches(claims[r.Claim], r.Value) {
+			continue
+		}
+
+		p.Grants = append(p.Grants, r.Grant)
+		if p.Tenant == "" {
+			p.Tenant = r.Tenant
+		}
+	}
+}
+
+func (g Grant) Allows(permission Permission, bucket string) bool {
+	if !g.has(permission) {
+		return false
+	}
+
+	for _, pattern := range g.Buckets {
+		if Match(pattern, bucket) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g Grant) has(permission Permission) bool {
+	for _, granted := range g.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,191 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadInterval is how often at most the certificate files are checked for changes
+const reloadInterval = time.Second
+
+// ClientAuth tells whether a server asks its clients for a certificate
+type ClientAuth string
+
+const (
+	ClientAuthNone     ClientAuth = "none"
+	ClientAuthOptional ClientAuth = "optional"
+	ClientAuthRequire  ClientAuth = "require"
+)
+
+// Reloader serves a certificate and its key from files, reloading them once they change on disk,
+// e.g. when renewed by cert-manager, without restarting the listener or the connection
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+
+	now func() time.Time
+}
+
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		now:      time.Now,
+	}
+
+	modTime, err := r.lastModified()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Certificate is the current certificate, a certificate failing to load is logged and the previous one kept
+func (r *Reloader) Certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.checked) < reloadInterval {
+		return r.cert, nil
+	}
+	r.checked = now
+
+	modTime, err := r.lastModified()
+	if err == nil && !modTime.Equal(r.modTime) {
+		err = r.load(modTime)
+	}
+	if err != nil {
+		stdlog.Printf("reloading certificate %s: %v", r.certFile, err)
+	}
+
+	return r.cert, nil
+}
+
+// GetCertificate is meant for tls.Config of servers
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
+
+// GetClientCertificate is meant for tls.Config of clients
+func (r *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate()
+}
+
+func (r *Reloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// lastModified is the most recent modification time of the certificate and key files
+func (r *Reloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+
+	return last, nil
+}
+
+// NewServerConfig serves the certificate, reloaded on change, and verifies the certificates of the clients
+// against the CAs of clientCAFile unless clientAuth is none
+func NewServerConfig(certFile, keyFile, clientCAFile string, clientAuth ClientAuth) (*tls.Config, error) {
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+
+	switch clientAuth {
+	case ClientAuthNone, "":
+		return cfg, nil
+	case ClientAuthOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client auth %q, expected none, optional or require", clientAuth)
+	}
+
+	if clientCAFile == "" {
+		return nil, errors.New("verifying client certificates requires a CA")
+	}
+	if cfg.ClientCAs, err = LoadCertPool(clientCAFile); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// NewClientConfig verifies the server against the CAs of caFile, or the system ones if empty,
+// and presents the certificate, reloaded on change, if set
+func NewClientConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if caFile != "" {
+		var err error
+		if cfg.RootCAs, err = LoadCertPool(caFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		r, err := NewReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = r.GetClientCertificate
+	}
+
+	return cfg, nil
+}
+
+// LoadCertPool reads the PEM certificates of the file
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,169 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	stdlog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "immulogs test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	ca.write(t, "ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+// issue writes the certificate and key of cn to name.pem and name-key.pem
+func (ca *testCA) issue(t *testing.T, name, cn string, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return ca.write(t, name+".pem", "CERTIFICATE", der), ca.write(t, name+"-key.pem", "PRIVATE KEY", keyDER)
+}
+
+func (ca *testCA) write(t *testing.T, name, typ string, der []byte) string {
+	path := filepath.Join(ca.dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+	return path
+}
+
+func TestReloader(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, "server", "first", x509.ExtKeyUsageServerAuth)
+
+	r, err := NewReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	commonName := func() string {
+		cert, err := r.Certificate()
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+	require.Equal(t, "first", commonName())
+
+	// renewed, with a later modification time whatever the resolution of the filesystem
+	ca.issue(t, "server", "second", x509.ExtKeyUsageServerAuth)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+
+	require.Equal(t, "first", commonName(), "checked at most once per interval")
+	now = now.Add(reloadInterval)
+	require.Equal(t, "second", commonName())
+
+	// a broken renewal keeps the previous certificate
+	require.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0o600))
+	now = now.Add(reloadInterval)
+	require.Equal(t, "second", commonName())
+
+	_, err = NewReloader(certFile, keyFile)
+	require.Error(t, err)
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "server", "immulogsd", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, "client", "billing", x509.ExtKeyUsageClientAuth)
+	caFile := filepath.Join(ca.dir, "ca.pem")
+
+	serverCfg, err := NewServerConfig(serverCert, serverKey, caFile, ClientAuthRequire)
+	require.NoError(t, err)
+
+	// httptest.Server would replace the certificate with its own
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}), ErrorLog: stdlog.New(io.Discard, "", 0)}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	get := func(cfg *tls.Config) (string, error) {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := c.Get("https://" + l.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	cfg, err := NewClientConfig(caFile, clientCert, clientKey, "")
+	require.NoError(t, err)
+	cn, err := get(cfg)
+	require.NoError(t, err)
+	require.Equal(t, "billing", cn)
+
+	cfg, err = NewClientConfig(caFile, "", "", "")
+	require.NoError(t, err)
+	_, err = get(cfg)
+	require.Error(t, err, "no client certificate")
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewServerConfig(serverCert, serverKey, "", ClientAuthRequire)
+		require.Error(t, err)
+
+		_, err = NewServerConfig(serverCert, serverKey, caFile, "sometimes")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,134 @@
+package checkpoint
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/archive"
+)
+
+var ErrBadSignature = errors.New("the checkpoint signature doesn't match")
+
+// Checkpoint is the size of a bucket at an immudb state, signed with an ed25519 key,
+// so that the state can be trusted without access to immudb and later rewrites of history stand out
+type Checkpoint struct {
+	Bucket    string    `json:"bucket"`
+	Count     uint64    `json:"count"`
+	Database  string    `json:"database"`
+	TxID      uint64    `json:"tx_id"`
+	TxHash    []byte    `json:"tx_hash"`
+	CreatedAt time.Time `json:"created_at"`
+	Signature []byte    `json:"signature"`
+}
+
+func New(bucket string, count uint64, state archive.State, createdAt time.Time) Checkpoint {
+	return Checkpoint{
+		Bucket:    bucket,
+		Count:     count,
+		Database:  state.Database,
+		TxID:      state.TxID,
+		TxHash:    state.TxHash,
+		CreatedAt: createdAt.UTC().Truncate(time.Second),
+	}
+}
+
+func (c Checkpoint) State() archive.State {
+	return archive.State{Database: c.Database, TxID: c.TxID, TxHash: c.TxHash}
+}
+
+// payload is what's signed, each field on its own line
+func (c Checkpoint) payload() []byte {
+	return []byte(strings.Join([]string{
+		"immulogs-checkpoint-v1",
+		c.Bucket,
+		strconv.FormatUint(c.Count, 10),
+		c.Database,
+		strconv.FormatUint(c.TxID, 10),
+		hex.EncodeToString(c.TxHash),
+		c.CreatedAt.UTC().Format(time.RFC3339),
+	}, "\n"))
+}
+
+func (c *Checkpoint) Sign(key ed25519.PrivateKey) {
+	c.Signature = ed25519.Sign(key, c.payload())
+}
+
+func (c Checkpoint) Verify(key ed25519.PublicKey) error {
+	if !ed25519.Verify(key, c.payload(), c.Signature) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+// LoadPublicKey reads a PEM encoded ed25519 public key, as written by openssl pkey -pubout
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 public key", path)
+	}
+
+	return pub, nil
+}
+
+// LoadPrivateKey reads a PEM encoded PKCS #8 ed25519 private key, as written by openssl genpkey -algorithm ed25519
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 private key", path)
+	}
+
+	return priv, nil
+}
+
+// EncodePublicKey encodes a public key the way LoadPublicKey reads it
+func EncodePublicKey(key ed25519.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	return block, nil
+}
@@ -0,0 +1,71 @@
+package checkpoint
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/archive"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	state := archive.State{Database: "defaultdb", TxID: 42, TxHash: []byte{0xca, 0xfe}}
+	c := New("my-bucket-name", 7, state, time.Date(2023, 1, 2, 3, 4, 5, 6, time.UTC))
+	c.Sign(priv)
+
+	require.Equal(t, state, c.State())
+	require.NoError(t, c.Verify(pub))
+
+	t.Run("tampered", func(t *testing.T) {
+		forged := c
+		forged.TxID++
+		require.ErrorIs(t, forged.Verify(pub), ErrBadSignature)
+
+		forged = c
+		forged.Count--
+		require.ErrorIs(t, forged.Verify(pub), ErrBadSignature)
+	})
+
+	t.Run("another key", func(t *testing.T) {
+		other, _, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		require.ErrorIs(t, c.Verify(other), ErrBadSignature)
+	})
+
+	t.Run("load keys", func(t *testing.T) {
+		encoded, err := EncodePublicKey(pub)
+		require.NoError(t, err)
+
+		path := filepath.Join(t.TempDir(), "key.pub")
+		require.NoError(t, os.WriteFile(path, []byte(encoded), 0o600))
+
+		got, err := LoadPublicKey(path)
+		require.NoError(t, err)
+		require.Equal(t, pub, got)
+
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		require.NoError(t, err)
+
+		privPath := filepath.Join(t.TempDir(), "key")
+		require.NoError(t, os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+		gotPriv, err := LoadPrivateKey(privPath)
+		require.NoError(t, err)
+		require.Equal(t, priv, gotPriv)
+
+		_, err = LoadPrivateKey(path)
+		require.Error(t, err)
+
+		require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o600))
+		_, err = LoadPublicKey(path)
+		require.ErrorContains(t, err, "no PEM data")
+	})
+}
@@ -0,0 +1,552 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/signing"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	defaultBackoff = 200 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+)
+
+// Client talks to the REST API of immulogsd
+// An empty bucket name addresses the global (bucket-less) endpoints
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+
+	retries int
+	backoff time.Duration
+
+	producer string
+	key      ed25519.PrivateKey
+
+	apiKey string
+	token  string
+	tenant string
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(c *http.Client) Option {
+	return func(client *Client) {
+		client.httpClient = c
+	}
+}
+
+// WithRetries retries failed requests up to n times, waiting backoff and then twice as long after every attempt
+// Only network errors and temporary server errors (5xx, 429, 408) are retried
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(client *Client) {
+		client.retries = n
+		client.backoff = backoff
+	}
+}
+
+// WithAPIKey authenticates every request with the API key
+func WithAPIKey(key string) Option {
+	return func(client *Client) {
+		client.apiKey = key
+	}
+}
+
+// WithToken authenticates every request with a bearer token, e.g. a JWT issued by an SSO
+func WithToken(token string) Option {
+	return func(client *Client) {
+		client.token = token
+	}
+}
+
+// WithTenant makes every request within the tenant
+func WithTenant(name string) Option {
+	return func(client *Client) {
+		client.tenant = name
+	}
+}
+
+// WithSigner signs every entry written with Add, Batch or a Writer on behalf of the producer
+func WithSigner(producer string, key ed25519.PrivateKey) Option {
+	return func(client *Client) {
+		client.producer = producer
+		client.key = key
+	}
+}
+
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("malformed base URL %q", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		backoff:    defaultBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// Add stores a single entry
+func (c *Client) Add(ctx context.Context, bucket string, e log.Entry) (map[string]any, error) {
+	e, err := c.sign(e)
+	if err != nil {
+		return nil, err
+	}
+
+	var res map[string]any
+	err = c.do(ctx, http.MethodPost, c.url(bucket, "add"), "text/plain", e.Bytes(), &res)
+	return res, err
+}
+
+// Batch stores all the entries at once, structured entries keep their fields
+func (c *Client) Batch(ctx context.Context, bucket string, entries []log.Entry) (map[string]any, error) {
+	if c.key != nil {
+		signed := make([]log.Entry, 0, len(entries))
+		for _, e := range entries {
+			e, err := c.sign(e)
+			if err != nil {
+				return nil, err
+			}
+			signed = append(signed, e)
+		}
+		entries = signed
+	}
+
+	body, err := EncodeNDJSON(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var res map[string]any
+	err = c.do(ctx, http.MethodPost, c.url(bucket, "batch"), "application/x-ndjson", body, &res)
+	return res, err
+}
+
+// BatchRaw stores an already encoded batch body, contentType is one of the formats accepted by /batch
+func (c *Client) BatchRaw(ctx context.Context, bucket string, contentType string, body []byte) (map[string]any, error) {
+	var res map[string]any
+	err := c.do(ctx, http.MethodPost, c.url(bucket, "batch"), contentType, body, &res)
+	return res, err
+}
+
+// StreamSummary is the outcome of Stream
+type StreamSummary struct {
+	Written uint64 `json:"written"`
+	Failed  uint64 `json:"failed"`
+	FirstTx any    `json:"first_tx,omitempty"`
+	LastTx  any    `json:"last_tx,omitempty"`
+}
+
+// Stream uploads an arbitrarily large body which the server commits in chunks of at most chunk entries (0 for the default)
+// contentType is one of the formats accepted by /batch, the body is never retried as it can't be replayed
+func (c *Client) Stream(ctx context.Context, bucket string, body io.Reader, contentType string, chunk int) (StreamSummary, error) {
+	u := c.url(bucket, "stream")
+	if chunk > 0 {
+		u += "?chunk=" + strconv.Itoa(chunk)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return StreamSummary{}, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	// streams are long by design, the client-wide timeout doesn't apply
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+
+	var summary StreamSummary
+	err = c.send(&httpClient, req, &summary)
+	return summary, err
+}
+
+type ImportOptions struct {
+	// NDJSON reads the body as plain entries, the way Batch sends them, rather than as an archive made by Export
+	NDJSON bool
+	// Skip skips the leading entries, e.g. the ones committed by an interrupted import
+	Skip uint64
+	// Chunk is the number of entries committed at once, 0 for the server's default
+	Chunk int
+}
+
+// ImportSummary is the outcome of Import
+type ImportSummary struct {
+	Written uint64 `json:"written"`
+	Failed  uint64 `json:"failed"`
+	Skipped uint64 `json:"skipped"`
+	FirstTx any    `json:"first_tx,omitempty"`
+	LastTx  any    `json:"last_tx,omitempty"`
+	// Complete is set once an archive turned out to match its manifest
+	Complete bool `json:"complete"`
+}
+
+// Import replays an archive into a bucket, the summary tells how many entries were committed even if it fails
+func (c *Client) Import(ctx context.Context, bucket string, body io.Reader, opts ImportOptions) (ImportSummary, error) {
+	query := url.Values{"skip": {strconv.FormatUint(opts.Skip, 10)}}
+	if opts.NDJSON {
+		query.Set("format", "ndjson")
+	}
+	if opts.Chunk > 0 {
+		query.Set("chunk", strconv.Itoa(opts.Chunk))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(bucket, "import")+"?"+query.Encode(), body)
+	if err != nil {
+		return ImportSummary{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	// imports are long by design, the client-wide timeout doesn't apply
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+
+	resp, err := c.roundTrip(&httpClient, req)
+	if err != nil {
+		return ImportSummary{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ImportSummary{}, err
+	}
+
+	var summary ImportSummary
+	_ = json.Unmarshal(data, &summary)
+
+	if resp.StatusCode >= 300 {
+		return summary, decodeError(resp.StatusCode, resp.Header, data)
+	}
+
+	return summary, nil
+}
+
+// Last returns the last n entries, or all of them if n isn't positive
+func (c *Client) Last(ctx context.Context, bucket string, n int64) ([]log.Entry, error) {
+	var res struct {
+		Entries []json.RawMessage `json:"entries"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.url(bucket, "last", strconv.FormatInt(n, 10)), "", nil, &res); err != nil {
+		return nil, err
+	}
+
+	return decodeEntries(res.Entries)
+}
+
+// All returns all the entries
+func (c *Client) All(ctx context.Context, bucket string) ([]log.Entry, error) {
+	return c.Last(ctx, bucket, -1)
+}
+
+// Count returns the number of entries
+func (c *Client) Count(ctx context.Context, bucket string) (uint64, error) {
+	var res struct {
+		Count uint64 `json:"count"`
+	}
+	err := c.do(ctx, http.MethodGet, c.url(bucket, "count"), "", nil, &res)
+	return res.Count, err
+}
+
+// Search returns up to limit most recent entries containing q, case-insensitively (0 for the server's default limit)
+func (c *Client) Search(ctx context.Context, bucket string, q string, limit int) ([]log.Entry, error) {
+	query := url.Values{"q": {q}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var res struct {
+		Entries []json.RawMessage `json:"entries"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.url(bucket, "search")+"?"+query.Encode(), "", nil, &res); err != nil {
+		return nil, err
+	}
+
+	return decodeEntries(res.Entries)
+}
+
+// Buckets lists the names of all the buckets
+func (c *Client) Buckets(ctx context.Context) ([]string, error) {
+	var res struct {
+		Buckets []string `json:"buckets"`
+	}
+	err := c.do(ctx, http.MethodGet, c.url("", "buckets"), "", nil, &res)
+	return res.Buckets, err
+}
+
+// VerifyResult is the outcome of Verify
+type VerifyResult struct {
+	Verified int      `json:"verified"`
+	Failed   []string `json:"failed"`
+}
+
+// Verify asks the server to verify the proofs of all the entries of a bucket
+func (c *Client) Verify(ctx context.Context, bucket string) (VerifyResult, error) {
+	var res VerifyResult
+	err := c.do(ctx, http.MethodGet, c.url(bucket, "verify"), "", nil, &res)
+	return res, err
+}
+
+// Shred destroys the encryption keys of a bucket, which makes its entries unreadable for good,
+// it returns the number of keys destroyed
+func (c *Client) Shred(ctx context.Context, bucket string) (int, error) {
+	var res struct {
+		Shredded int `json:"shredded"`
+	}
+	err := c.do(ctx, http.MethodPost, c.url(bucket, "shred"), "", nil, &res)
+	return res.Shredded, err
+}
+
+// Key is an API key, without its secret
+type Key struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Tenant      string     `json:"tenant,omitempty"`
+	Buckets     []string   `json:"buckets"`
+	Permissions []string   `json:"permissions"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateKey creates an API key allowed to use the buckets matching the patterns, and returns it along with its secret,
+// the key is bound to the tenant of the client if set
+func (c *Client) CreateKey(ctx context.Context, name string, buckets []string, permissions []string) (Key, string, error) {
+	body, err := json.Marshal(map[string]any{"name": name, "buckets": buckets, "permissions": permissions})
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	var res struct {
+		Key    Key    `json:"key"`
+		Secret string `json:"secret"`
+	}
+	err = c.do(ctx, http.MethodPost, c.url("", "keys"), "application/json", body, &res)
+	return res.Key, res.Secret, err
+}
+
+// Keys lists the API keys the client's key administers
+func (c *Client) Keys(ctx context.Context) ([]Key, error) {
+	var res struct {
+		Keys []Key `json:"keys"`
+	}
+	err := c.do(ctx, http.MethodGet, c.url("", "keys"), "", nil, &res)
+	return res.Keys, err
+}
+
+// RevokeKey disables an API key for good
+func (c *Client) RevokeKey(ctx context.Context, id string) (Key, error) {
+	var res struct {
+		Key Key `json:"key"`
+	}
+	err := c.do(ctx, http.MethodDelete, c.url("", "keys", url.PathEscape(id)), "", nil, &res)
+	return res.Key, err
+}
+
+// Export streams the archive of a bucket in the given format, "ndjson" or "tar.gz", which the caller has to close
+// the archive is complete only if it ends with its manifest
+func (c *Client) Export(ctx context.Context, bucket string, format string) (io.ReadCloser, error) {
+	return c.ExportAt(ctx, bucket, format, 0)
+}
+
+// ExportAt is Export with the proofs leading to the tx, e.g. the one of a checkpoint, rather than to the current state
+func (c *Client) ExportAt(ctx context.Context, bucket string, format string, tx uint64) (io.ReadCloser, error) {
+	query := url.Values{"format": {format}}
+	if tx != 0 {
+		query.Set("tx", strconv.FormatUint(tx, 10))
+	}
+	u := c.url(bucket, "export") + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// exports are long by design, the client-wide timeout doesn't apply
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+
+	resp, err := c.roundTrip(&httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, decodeError(resp.StatusCode, resp.Header, body)
+	}
+
+	return resp.Body, nil
+}
+
+func (c *Client) sign(e log.Entry) (log.Entry, error) {
+	if c.key == nil {
+		return e, nil
+	}
+
+	return signing.Sign(e, c.producer, c.key)
+}
+
+func (c *Client) url(bucket string, path ...string) string {
+	if bucket != "" {
+		// bucket names may contain slashes, e.g. jobs/nightly
+		path = append([]string{url.PathEscape(bucket)}, path...)
+	}
+
+	return c.baseURL.JoinPath(path...).String()
+}
+
+// do sends a request with a replayable body, retrying it if allowed
+func (c *Client) do(ctx context.Context, method, u, contentType string, body []byte, res any) error {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		err = c.send(c.httpClient, req, res)
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			return err
+		}
+
+		wait := backoff
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func (c *Client) roundTrip(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-Immulogs-Tenant", c.tenant)
+	}
+
+	return httpClient.Do(req)
+}
+
+func (c *Client) send(httpClient *http.Client, req *http.Request, res any) error {
+	resp, err := c.roundTrip(httpClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		return decodeError(resp.StatusCode, resp.Header, body)
+	}
+
+	if res == nil || len(body) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(body, res)
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+
+	// network errors
+	return true
+}
+
+// EncodeNDJSON encodes entries the way /batch expects them with the application/x-ndjson content type
+func EncodeNDJSON(entries []log.Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	for _, e := range entries {
+		var v any = e.String()
+		if s, ok := e.(log.Structured); ok {
+			v = s.Fields()
+		}
+
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeEntries(raw []json.RawMessage) ([]log.Entry, error) {
+	entries := make([]log.Entry, 0, len(raw))
+	for _, r := range raw {
+		var v any
+		dec := json.NewDecoder(bytes.NewReader(r))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		switch v := v.(type) {
+		case string:
+			entries = append(entries, log.FromString(v))
+		case map[string]any:
+			entries = append(entries, log.FromFields(v))
+		default:
+			return nil, fmt.Errorf("unexpected entry %s", r)
+		}
+	}
+
+	return entries, nil
+}
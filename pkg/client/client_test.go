@@ -0,0 +1,280 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/client/clienttest"
+	"github.com/lootek/go-immulogs/pkg/quota"
+	"github.com/lootek/go-immulogs/pkg/service"
+	"github.com/lootek/go-immulogs/pkg/signing"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+
+	srv := clienttest.NewServer()
+	defer srv.Close()
+
+	c, err := New(srv.URL, WithRetries(2, time.Millisecond))
+	require.NoError(t, err)
+
+	t.Run("count empty", func(t *testing.T) {
+		got, err := c.Count(ctx, "my-bucket-name")
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), got)
+	})
+
+	t.Run("add one", func(t *testing.T) {
+		got, err := c.Add(ctx, "my-bucket-name", log.FromString("a sample log entry"))
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"written": 1.}, got)
+	})
+
+	t.Run("add batch", func(t *testing.T) {
+		got, err := c.Batch(ctx, "my-bucket-name", []log.Entry{
+			log.FromString("a sample log entry #1"),
+			log.FromFields(map[string]any{"level": "info", "msg": "<structured>"}),
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"written": 2.}, got)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		got, err := c.Stream(ctx, "my-bucket-name", strings.NewReader("#1\n#2\n#3\n"), "text/plain", 2)
+		require.NoError(t, err)
+		require.Equal(t, StreamSummary{Written: 3}, got)
+	})
+
+	t.Run("count all by now", func(t *testing.T) {
+		got, err := c.Count(ctx, "my-bucket-name")
+		require.NoError(t, err)
+		require.Equal(t, uint64(6), got)
+	})
+
+	t.Run("get last 4", func(t *testing.T) {
+		got, err := c.Last(ctx, "my-bucket-name", 4)
+		require.NoError(t, err)
+		require.Equal(t, []log.Entry{
+			log.FromFields(map[string]any{"level": "info", "msg": "<structured>"}),
+			log.FromString("#1"),
+			log.FromString("#2"),
+			log.FromString("#3"),
+		}, got)
+	})
+
+	t.Run("get all", func(t *testing.T) {
+		got, err := c.All(ctx, "my-bucket-name")
+		require.NoError(t, err)
+		require.Len(t, got, 6)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		got, err := c.Search(ctx, "my-bucket-name", "#", 2)
+		require.NoError(t, err)
+		require.Equal(t, []log.Entry{log.FromString("#2"), log.FromString("#3")}, got)
+	})
+
+	t.Run("buckets", func(t *testing.T) {
+		got, err := c.Buckets(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"my-bucket-name"}, got)
+	})
+
+	t.Run("bucket with slashes", func(t *testing.T) {
+		_, err := c.Add(ctx, "jobs/nightly", log.FromString("a nightly job"))
+		require.NoError(t, err)
+
+		got, err := c.Last(ctx, "jobs/nightly", 1)
+		require.NoError(t, err)
+		require.Equal(t, []log.Entry{log.FromString("a nightly job")}, got)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		body, err := c.Export(ctx, "jobs/nightly", "ndjson")
+		require.NoError(t, err)
+		defer body.Close()
+
+		data, err := io.ReadAll(body)
+		require.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		require.Len(t, lines, 2)
+		require.Equal(t, `{"value":"a nightly job"}`, lines[0])
+		require.Contains(t, lines[1], `"manifest":{"version":1,"bucket":"jobs/nightly"`)
+
+		_, err = c.Export(ctx, "jobs/nightly", "zip")
+		var apiErr *Error
+		require.True(t, errors.As(err, &apiErr))
+		require.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	})
+
+	t.Run("import", func(t *testing.T) {
+		body, err := c.Export(ctx, "jobs/nightly", "tar.gz")
+		require.NoError(t, err)
+		defer body.Close()
+
+		got, err := c.Import(ctx, "jobs/restored", body, ImportOptions{})
+		require.NoError(t, err)
+		require.Equal(t, ImportSummary{Written: 1, Complete: true}, got)
+
+		entries, err := c.All(ctx, "jobs/restored")
+		require.NoError(t, err)
+		require.Equal(t, []log.Entry{log.FromString("a nightly job")}, entries)
+	})
+
+	t.Run("import incomplete", func(t *testing.T) {
+		got, err := c.Import(ctx, "jobs/restored", strings.NewReader("{\"value\":\"#2\"}\n"), ImportOptions{})
+		var apiErr *Error
+		require.True(t, errors.As(err, &apiErr))
+		require.Equal(t, http.StatusUnprocessableEntity, apiErr.StatusCode)
+		require.Equal(t, ImportSummary{Written: 1}, got)
+	})
+
+	t.Run("verify unsupported", func(t *testing.T) {
+		_, err := c.Verify(ctx, "my-bucket-name")
+		var apiErr *Error
+		require.True(t, errors.As(err, &apiErr))
+		require.Equal(t, http.StatusNotImplemented, apiErr.StatusCode)
+	})
+
+	t.Run("retry temporary errors", func(t *testing.T) {
+		srv.FailNext(2, http.StatusServiceUnavailable, `{"error":"storage unavailable"}`)
+		before := len(srv.Requests())
+
+		got, err := c.Count(ctx, "my-bucket-name")
+		require.NoError(t, err)
+		require.Equal(t, uint64(6), got)
+		require.Len(t, srv.Requests(), before+3)
+	})
+
+	t.Run("give up after retries", func(t *testing.T) {
+		srv.FailNext(3, http.StatusServiceUnavailable, `{"error":"storage unavailable"}`)
+
+		_, err := c.Count(ctx, "my-bucket-name")
+		var apiErr *Error
+		require.True(t, errors.As(err, &apiErr))
+		require.Equal(t, &Error{StatusCode: http.StatusServiceUnavailable, Message: "storage unavailable"}, apiErr)
+		require.True(t, apiErr.Temporary())
+	})
+
+	t.Run("typed errors are not retried", func(t *testing.T) {
+		before := len(srv.Requests())
+
+		_, err := c.Stream(ctx, "my-bucket-name", strings.NewReader("\"ok\"\n[]\n"), "application/x-ndjson", 0)
+		var apiErr *Error
+		require.True(t, errors.As(err, &apiErr))
+		require.Equal(t, &Error{
+			StatusCode: http.StatusBadRequest,
+			Message:    "line 2: an entry must be a string or an object, got an array",
+			Line:       2,
+		}, apiErr)
+		require.False(t, apiErr.Temporary())
+		require.Len(t, srv.Requests(), before+1)
+	})
+
+	t.Run("retry after quota exceeded", func(t *testing.T) {
+		q := quota.New(quota.Config{Buckets: []quota.BucketLimits{{Bucket: "*", Limits: quota.Limits{EntriesPerSecond: 1}}}}, nil)
+		srv := clienttest.NewServer(service.WithQuotas(q))
+		defer srv.Close()
+
+		limited, err := New(srv.URL, WithRetries(1, time.Millisecond))
+		require.NoError(t, err)
+
+		_, err = limited.Add(ctx, "app", log.FromString("#1"))
+		require.NoError(t, err)
+
+		// waits as told by the server rather than the backoff
+		start := time.Now()
+		_, err = limited.Add(ctx, "app", log.FromString("#2"))
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+
+		signer, err := New(srv.URL, WithSigner("billing", priv))
+		require.NoError(t, err)
+
+		_, err = signer.Add(ctx, "signed", log.FromString("#1"))
+		require.NoError(t, err)
+		_, err = signer.Batch(ctx, "signed", []log.Entry{log.FromFields(map[string]any{"amount": 12.5})})
+		require.NoError(t, err)
+
+		got, err := c.All(ctx, "signed")
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+
+		registry := signing.Registry{"billing": pub}
+		for _, e := range got {
+			require.Equal(t, signing.StatusValid, registry.Verify(e).Status)
+		}
+	})
+
+	t.Run("api keys", func(t *testing.T) {
+		store, err := auth.NewStore("")
+		require.NoError(t, err)
+		store.Bootstrap("imk_admin")
+
+		srv := clienttest.NewServer(service.WithAuth(store, bucket.NewBucket("audit")))
+		defer srv.Close()
+
+		admin, err := New(srv.URL, WithAPIKey("imk_admin"))
+		require.NoError(t, err)
+
+		key, secret, err := admin.CreateKey(ctx, "ci", []string{"jobs/*"}, []string{"read", "write"})
+		require.NoError(t, err)
+		require.Equal(t, "ci", key.Name)
+
+		ci, err := New(srv.URL, WithAPIKey(secret))
+		require.NoError(t, err)
+
+		_, err = ci.Add(ctx, "jobs/nightly", log.FromString("#1"))
+		require.NoError(t, err)
+
+		_, err = ci.Add(ctx, "app", log.FromString("#1"))
+		var apiErr *Error
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+
+		keys, err := admin.Keys(ctx)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+
+		revoked, err := admin.RevokeKey(ctx, key.ID)
+		require.NoError(t, err)
+		require.NotNil(t, revoked.RevokedAt)
+
+		_, err = ci.Count(ctx, "jobs/nightly")
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	})
+
+	t.Run("invalid base URL", func(t *testing.T) {
+		_, err := New("localhost:8000")
+		require.Error(t, err)
+	})
+}
+
+func TestEncodeNDJSON(t *testing.T) {
+	got, err := EncodeNDJSON([]log.Entry{
+		log.FromString("plain <text>"),
+		log.FromFields(map[string]any{"n": json.Number("1")}),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "\"plain <text>\"\n{\"n\":1}\n", string(got))
+}
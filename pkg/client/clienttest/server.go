@@ -0,0 +1,73 @@
+package clienttest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/lootek/go-immulogs/pkg/service"
+	"github.com/lootek/go-immulogs/pkg/storage"
+)
+
+// Server is an immulogsd REST API backed by in-memory storage, meant for testing clients
+// Close it once done, like an httptest.Server
+type Server struct {
+	*httptest.Server
+	Storage *storage.Memory
+
+	mu       sync.Mutex
+	failures []failure
+	requests []*http.Request
+}
+
+type failure struct {
+	status int
+	body   string
+}
+
+// the options enable optional features of the API, e.g. service.WithAuth
+func NewServer(opts ...service.RESTOption) *Server {
+	s := &Server{Storage: storage.NewMemory()}
+	handler := service.NewREST(s.Storage, "", 0, opts...).Handler()
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+
+		var f *failure
+		if len(s.failures) > 0 {
+			f = &s.failures[0]
+			s.failures = s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		if f != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(f.status)
+			_, _ = w.Write([]byte(f.body))
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+
+	return s
+}
+
+// FailNext makes the next n requests fail with the given status and JSON body, without reaching the storage
+func (s *Server) FailNext(n int, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status, body})
+	}
+}
+
+// Requests returns all the requests received so far, including the failed ones
+func (s *Server) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*http.Request(nil), s.requests...)
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error is an error response of immulogsd
+type Error struct {
+	StatusCode int
+	Message    string
+
+	// Line and Entry point at the offending part of a rejected batch, if known
+	Line  int
+	Entry int
+
+	// RetryAfter is how long to wait before retrying, as told by the Retry-After header of a rejected write
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("immulogsd: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("immulogsd: %d %s", e.StatusCode, e.Message)
+}
+
+// Temporary tells whether the request may succeed when retried
+func (e *Error) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout
+}
+
+func decodeError(statusCode int, header http.Header, body []byte) *Error {
+	e := &Error{StatusCode: statusCode}
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	var res struct {
+		Error string `json:"error"`
+		Line  int    `json:"line"`
+		Entry int    `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		e.Message = string(body)
+		return e
+	}
+
+	e.Message, e.Line, e.Entry = res.Error, res.Line, res.Entry
+	return e
+}
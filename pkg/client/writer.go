@@ -0,0 +1,225 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+var (
+	ErrWriterClosed = errors.New("writer is closed")
+	ErrBufferFull   = errors.New("buffer is full")
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	defaultBufferSize    = 10000
+)
+
+type WriterOptions struct {
+	// BatchSize is the number of entries sent at once, a full batch is sent right away
+	BatchSize int
+	// FlushInterval is how long entries may wait for a batch to fill up
+	FlushInterval time.Duration
+	// BufferSize is the number of entries waiting to be sent
+	BufferSize int
+	// DropWhenFull drops new entries instead of blocking writers while the buffer is full
+	DropWhenFull bool
+	// OnError is called with every batch that couldn't be sent, after the client's retries
+	OnError func(err error, entries []log.Entry)
+}
+
+// WriterStats are the counters of a Writer
+type WriterStats struct {
+	Sent    uint64
+	Failed  uint64
+	Dropped uint64
+}
+
+// Writer buffers entries and sends them asynchronously in batches to /:bucket/batch
+// Every Write call is a single entry, which makes Writer suitable as the output of line-oriented loggers
+type Writer struct {
+	client *Client
+	bucket string
+	opts   WriterOptions
+
+	mu      sync.RWMutex
+	closed  bool
+	entries chan log.Entry
+	flushCh chan chan struct{}
+	done    chan struct{}
+
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func (c *Client) NewWriter(bucket string, opts WriterOptions) *Writer {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = defaultBatchSize
+	}
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+
+	if opts.BufferSize < 1 {
+		opts.BufferSize = defaultBufferSize
+	}
+
+	w := &Writer{
+		client:  c,
+		bucket:  bucket,
+		opts:    opts,
+		entries: make(chan log.Entry, opts.BufferSize),
+		flushCh: make(chan chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+// Write queues p, stripped of its trailing newline, as a single entry
+func (w *Writer) Write(p []byte) (int, error) {
+	line := bytes.TrimSuffix(p, []byte{'\n'})
+	if err := w.WriteEntry(log.FromBytes(append([]byte(nil), line...))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// WriteEntry queues an entry, blocking while the buffer is full unless DropWhenFull is set
+func (w *Writer) WriteEntry(e log.Entry) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	if !w.opts.DropWhenFull {
+		w.entries <- e
+		return nil
+	}
+
+	select {
+	case w.entries <- e:
+		return nil
+	default:
+		w.dropped.Add(1)
+		return ErrBufferFull
+	}
+}
+
+// Flush sends all the entries queued so far and waits until they're sent or ctx is done
+func (w *Writer) Flush(ctx context.Context) error {
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return ErrWriterClosed
+	}
+
+	flushed := make(chan struct{})
+	select {
+	case w.flushCh <- flushed:
+	case <-ctx.Done():
+		w.mu.RUnlock()
+		return ctx.Err()
+	}
+	w.mu.RUnlock()
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close sends the remaining entries and stops the writer
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+
+	w.closed = true
+	close(w.entries)
+	w.mu.Unlock()
+
+	<-w.done
+	return nil
+}
+
+func (w *Writer) Stats() WriterStats {
+	return WriterStats{
+		Sent:    w.sent.Load(),
+		Failed:  w.failed.Load(),
+		Dropped: w.dropped.Load(),
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]log.Entry, 0, w.opts.BatchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if _, err := w.client.Batch(context.Background(), w.bucket, batch); err != nil {
+			w.failed.Add(uint64(len(batch)))
+			if w.opts.OnError != nil {
+				w.opts.OnError(err, batch)
+			}
+		} else {
+			w.sent.Add(uint64(len(batch)))
+		}
+
+		batch = make([]log.Entry, 0, w.opts.BatchSize)
+	}
+
+	for {
+		select {
+		case e, ok := <-w.entries:
+			if !ok {
+				send()
+				return
+			}
+
+			batch = append(batch, e)
+			if len(batch) >= w.opts.BatchSize {
+				send()
+			}
+
+		case <-ticker.C:
+			send()
+
+		case flushed := <-w.flushCh:
+			// whatever was queued before the flush request is sent along
+			for pending := len(w.entries); pending > 0; pending-- {
+				batch = append(batch, <-w.entries)
+				if len(batch) >= w.opts.BatchSize {
+					send()
+				}
+			}
+
+			send()
+			close(flushed)
+		}
+	}
+}
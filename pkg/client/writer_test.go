@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/client/clienttest"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+
+	setup := func(t *testing.T) (*clienttest.Server, *Client) {
+		srv := clienttest.NewServer()
+		t.Cleanup(srv.Close)
+
+		c, err := New(srv.URL)
+		require.NoError(t, err)
+
+		return srv, c
+	}
+
+	stored := func(t *testing.T, srv *clienttest.Server) []log.Entry {
+		entries, err := srv.Storage.Last(bucket.NewBucket("my-bucket-name"), 1000)
+		require.NoError(t, err)
+		return entries
+	}
+
+	t.Run("flush by size", func(t *testing.T) {
+		srv, c := setup(t)
+		w := c.NewWriter("my-bucket-name", WriterOptions{BatchSize: 2, FlushInterval: time.Hour})
+		defer w.Close()
+
+		for i := 1; i <= 5; i++ {
+			require.NoError(t, w.WriteEntry(log.FromString(fmt.Sprintf("#%d", i))))
+		}
+
+		require.Eventually(t, func() bool { return w.Stats().Sent == 4 }, 5*time.Second, time.Millisecond)
+		require.Len(t, srv.Requests(), 2)
+
+		require.NoError(t, w.Close())
+		require.Equal(t, WriterStats{Sent: 5}, w.Stats())
+		require.Equal(t, []log.Entry{
+			log.FromString("#1"), log.FromString("#2"), log.FromString("#3"), log.FromString("#4"), log.FromString("#5"),
+		}, stored(t, srv))
+	})
+
+	t.Run("flush by interval", func(t *testing.T) {
+		srv, c := setup(t)
+		w := c.NewWriter("my-bucket-name", WriterOptions{BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+		defer w.Close()
+
+		require.NoError(t, w.WriteEntry(log.FromString("#1")))
+		require.Eventually(t, func() bool { return w.Stats().Sent == 1 }, 5*time.Second, time.Millisecond)
+		require.Equal(t, []log.Entry{log.FromString("#1")}, stored(t, srv))
+	})
+
+	t.Run("explicit flush", func(t *testing.T) {
+		srv, c := setup(t)
+		w := c.NewWriter("my-bucket-name", WriterOptions{BatchSize: 100, FlushInterval: time.Hour})
+		defer w.Close()
+
+		l := stdlog.New(w, "", 0)
+		l.Println("line #1")
+		l.Println("line #2")
+
+		require.NoError(t, w.Flush(ctx))
+		require.Equal(t, []log.Entry{log.FromString("line #1"), log.FromString("line #2")}, stored(t, srv))
+	})
+
+	t.Run("drop when full", func(t *testing.T) {
+		// the writer goroutine is kept busy by a slow server, so the buffer fills up
+		slow := make(chan struct{})
+		srv := clienttest.NewServer()
+		defer srv.Close()
+		blocking := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			<-slow
+			return http.DefaultTransport.RoundTrip(r)
+		})}
+		c, err := New(srv.URL, WithHTTPClient(blocking))
+		require.NoError(t, err)
+
+		w := c.NewWriter("my-bucket-name", WriterOptions{BatchSize: 1, BufferSize: 1, DropWhenFull: true, FlushInterval: time.Hour})
+
+		require.NoError(t, w.WriteEntry(log.FromString("#1")))
+		require.Eventually(t, func() bool { return len(w.entries) == 0 }, 5*time.Second, time.Millisecond)
+		require.NoError(t, w.WriteEntry(log.FromString("#2")))
+		require.ErrorIs(t, w.WriteEntry(log.FromString("#3")), ErrBufferFull)
+
+		close(slow)
+		require.NoError(t, w.Close())
+		require.Equal(t, WriterStats{Sent: 2, Dropped: 1}, w.Stats())
+	})
+
+	t.Run("report failures", func(t *testing.T) {
+		srv, c := setup(t)
+		srv.FailNext(1, http.StatusInternalServerError, `{"error":"storage unavailable"}`)
+
+		var mu sync.Mutex
+		var failed []log.Entry
+		w := c.NewWriter("my-bucket-name", WriterOptions{BatchSize: 2, OnError: func(err error, entries []log.Entry) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			var apiErr *Error
+			require.True(t, errors.As(err, &apiErr))
+			failed = append(failed, entries...)
+		}})
+
+		require.NoError(t, w.WriteEntry(log.FromString("#1")))
+		require.NoError(t, w.WriteEntry(log.FromString("#2")))
+		require.NoError(t, w.WriteEntry(log.FromString("#3")))
+		require.NoError(t, w.Close())
+
+		require.Equal(t, WriterStats{Sent: 1, Failed: 2}, w.Stats())
+		require.Equal(t, []log.Entry{log.FromString("#1"), log.FromString("#2")}, failed)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		_, c := setup(t)
+		w := c.NewWriter("my-bucket-name", WriterOptions{})
+		require.NoError(t, w.Close())
+		require.NoError(t, w.Close())
+
+		_, err := w.Write([]byte("late"))
+		require.ErrorIs(t, err, ErrWriterClosed)
+		require.ErrorIs(t, w.Flush(ctx), ErrWriterClosed)
+	})
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
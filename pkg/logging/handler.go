@@ -0,0 +1,201 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/client"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// Handler is a slog.Handler shipping records as structured entries through a batching client.Writer
+// Attributes become fields and groups become nested objects
+type Handler struct {
+	w    *client.Writer
+	opts slog.HandlerOptions
+
+	// goas are the groups and attributes added with WithGroup and WithAttrs, in order
+	goas []groupOrAttrs
+}
+
+type groupOrAttrs struct {
+	group string
+	attrs []slog.Attr
+}
+
+func NewHandler(w *client.Writer, opts *slog.HandlerOptions) *Handler {
+	h := &Handler{w: w}
+	if opts != nil {
+		h.opts = *opts
+	}
+
+	return h
+}
+
+// NewLogger is a shorthand for a logger shipping to the given bucket of immulogsd at serverURL
+// The returned writer has to be closed before exiting, so that buffered entries are sent
+func NewLogger(serverURL, bucket string, opts *slog.HandlerOptions) (*slog.Logger, *client.Writer, error) {
+	c, err := client.New(serverURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w := c.NewWriter(bucket, client.WriterOptions{})
+	return slog.New(NewHandler(w, opts)), w, nil
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+
+	return level >= minLevel
+}
+
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	fields := map[string]any{}
+
+	if !r.Time.IsZero() {
+		fields[log.TimestampKey] = r.Time.UTC().Format(time.RFC3339Nano)
+	}
+	fields[log.LevelKey] = LevelName(r.Level)
+	fields[log.MessageKey] = r.Message
+
+	if h.opts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		fields[slog.SourceKey] = fmt.Sprintf("%s:%d", frame.File, frame.Line)
+	}
+
+	goas := h.goas
+	if r.NumAttrs() == 0 {
+		// groups with no attributes are omitted
+		for len(goas) > 0 && goas[len(goas)-1].group != "" {
+			goas = goas[:len(goas)-1]
+		}
+	}
+
+	var groups []string
+	for _, goa := range goas {
+		if goa.group != "" {
+			groups = append(groups, goa.group)
+			continue
+		}
+
+		for _, a := range goa.attrs {
+			h.addAttr(fields, groups, a)
+		}
+	}
+
+	r.Attrs(func(a slog.Attr) bool {
+		h.addAttr(fields, groups, a)
+		return true
+	})
+
+	return h.w.WriteEntry(log.FromFields(fields))
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	return h.with(groupOrAttrs{attrs: attrs})
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return h.with(groupOrAttrs{group: name})
+}
+
+func (h *Handler) with(goa groupOrAttrs) *Handler {
+	h2 := *h
+	h2.goas = make([]groupOrAttrs, len(h.goas)+1)
+	copy(h2.goas, h.goas)
+	h2.goas[len(h2.goas)-1] = goa
+
+	return &h2
+}
+
+// addAttr sets a in the object nested under groups, creating the objects on demand
+func (h *Handler) addAttr(fields map[string]any, groups []string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+
+	if a.Value.Kind() != slog.KindGroup && h.opts.ReplaceAttr != nil {
+		a = h.opts.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return
+		}
+
+		// an unnamed group is inlined
+		if a.Key != "" {
+			groups = append(groups[:len(groups):len(groups)], a.Key)
+		}
+
+		for _, ga := range attrs {
+			h.addAttr(fields, groups, ga)
+		}
+		return
+	}
+
+	target := fields
+	for _, g := range groups {
+		nested, ok := target[g].(map[string]any)
+		if !ok {
+			nested = map[string]any{}
+			target[g] = nested
+		}
+		target = nested
+	}
+
+	target[a.Key] = value(a.Value)
+}
+
+func value(v slog.Value) any {
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindAny:
+		switch a := v.Any().(type) {
+		case error:
+			return a.Error()
+		case fmt.Stringer:
+			return a.String()
+		default:
+			return a
+		}
+	default:
+		return v.Any()
+	}
+}
+
+// LevelName maps slog levels onto the severity names used by structured entries
+func LevelName(l slog.Level) string {
+	switch {
+	case l < slog.LevelInfo:
+		return "debug"
+	case l < slog.LevelWarn:
+		return "info"
+	case l < slog.LevelError:
+		return "warning"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/client"
+	"github.com/lootek/go-immulogs/pkg/client/clienttest"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+
+	srv := clienttest.NewServer()
+	defer srv.Close()
+
+	c, err := client.New(srv.URL)
+	require.NoError(t, err)
+
+	shipped := func(t *testing.T, fn func(l *slog.Logger), opts *slog.HandlerOptions) []map[string]any {
+		b := strings.ReplaceAll(t.Name(), "/", "-")
+		w := c.NewWriter(b, client.WriterOptions{})
+		fn(slog.New(NewHandler(w, opts)))
+		require.NoError(t, w.Close())
+
+		entries, err := srv.Storage.Last(bucket.NewBucket(b), 100)
+		require.NoError(t, err)
+
+		var got []map[string]any
+		for _, e := range entries {
+			var fields map[string]any
+			require.NoError(t, json.Unmarshal(e.Bytes(), &fields))
+			require.NotEmpty(t, fields["timestamp"])
+			delete(fields, "timestamp")
+			got = append(got, fields)
+		}
+
+		return got
+	}
+
+	t.Run("levels", func(t *testing.T) {
+		got := shipped(t, func(l *slog.Logger) {
+			l.Debug("filtered out")
+			l.Info("info")
+			l.Warn("warn")
+			l.Log(ctx, slog.LevelError+4, "error+4")
+		}, nil)
+
+		require.Equal(t, []map[string]any{
+			{"level": "info", "message": "info"},
+			{"level": "warning", "message": "warn"},
+			{"level": "error", "message": "error+4"},
+		}, got)
+	})
+
+	t.Run("attributes and groups", func(t *testing.T) {
+		got := shipped(t, func(l *slog.Logger) {
+			l = l.With("service", "api").WithGroup("request").With("id", 42)
+			l.Info("handled",
+				slog.String("path", "/a"),
+				slog.Group("response", slog.Int("status", 200), slog.Duration("took", time.Second)),
+				slog.Any("err", errors.New("boom")),
+			)
+			l.WithGroup("empty").Info("no attrs")
+		}, &slog.HandlerOptions{Level: slog.LevelDebug})
+
+		require.Equal(t, []map[string]any{
+			{
+				"level":   "info",
+				"message": "handled",
+				"service": "api",
+				"request": map[string]any{
+					"id":       42.,
+					"path":     "/a",
+					"response": map[string]any{"status": 200., "took": "1s"},
+					"err":      "boom",
+				},
+			},
+			{
+				"level":   "info",
+				"message": "no attrs",
+				"service": "api",
+				"request": map[string]any{"id": 42.},
+			},
+		}, got)
+	})
+
+	t.Run("replace attributes", func(t *testing.T) {
+		got := shipped(t, func(l *slog.Logger) {
+			l.Info("login", "user", "alice", "password", "secret")
+		}, &slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == "password" {
+				return slog.Attr{}
+			}
+			return a
+		}})
+
+		require.Equal(t, []map[string]any{
+			{"level": "info", "message": "login", "user": "alice"},
+		}, got)
+	})
+}
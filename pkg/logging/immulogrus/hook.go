@@ -0,0 +1,64 @@
+package immulogrus
+
+import (
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/client"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/sirupsen/logrus"
+)
+
+// Hook is a logrus.Hook shipping entries as structured immulogs entries through a batching client.Writer
+type Hook struct {
+	w      *client.Writer
+	levels []logrus.Level
+}
+
+// NewHook fires for the given levels, or all of them if none are given
+func NewHook(w *client.Writer, levels ...logrus.Level) *Hook {
+	if len(levels) == 0 {
+		levels = logrus.AllLevels
+	}
+
+	return &Hook{w: w, levels: levels}
+}
+
+func (h *Hook) Levels() []logrus.Level {
+	return h.levels
+}
+
+func (h *Hook) Fire(e *logrus.Entry) error {
+	fields := make(map[string]any, len(e.Data)+3)
+	for k, v := range e.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		fields[k] = v
+	}
+
+	fields[log.TimestampKey] = e.Time.UTC().Format(time.RFC3339Nano)
+	fields[log.LevelKey] = LevelName(e.Level)
+	fields[log.MessageKey] = e.Message
+
+	if e.HasCaller() {
+		fields["caller"] = e.Caller.Function
+	}
+
+	return h.w.WriteEntry(log.FromFields(fields))
+}
+
+// LevelName maps logrus levels onto the severity names used by structured entries
+func LevelName(l logrus.Level) string {
+	switch l {
+	case logrus.TraceLevel, logrus.DebugLevel:
+		return "debug"
+	case logrus.InfoLevel:
+		return "info"
+	case logrus.WarnLevel:
+		return "warning"
+	case logrus.ErrorLevel:
+		return "error"
+	default:
+		return "critical"
+	}
+}
@@ -0,0 +1,52 @@
+package immulogrus
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/lootek/go-immulogs/pkg/client"
+	"github.com/lootek/go-immulogs/pkg/client/clienttest"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHook(t *testing.T) {
+	srv := clienttest.NewServer()
+	defer srv.Close()
+
+	c, err := client.New(srv.URL)
+	require.NoError(t, err)
+
+	w := c.NewWriter("my-bucket-name", client.WriterOptions{})
+
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	l.AddHook(NewHook(w, logrus.InfoLevel, logrus.WarnLevel, logrus.ErrorLevel))
+
+	l.Debug("filtered out")
+	l.WithField("status", 200).Info("handled")
+	l.Warn("slow")
+	l.WithError(errors.New("boom")).Error("failed")
+	require.NoError(t, w.Close())
+
+	entries, err := srv.Storage.Last(bucket.NewBucket("my-bucket-name"), 100)
+	require.NoError(t, err)
+
+	var got []map[string]any
+	for _, e := range entries {
+		var fields map[string]any
+		require.NoError(t, json.Unmarshal(e.Bytes(), &fields))
+		require.NotEmpty(t, fields["timestamp"])
+		delete(fields, "timestamp")
+		got = append(got, fields)
+	}
+
+	require.Equal(t, []map[string]any{
+		{"level": "info", "message": "handled", "status": 200.},
+		{"level": "warning", "message": "slow"},
+		{"level": "error", "message": "failed", "error": "boom"},
+	}, got)
+}
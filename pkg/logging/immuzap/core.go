@@ -0,0 +1,86 @@
+package immuzap
+
+import (
+	"context"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/client"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"go.uber.org/zap/zapcore"
+)
+
+// Core is a zapcore.Core shipping entries as structured immulogs entries through a batching client.Writer
+// Combine it with other cores using zapcore.NewTee to keep the local output
+type Core struct {
+	zapcore.LevelEnabler
+
+	w      *client.Writer
+	fields []zapcore.Field
+}
+
+func NewCore(w *client.Writer, enab zapcore.LevelEnabler) *Core {
+	return &Core{LevelEnabler: enab, w: w}
+}
+
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	c2 := *c
+	c2.fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	return &c2
+}
+
+func (c *Core) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+
+	return ce
+}
+
+func (c *Core) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	entry := enc.Fields
+	entry[log.TimestampKey] = e.Time.UTC().Format(time.RFC3339Nano)
+	entry[log.LevelKey] = LevelName(e.Level)
+	entry[log.MessageKey] = e.Message
+
+	if e.LoggerName != "" {
+		entry["logger"] = e.LoggerName
+	}
+
+	if e.Caller.Defined {
+		entry["caller"] = e.Caller.String()
+	}
+
+	if e.Stack != "" {
+		entry["stacktrace"] = e.Stack
+	}
+
+	return c.w.WriteEntry(log.FromFields(entry))
+}
+
+func (c *Core) Sync() error {
+	return c.w.Flush(context.Background())
+}
+
+// LevelName maps zap levels onto the severity names used by structured entries
+func LevelName(l zapcore.Level) string {
+	switch l {
+	case zapcore.DebugLevel:
+		return "debug"
+	case zapcore.InfoLevel:
+		return "info"
+	case zapcore.WarnLevel:
+		return "warning"
+	case zapcore.ErrorLevel:
+		return "error"
+	default:
+		return "critical"
+	}
+}
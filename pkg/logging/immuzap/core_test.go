@@ -0,0 +1,50 @@
+package immuzap
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/lootek/go-immulogs/pkg/client"
+	"github.com/lootek/go-immulogs/pkg/client/clienttest"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCore(t *testing.T) {
+	srv := clienttest.NewServer()
+	defer srv.Close()
+
+	c, err := client.New(srv.URL)
+	require.NoError(t, err)
+
+	w := c.NewWriter("my-bucket-name", client.WriterOptions{})
+	l := zap.New(NewCore(w, zapcore.InfoLevel)).Named("api").With(zap.String("service", "api"))
+
+	l.Debug("filtered out")
+	l.Info("handled", zap.Int("status", 200), zap.Namespace("request"), zap.String("path", "/a"))
+	l.Warn("slow")
+	l.Error("failed", zap.Error(errors.New("boom")))
+	require.NoError(t, l.Sync())
+	require.NoError(t, w.Close())
+
+	entries, err := srv.Storage.Last(bucket.NewBucket("my-bucket-name"), 100)
+	require.NoError(t, err)
+
+	var got []map[string]any
+	for _, e := range entries {
+		var fields map[string]any
+		require.NoError(t, json.Unmarshal(e.Bytes(), &fields))
+		require.NotEmpty(t, fields["timestamp"])
+		delete(fields, "timestamp")
+		got = append(got, fields)
+	}
+
+	require.Equal(t, []map[string]any{
+		{"level": "info", "message": "handled", "logger": "api", "service": "api", "status": 200., "request": map[string]any{"path": "/a"}},
+		{"level": "warning", "message": "slow", "logger": "api", "service": "api"},
+		{"level": "error", "message": "failed", "logger": "api", "service": "api", "error": "boom"},
+	}, got)
+}
@@ -0,0 +1,62 @@
+package multiline
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Joiner folds continuation lines, e.g. the frames of a stack trace, into the entry they follow
+type Joiner struct {
+	continuation *regexp.Regexp
+	maxLines     int
+
+	pending []string
+}
+
+// New creates a Joiner treating lines that match pattern as continuations, an empty pattern disables joining
+// An entry is cut after maxLines lines, unless maxLines isn't positive
+func New(pattern string, maxLines int) (*Joiner, error) {
+	j := &Joiner{maxLines: maxLines}
+
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		j.continuation = re
+	}
+
+	return j, nil
+}
+
+// Add consumes a line and returns the entry it completes, if any
+func (j *Joiner) Add(line string) (string, bool) {
+	if j.continuation != nil && len(j.pending) > 0 && j.continuation.MatchString(line) &&
+		(j.maxLines <= 0 || len(j.pending) < j.maxLines) {
+		j.pending = append(j.pending, line)
+		return "", false
+	}
+
+	entry, ok := j.Flush()
+	j.pending = append(j.pending, line)
+
+	return entry, ok
+}
+
+// Flush returns the pending entry, if any
+func (j *Joiner) Flush() (string, bool) {
+	if len(j.pending) == 0 {
+		return "", false
+	}
+
+	entry := strings.Join(j.pending, "\n")
+	j.pending = j.pending[:0]
+
+	return entry, true
+}
+
+// Pending tells whether there's an entry waiting for its continuation lines
+func (j *Joiner) Pending() bool {
+	return len(j.pending) > 0
+}
@@ -0,0 +1,78 @@
+package multiline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoiner(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		maxLines int
+		lines    []string
+		want     []string
+	}{
+		{
+			name:  "disabled",
+			lines: []string{"first", "  second"},
+			want:  []string{"first", "  second"},
+		},
+		{
+			name:    "stack trace",
+			pattern: `^\s`,
+			lines: []string{
+				"starting",
+				"Exception in thread \"main\" java.lang.IllegalStateException",
+				"\tat com.example.Main.run(Main.java:10)",
+				"\tat com.example.Main.main(Main.java:5)",
+				"done",
+			},
+			want: []string{
+				"starting",
+				"Exception in thread \"main\" java.lang.IllegalStateException\n\tat com.example.Main.run(Main.java:10)\n\tat com.example.Main.main(Main.java:5)",
+				"done",
+			},
+		},
+		{
+			name:    "leading continuation",
+			pattern: `^\s`,
+			lines:   []string{"  orphan", "next"},
+			want:    []string{"  orphan", "next"},
+		},
+		{
+			name:     "max lines",
+			pattern:  `^\s`,
+			maxLines: 2,
+			lines:    []string{"head", " 1", " 2", " 3"},
+			want:     []string{"head\n 1", " 2\n 3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := New(tt.pattern, tt.maxLines)
+			require.NoError(t, err)
+
+			var got []string
+			for _, line := range tt.lines {
+				if entry, ok := j.Add(line); ok {
+					got = append(got, entry)
+				}
+			}
+
+			require.True(t, j.Pending())
+			entry, ok := j.Flush()
+			require.True(t, ok)
+			got = append(got, entry)
+
+			require.False(t, j.Pending())
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		_, err := New("(", 0)
+		require.Error(t, err)
+	})
+}
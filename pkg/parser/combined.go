@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// combinedTimeLayout is the layout of the timestamps of access logs, e.g. 10/Oct/2000:13:55:36 -0700
+const combinedTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+var combinedRegex = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\S+)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+// Combined parses the access logs of Apache and Nginx in the combined format, or the common one
+// which lacks the referer and the user agent
+type Combined struct{}
+
+func (Combined) Parse(line string) (log.Entry, error) {
+	m := combinedRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, errors.New("not an access log line")
+	}
+
+	ts, err := time.Parse(combinedTimeLayout, m[4])
+	if err != nil {
+		return nil, err
+	}
+
+	status, _ := strconv.Atoi(m[6])
+	fields := map[string]any{
+		"remote_addr":    m[1],
+		"request":        m[5],
+		"status":         status,
+		log.TimestampKey: ts.Format(time.RFC3339),
+	}
+	for key, value := range map[string]string{"ident": m[2], "remote_user": m[3], "referer": m[8], "user_agent": m[9]} {
+		if value != "" && value != "-" {
+			fields[key] = value
+		}
+	}
+
+	if bytes, err := strconv.ParseInt(m[7], 10, 64); err == nil {
+		fields["bytes"] = bytes
+	}
+
+	// malformed requests are logged as they are, e.g. "-" for a connection closed early
+	if parts := strings.Split(m[5], " "); len(parts) == 3 {
+		fields["method"], fields["path"], fields["protocol"] = parts[0], parts[1], parts[2]
+	}
+
+	return log.FromFields(fields), nil
+}
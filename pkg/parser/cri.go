@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// CRI parses the lines of the container logs written by Kubernetes runtimes, e.g. containerd or CRI-O:
+// "<RFC 3339 timestamp> <stdout|stderr> <F|P> <message>", P marking a partial line continued by the next one
+type CRI struct{}
+
+func (CRI) Parse(line string) (log.Entry, error) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 {
+		return nil, errors.New("not a CRI log line")
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if parts[1] != "stdout" && parts[1] != "stderr" {
+		return nil, errors.New("unknown stream " + parts[1])
+	}
+
+	// the tag may hold more flags in the future, separated by colons
+	tag := strings.Split(parts[2], ":")[0]
+	if tag != "F" && tag != "P" {
+		return nil, errors.New("unknown tag " + parts[2])
+	}
+
+	var message string
+	if len(parts) == 4 {
+		message = parts[3]
+	}
+
+	return log.FromFields(map[string]any{
+		log.TimestampKey: ts.Format(time.RFC3339Nano),
+		"stream":         parts[1],
+		"partial":        tag == "P",
+		log.MessageKey:   message,
+	}), nil
+}
@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+var (
+	goroutineRegex = regexp.MustCompile(`^goroutine (\d+) \[([^\]]+)\]:$`)
+	frameFileRegex = regexp.MustCompile(`^\t(.+):(\d+)(?: \+0x[0-9a-f]+)?$`)
+)
+
+// GoPanic parses the whole output of a Go panic, e.g. joined into a single entry by a multiline joiner,
+// into its message and the stack of the goroutine which panicked
+type GoPanic struct{}
+
+func (GoPanic) Parse(line string) (log.Entry, error) {
+	lines := strings.Split(strings.TrimRight(line, "\n"), "\n")
+
+	i := 0
+	for i < len(lines) && !strings.HasPrefix(lines[i], "panic: ") && !strings.HasPrefix(lines[i], "fatal error: ") {
+		i++
+	}
+	if i == len(lines) {
+		return nil, errors.New("not a Go panic")
+	}
+
+	kind, message, _ := strings.Cut(lines[i], ": ")
+	level := "panic"
+	if kind == "fatal error" {
+		level = "fatal"
+	}
+	fields := map[string]any{
+		log.LevelKey: level,
+	}
+
+	// the message may span several lines, up to the goroutine header
+	i++
+	for ; i < len(lines) && !goroutineRegex.MatchString(lines[i]); i++ {
+		if lines[i] != "" {
+			message += "\n" + lines[i]
+		}
+	}
+	fields[log.MessageKey] = strings.TrimSuffix(message, " [recovered]")
+
+	if i == len(lines) {
+		return log.FromFields(fields), nil
+	}
+
+	m := goroutineRegex.FindStringSubmatch(lines[i])
+	id, _ := strconv.Atoi(m[1])
+	fields["goroutine"] = id
+	fields["goroutine_state"] = m[2]
+
+	var frames []any
+	for i++; i+1 < len(lines); i += 2 {
+		if lines[i] == "" {
+			break
+		}
+
+		file := frameFileRegex.FindStringSubmatch(lines[i+1])
+		if file == nil {
+			break
+		}
+
+		fn, created := strings.CutPrefix(lines[i], "created by ")
+		if created {
+			fn, _, _ = strings.Cut(fn, " in goroutine ")
+			fields["created_by"] = fn
+		} else if j := strings.LastIndex(fn, "("); j > 0 && strings.HasSuffix(fn, ")") {
+			// the arguments are raw words, of no use once parsed
+			fn = fn[:j]
+		}
+
+		no, _ := strconv.Atoi(file[2])
+		frames = append(frames, map[string]any{"function": fn, "file": file[1], "line": no})
+	}
+	if len(frames) > 0 {
+		fields["frames"] = frames
+	}
+
+	return log.FromFields(fields), nil
+}
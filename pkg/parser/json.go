@@ -0,0 +1,32 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// JSON parses lines holding a single JSON object
+type JSON struct{}
+
+func (JSON) Parse(line string) (log.Entry, error) {
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	if fields == nil {
+		return nil, errors.New("not a JSON object")
+	}
+
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON object")
+	}
+
+	return log.FromFields(fields), nil
+}
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// Logfmt parses key=value pairs as described in https://brandur.org/logfmt
+// Values may be double-quoted, keys without a value are set to true
+type Logfmt struct{}
+
+func (Logfmt) Parse(line string) (log.Entry, error) {
+	fields := map[string]any{}
+
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+
+		key := line[start:i]
+		if key == "" || strings.ContainsAny(key, `"`) {
+			return nil, fmt.Errorf("malformed key at %d", start)
+		}
+
+		if i >= len(line) || line[i] != '=' {
+			fields[key] = true
+			continue
+		}
+		i++
+
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated value of %q", key)
+			}
+
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("malformed value of %q: %w", key, err)
+			}
+
+			fields[key] = value
+			i = end + 1
+			continue
+		}
+
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		fields[key] = line[start:i]
+	}
+
+	if len(fields) == 0 {
+		return nil, errors.New("no fields")
+	}
+
+	return log.FromFields(fields), nil
+}
@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// Parser turns a single raw log line into a log entry
+type Parser interface {
+	Parse(line string) (log.Entry, error)
+}
+
+// New creates a parser by its name, expr is only used by the regex parser
+func New(name string, expr string) (Parser, error) {
+	switch name {
+	case "", "none":
+		return None{}, nil
+	case "json":
+		return JSON{}, nil
+	case "logfmt":
+		return Logfmt{}, nil
+	case "combined":
+		return Combined{}, nil
+	case "cri":
+		return CRI{}, nil
+	case "gopanic":
+		return GoPanic{}, nil
+	case "regex":
+		return NewRegex(expr)
+	default:
+		return nil, fmt.Errorf("unknown parser %q", name)
+	}
+}
+
+// None keeps lines as they are
+type None struct{}
+
+func (None) Parse(line string) (log.Entry, error) {
+	return log.FromString(line), nil
+}
@@ -0,0 +1,135 @@
+package parser
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParser(t *testing.T) {
+	tests := []struct {
+		name    string
+		parser  string
+		expr    string
+		line    string
+		want    log.Entry
+		wantErr bool
+	}{
+		{"none", "none", "", `level=info msg="hi"`, log.FromString(`level=info msg="hi"`), false},
+		{"default", "", "", `{"a":1}`, log.FromString(`{"a":1}`), false},
+
+		{"json object", "json", "", `{"level":"info","n":1.5,"nested":{"a":[1]}}`, log.FromFields(map[string]any{
+			"level":  "info",
+			"n":      json.Number("1.5"),
+			"nested": map[string]any{"a": []any{json.Number("1")}},
+		}), false},
+		{"json string", "json", "", `"text"`, nil, true},
+		{"json null", "json", "", `null`, nil, true},
+		{"json trailing data", "json", "", `{"a":1} {"b":2}`, nil, true},
+		{"json malformed", "json", "", `{"a":`, nil, true},
+
+		{"logfmt", "logfmt", "", `level=info msg="hello \"world\"" dur=1.5s  flag path=/a=b`, log.FromFields(map[string]any{
+			"level": "info",
+			"msg":   `hello "world"`,
+			"dur":   "1.5s",
+			"flag":  true,
+			"path":  "/a=b",
+		}), false},
+		{"logfmt empty value", "logfmt", "", `a= b=""`, log.FromFields(map[string]any{"a": "", "b": ""}), false},
+		{"logfmt unterminated", "logfmt", "", `msg="oops`, nil, true},
+		{"logfmt malformed key", "logfmt", "", `=value`, nil, true},
+		{"logfmt empty", "logfmt", "", `   `, nil, true},
+
+		{"regex", "regex", `^(?P<ip>\S+) (?P<method>[A-Z]+) (?P<path>\S+)`, `10.0.0.1 GET /index.html HTTP/1.1`, log.FromFields(map[string]any{
+			"ip":     "10.0.0.1",
+			"method": "GET",
+			"path":   "/index.html",
+		}), false},
+		{"regex no match", "regex", `^(?P<ip>\d+\.\d+\.\d+\.\d+)$`, `not an ip`, nil, true},
+
+		{"combined", "combined", "", `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`, log.FromFields(map[string]any{
+			"remote_addr": "127.0.0.1",
+			"remote_user": "frank",
+			"timestamp":   "2000-10-10T13:55:36-07:00",
+			"request":     "GET /apache_pb.gif HTTP/1.0",
+			"method":      "GET",
+			"path":        "/apache_pb.gif",
+			"protocol":    "HTTP/1.0",
+			"status":      200,
+			"bytes":       int64(2326),
+			"referer":     "http://www.example.com/start.html",
+			"user_agent":  "Mozilla/4.08 [en] (Win98; I ;Nav)",
+		}), false},
+		{"common", "combined", "", `10.1.1.1 - - [01/Jan/2023:00:00:00 +0000] "-" 400 - `, log.FromFields(map[string]any{
+			"remote_addr": "10.1.1.1",
+			"timestamp":   "2023-01-01T00:00:00Z",
+			"request":     "-",
+			"status":      400,
+		}), false},
+		{"combined malformed", "combined", "", `GET /index.html 200`, nil, true},
+
+		{"cri", "cri", "", `2016-10-06T00:17:09.669794202Z stdout F log message with  spaces`, log.FromFields(map[string]any{
+			"timestamp": "2016-10-06T00:17:09.669794202Z",
+			"stream":    "stdout",
+			"partial":   false,
+			"message":   "log message with  spaces",
+		}), false},
+		{"cri partial", "cri", "", `2016-10-06T00:17:10.113242941Z stderr P `, log.FromFields(map[string]any{
+			"timestamp": "2016-10-06T00:17:10.113242941Z",
+			"stream":    "stderr",
+			"partial":   true,
+			"message":   "",
+		}), false},
+		{"cri unknown stream", "cri", "", `2016-10-06T00:17:09Z stdin F x`, nil, true},
+		{"cri malformed", "cri", "", `log message`, nil, true},
+
+		{"gopanic", "gopanic", "", "panic: runtime error: index out of range [3] with length 0\n\ngoroutine 7 [running]:\n" +
+			"main.(*server).handle(0xc000010000, {0x0, 0x0})\n\t/app/server.go:42 +0x1d\n" +
+			"created by main.main in goroutine 1\n\t/app/main.go:10 +0x25\nexit status 2\n", log.FromFields(map[string]any{
+			"level":           "panic",
+			"message":         "runtime error: index out of range [3] with length 0",
+			"goroutine":       7,
+			"goroutine_state": "running",
+			"created_by":      "main.main",
+			"frames": []any{
+				map[string]any{"function": "main.(*server).handle", "file": "/app/server.go", "line": 42},
+				map[string]any{"function": "main.main", "file": "/app/main.go", "line": 10},
+			},
+		}), false},
+		{"gopanic fatal error", "gopanic", "", "fatal error: all goroutines are asleep - deadlock!", log.FromFields(map[string]any{
+			"level":   "fatal",
+			"message": "all goroutines are asleep - deadlock!",
+		}), false},
+		{"not a gopanic", "gopanic", "", "error: something failed", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.parser, tt.expr)
+			require.NoError(t, err)
+
+			got, err := p.Parse(tt.line)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		for name, expr := range map[string]string{
+			"unknown": "",
+			"regex":   `(unnamed)`,
+		} {
+			_, err := New(name, expr)
+			require.Error(t, err, name)
+		}
+
+		_, err := NewRegex(`(?P<broken`)
+		require.Error(t, err)
+	})
+}
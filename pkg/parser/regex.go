@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// Regex extracts the named groups of a regular expression into fields
+type Regex struct {
+	re *regexp.Regexp
+}
+
+func NewRegex(expr string) (*Regex, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var named bool
+	for _, name := range re.SubexpNames() {
+		named = named || name != ""
+	}
+
+	if !named {
+		return nil, errors.New("the regex has no named groups")
+	}
+
+	return &Regex{re: re}, nil
+}
+
+func (r *Regex) Parse(line string) (log.Entry, error) {
+	match := r.re.FindStringSubmatch(line)
+	if match == nil {
+		return nil, errors.New("no match")
+	}
+
+	fields := map[string]any{}
+	for i, name := range r.re.SubexpNames() {
+		if name != "" && i < len(match) {
+			fields[name] = match[i]
+		}
+	}
+
+	return log.FromFields(fields), nil
+}
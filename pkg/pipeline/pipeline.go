@@ -0,0 +1,232 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Record is an entry along with the bucket it's written to
+type Record struct {
+	Bucket string
+	Entry  log.Entry
+}
+
+// Processor handles the entries one at a time, returning what they turn into: none drops the entry, several
+// split it, and records of another bucket reroute it
+type Processor interface {
+	Process(r Record) ([]Record, error)
+}
+
+// ProcessorFunc is a function used as a Processor
+type ProcessorFunc func(r Record) ([]Record, error)
+
+func (f ProcessorFunc) Process(r Record) ([]Record, error) {
+	return f(r)
+}
+
+// ProcessorConfig configures a built-in processor, the fields used depend on its type
+type ProcessorConfig struct {
+	Type string `json:"type"`
+	// Name labels the metrics of the processor, its type followed by its position in the chain by default
+	Name string `json:"name,omitempty"`
+	// Match is the regular expression the entries must match to be processed, the others are passed along as they are
+	Match string `json:"match,omitempty"`
+
+	// Bucket is where reroute sends the entries
+	Bucket string `json:"bucket,omitempty"`
+	// Separator is what split splits the entries on, a new line by default
+	Separator string `json:"separator,omitempty"`
+	// Rate is the fraction of the entries sample keeps
+	Rate float64 `json:"rate,omitempty"`
+	// Replacement replaces the matches in replace, with $1 for the first group of Match and so on
+	Replacement string `json:"replacement,omitempty"`
+	// Fields are set by enrich
+	Fields map[string]any `json:"fields,omitempty"`
+	// Parser is the name of the parser of parse, as in parser.New, with its Expr for the regex one
+	Parser string `json:"parser,omitempty"`
+	Expr   string `json:"expr,omitempty"`
+}
+
+// BucketProcessors is the chain of processors of every bucket matching the pattern
+type BucketProcessors struct {
+	Bucket     string            `json:"bucket"`
+	Processors []ProcessorConfig `json:"processors"`
+}
+
+// Config lists the chains, the first one matching a bucket applies, patterns are as in auth.Match
+type Config struct {
+	Buckets []BucketProcessors `json:"buckets"`
+}
+
+// Load reads the config from a JSON file
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+type stage struct {
+	name      string
+	match     *regexp.Regexp
+	processor Processor
+}
+
+type chain struct {
+	bucket string
+	stages []stage
+}
+
+// Pipeline runs the entries through the chain of processors of their bucket before they're written
+type Pipeline struct {
+	chains []*chain
+
+	entries *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+}
+
+func New(cfg Config) (*Pipeline, error) {
+	p := &Pipeline{
+		entries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "immulogs_processor_entries_total",
+			Help: "Entries handled by the processors, by result.",
+		}, []string{"chain", "processor", "result"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "immulogs_processor_seconds",
+			Help:    "Time spent processing an entry.",
+			Buckets: []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05},
+		}, []string{"chain", "processor"}),
+	}
+
+	for _, b := range cfg.Buckets {
+		c := p.chain(b.Bucket)
+		for i, pc := range b.Processors {
+			proc, err := Build(pc)
+			if err != nil {
+				return nil, fmt.Errorf("bucket %s: processor %d: %w", b.Bucket, i+1, err)
+			}
+
+			name := pc.Name
+			if name == "" {
+				name = pc.Type + "-" + strconv.Itoa(i+1)
+			}
+
+			var match *regexp.Regexp
+			if pc.Match != "" {
+				if match, err = regexp.Compile(pc.Match); err != nil {
+					return nil, fmt.Errorf("bucket %s: processor %d: %w", b.Bucket, i+1, err)
+				}
+			}
+
+			c.stages = append(c.stages, stage{name: name, match: match, processor: proc})
+		}
+	}
+
+	return p, nil
+}
+
+// Use appends a processor to the chain of the buckets matching the pattern, e.g. a custom one
+func (p *Pipeline) Use(bucket, name string, proc Processor) *Pipeline {
+	c := p.chain(bucket)
+	c.stages = append(c.stages, stage{name: name, processor: proc})
+	return p
+}
+
+func (p *Pipeline) chain(bucket string) *chain {
+	for _, c := range p.chains {
+		if c.bucket == bucket {
+			return c
+		}
+	}
+
+	c := &chain{bucket: bucket}
+	p.chains = append(p.chains, c)
+	return c
+}
+
+// Collectors are the metrics of the processors, to be registered
+func (p *Pipeline) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.entries, p.latency}
+}
+
+// Process runs the entry through the chain of its bucket, rerouted entries go on through the same chain,
+// never through the one of the bucket they're rerouted to
+func (p *Pipeline) Process(bucket string, e log.Entry) ([]Record, error) {
+	records := []Record{{Bucket: bucket, Entry: e}}
+
+	c := p.chainOf(bucket)
+	if c == nil {
+		return records, nil
+	}
+
+	for _, s := range c.stages {
+		var next []Record
+		for _, r := range records {
+			if s.match != nil && !s.match.MatchString(r.Entry.String()) {
+				next = append(next, r)
+				continue
+			}
+
+			out, err := p.run(c, s, r)
+			if err != nil {
+				return nil, fmt.Errorf("processor %s: %w", s.name, err)
+			}
+			next = append(next, out...)
+		}
+
+		records = next
+		if len(records) == 0 {
+			break
+		}
+	}
+
+	return records, nil
+}
+
+func (p *Pipeline) run(c *chain, s stage, r Record) ([]Record, error) {
+	start := time.Now()
+	out, err := s.processor.Process(r)
+	p.latency.WithLabelValues(c.bucket, s.name).Observe(time.Since(start).Seconds())
+
+	p.entries.WithLabelValues(c.bucket, s.name, "in").Inc()
+	switch {
+	case err != nil:
+		p.entries.WithLabelValues(c.bucket, s.name, "error").Inc()
+	case len(out) == 0:
+		p.entries.WithLabelValues(c.bucket, s.name, "dropped").Inc()
+	default:
+		p.entries.WithLabelValues(c.bucket, s.name, "out").Add(float64(len(out)))
+		for _, o := range out {
+			if o.Bucket != r.Bucket {
+				p.entries.WithLabelValues(c.bucket, s.name, "rerouted").Inc()
+			}
+		}
+	}
+
+	return out, err
+}
+
+func (p *Pipeline) chainOf(bucket string) *chain {
+	for _, c := range p.chains {
+		if auth.Match(c.bucket, bucket) {
+			return c
+		}
+	}
+
+	return nil
+}
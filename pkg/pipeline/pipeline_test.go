@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline(t *testing.T) {
+	p, err := New(Config{Buckets: []BucketProcessors{
+		{Bucket: "app*", Processors: []ProcessorConfig{
+			{Type: TypeSplit},
+			{Type: TypeDrop, Name: "no-debug", Match: `^DEBUG`},
+			{Type: TypeReroute, Match: `^ERROR`, Bucket: "errors"},
+			{Type: TypeEnrich, Fields: map[string]any{"env": "prod"}},
+		}},
+	}})
+	require.NoError(t, err)
+
+	t.Run("chain", func(t *testing.T) {
+		records, err := p.Process("app", log.FromString("INFO started\nDEBUG x=1\n\nERROR failed"))
+		require.NoError(t, err)
+		require.Equal(t, []Record{
+			{Bucket: "app", Entry: log.FromFields(map[string]any{"message": "INFO started", "env": "prod"})},
+			{Bucket: "errors", Entry: log.FromFields(map[string]any{"message": "ERROR failed", "env": "prod"})},
+		}, records)
+	})
+
+	t.Run("all dropped", func(t *testing.T) {
+		records, err := p.Process("app/eu", log.FromString("DEBUG x=2"))
+		require.NoError(t, err)
+		require.Empty(t, records)
+	})
+
+	t.Run("no chain", func(t *testing.T) {
+		records, err := p.Process("other", log.FromString("DEBUG x=3"))
+		require.NoError(t, err)
+		require.Equal(t, []Record{{Bucket: "other", Entry: log.FromString("DEBUG x=3")}}, records)
+	})
+
+	t.Run("custom processor", func(t *testing.T) {
+		p.Use("jobs", "failing", ProcessorFunc(func(r Record) ([]Record, error) {
+			return nil, errors.New("boom")
+		}))
+
+		_, err := p.Process("jobs", log.FromString("x"))
+		require.EqualError(t, err, "processor failing: boom")
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		require.Equal(t, 2., testutil.ToFloat64(p.entries.WithLabelValues("app*", "split-1", "in")))
+		require.Equal(t, 4., testutil.ToFloat64(p.entries.WithLabelValues("app*", "split-1", "out")))
+		require.Equal(t, 2., testutil.ToFloat64(p.entries.WithLabelValues("app*", "no-debug", "dropped")))
+		require.Equal(t, 1., testutil.ToFloat64(p.entries.WithLabelValues("app*", "reroute-3", "in")))
+		require.Equal(t, 1., testutil.ToFloat64(p.entries.WithLabelValues("app*", "reroute-3", "rerouted")))
+		require.Equal(t, 1., testutil.ToFloat64(p.entries.WithLabelValues("jobs", "failing", "error")))
+	})
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name      string
+		processor ProcessorConfig
+		err       string
+	}{
+		{"unknown type", ProcessorConfig{Type: "grok"}, `bucket *: processor 1: unknown processor type "grok"`},
+		{"invalid match", ProcessorConfig{Type: TypeDrop, Match: "("}, "bucket *: processor 1: error parsing regexp: missing closing ): `(`"},
+		{"reroute without bucket", ProcessorConfig{Type: TypeReroute}, "bucket *: processor 1: reroute needs a bucket"},
+		{"sample rate", ProcessorConfig{Type: TypeSample, Rate: 2}, "bucket *: processor 1: sample rate must be in (0, 1], got 2"},
+		{"replace without match", ProcessorConfig{Type: TypeReplace}, "bucket *: processor 1: replace needs a match"},
+		{"enrich without fields", ProcessorConfig{Type: TypeEnrich}, "bucket *: processor 1: enrich needs fields"},
+		{"parse without parser", ProcessorConfig{Type: TypeParse}, "bucket *: processor 1: parse needs a parser"},
+		{"unknown parser", ProcessorConfig{Type: TypeParse, Parser: "xml"}, `bucket *: processor 1: unknown parser "xml"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(Config{Buckets: []BucketProcessors{{Bucket: "*", Processors: []ProcessorConfig{tt.processor}}}})
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipeline.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"buckets":[{"bucket":"*","processors":[{"type":"sample","rate":0.5}]}]}`), 0o600))
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	require.Equal(t, Config{Buckets: []BucketProcessors{{Bucket: "*", Processors: []ProcessorConfig{{Type: TypeSample, Rate: 0.5}}}}}, cfg)
+}
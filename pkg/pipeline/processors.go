@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/lootek/go-immulogs/pkg/parser"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// Built-in processor types
+const (
+	TypeDrop    = "drop"
+	TypeSample  = "sample"
+	TypeReroute = "reroute"
+	TypeSplit   = "split"
+	TypeReplace = "replace"
+	TypeEnrich  = "enrich"
+	TypeParse   = "parse"
+)
+
+// Build creates a built-in processor, the match of the config is applied by the pipeline
+func Build(cfg ProcessorConfig) (Processor, error) {
+	switch cfg.Type {
+	case TypeDrop:
+		return Drop(), nil
+	case TypeSample:
+		if cfg.Rate <= 0 || cfg.Rate > 1 {
+			return nil, fmt.Errorf("sample rate must be in (0, 1], got %v", cfg.Rate)
+		}
+		return Sample(cfg.Rate), nil
+	case TypeReroute:
+		if cfg.Bucket == "" {
+			return nil, errors.New("reroute needs a bucket")
+		}
+		return Reroute(cfg.Bucket), nil
+	case TypeSplit:
+		sep := cfg.Separator
+		if sep == "" {
+			sep = "\n"
+		}
+		return Split(sep), nil
+	case TypeReplace:
+		if cfg.Match == "" {
+			return nil, errors.New("replace needs a match")
+		}
+		re, err := regexp.Compile(cfg.Match)
+		if err != nil {
+			return nil, err
+		}
+		return Replace(re, cfg.Replacement), nil
+	case TypeEnrich:
+		if len(cfg.Fields) == 0 {
+			return nil, errors.New("enrich needs fields")
+		}
+		return Enrich(cfg.Fields), nil
+	case TypeParse:
+		if cfg.Parser == "" {
+			return nil, errors.New("parse needs a parser")
+		}
+		p, err := parser.New(cfg.Parser, cfg.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return Parse(p), nil
+	default:
+		return nil, fmt.Errorf("unknown processor type %q", cfg.Type)
+	}
+}
+
+// Drop drops every entry
+func Drop() Processor {
+	return ProcessorFunc(func(r Record) ([]Record, error) {
+		return nil, nil
+	})
+}
+
+// Sample keeps the given fraction of the entries, evenly spread
+func Sample(rate float64) Processor {
+	var mu sync.Mutex
+	var credit float64
+
+	return ProcessorFunc(func(r Record) ([]Record, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		credit += rate
+		if credit < 1 {
+			return nil, nil
+		}
+
+		credit--
+		return []Record{r}, nil
+	})
+}
+
+// Reroute writes the entries to another bucket
+func Reroute(bucket string) Processor {
+	return ProcessorFunc(func(r Record) ([]Record, error) {
+		return []Record{{Bucket: bucket, Entry: r.Entry}}, nil
+	})
+}
+
+// Split splits the entries into one entry per non-empty part, structured entries are left alone
+func Split(sep string) Processor {
+	return ProcessorFunc(func(r Record) ([]Record, error) {
+		if _, ok := r.Entry.(log.Structured); ok {
+			return []Record{r}, nil
+		}
+
+		var out []Record
+		for _, part := range strings.Split(r.Entry.String(), sep) {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+			out = append(out, Record{Bucket: r.Bucket, Entry: log.FromString(part)})
+		}
+
+		return out, nil
+	})
+}
+
+// Replace replaces the matches of the regular expression in the entries, structured ones included as JSON
+func Replace(re *regexp.Regexp, replacement string) Processor {
+	return ProcessorFunc(func(r Record) ([]Record, error) {
+		replaced := re.ReplaceAllString(r.Entry.String(), replacement)
+		if _, ok := r.Entry.(log.Structured); !ok {
+			return []Record{{Bucket: r.Bucket, Entry: log.FromString(replaced)}}, nil
+		}
+
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(replaced), &fields); err != nil {
+			return nil, fmt.Errorf("the replacement broke the entry: %w", err)
+		}
+
+		return []Record{{Bucket: r.Bucket, Entry: log.FromFields(fields)}}, nil
+	})
+}
+
+// Enrich sets the fields on the entries, plain ones are turned into structured ones with their line as the message
+func Enrich(fields map[string]any) Processor {
+	return ProcessorFunc(func(r Record) ([]Record, error) {
+		var enriched map[string]any
+		if s, ok := r.Entry.(log.Structured); ok {
+			enriched = make(map[string]any, len(s.Fields())+len(fields))
+			for k, v := range s.Fields() {
+				enriched[k] = v
+			}
+		} else {
+			enriched = map[string]any{log.MessageKey: r.Entry.String()}
+		}
+
+		for k, v := range fields {
+			enriched[k] = v
+		}
+
+		return []Record{{Bucket: r.Bucket, Entry: log.FromFields(enriched)}}, nil
+	})
+}
+
+// Parse turns the plain entries into structured ones, keeping their original line under log.RawKey,
+// the entries which don't parse are kept as they are, and so are the structured ones and the ones
+// with a field of their own under log.RawKey, which would be lost otherwise
+func Parse(p parser.Parser) Processor {
+	return ProcessorFunc(func(r Record) ([]Record, error) {
+		if _, ok := r.Entry.(log.Structured); ok {
+			return []Record{r}, nil
+		}
+
+		line := r.Entry.String()
+		parsed, err := p.Parse(line)
+		if err != nil {
+			return []Record{r}, nil
+		}
+
+		s, ok := parsed.(log.Structured)
+		if !ok {
+			return []Record{r}, nil
+		}
+
+		fields := s.Fields()
+		if _, ok := fields[log.RawKey]; ok {
+			return []Record{r}, nil
+		}
+		fields[log.RawKey] = line
+
+		return []Record{{Bucket: r.Bucket, Entry: log.FromFields(fields)}}, nil
+	})
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessors(t *testing.T) {
+	process := func(t *testing.T, p Processor, e log.Entry) []Record {
+		out, err := p.Process(Record{Bucket: "app", Entry: e})
+		require.NoError(t, err)
+		return out
+	}
+
+	t.Run("sample", func(t *testing.T) {
+		p := Sample(0.25)
+		var kept int
+		for i := 0; i < 100; i++ {
+			kept += len(process(t, p, log.FromString("x")))
+		}
+		require.Equal(t, 25, kept)
+	})
+
+	t.Run("split", func(t *testing.T) {
+		require.Equal(t, []Record{
+			{Bucket: "app", Entry: log.FromString("a")},
+			{Bucket: "app", Entry: log.FromString("b")},
+		}, process(t, Split(";"), log.FromString("a;; ;b")))
+
+		structured := log.FromFields(map[string]any{"message": "a;b"})
+		require.Equal(t, []Record{{Bucket: "app", Entry: structured}}, process(t, Split(";"), structured))
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		p := Replace(regexp.MustCompile(`user=(\w+)`), "user=<$1>")
+		require.Equal(t, []Record{{Bucket: "app", Entry: log.FromString("login user=<joe>")}}, process(t, p, log.FromString("login user=joe")))
+		require.Equal(t, []Record{{Bucket: "app", Entry: log.FromFields(map[string]any{"msg": "user=<ann>"})}},
+			process(t, p, log.FromFields(map[string]any{"msg": "user=ann"})))
+
+		_, err := Replace(regexp.MustCompile(`"msg"`), "msg").Process(Record{Entry: log.FromFields(map[string]any{"msg": "x"})})
+		require.ErrorContains(t, err, "the replacement broke the entry")
+	})
+
+	t.Run("enrich", func(t *testing.T) {
+		fields := map[string]any{"level": "info", "env": "dev"}
+		got := process(t, Enrich(map[string]any{"env": "prod", "region": "eu"}), log.FromFields(fields))
+		require.Equal(t, []Record{{Bucket: "app", Entry: log.FromFields(map[string]any{"level": "info", "env": "prod", "region": "eu"})}}, got)
+		// the original entry is left intact
+		require.Equal(t, "dev", fields["env"])
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		p, err := Build(ProcessorConfig{Type: TypeParse, Parser: "logfmt"})
+		require.NoError(t, err)
+
+		line := `level=warn msg="disk almost full"`
+		require.Equal(t, []Record{{Bucket: "app", Entry: log.FromFields(map[string]any{
+			"level":    "warn",
+			"msg":      "disk almost full",
+			log.RawKey: line,
+		})}}, process(t, p, log.FromString(line)))
+
+		// kept as they are
+		require.Equal(t, []Record{{Bucket: "app", Entry: log.FromString(`msg="oops`)}}, process(t, p, log.FromString(`msg="oops`)))
+		require.Equal(t, []Record{{Bucket: "app", Entry: log.FromString(`msg=x raw=y`)}}, process(t, p, log.FromString(`msg=x raw=y`)))
+		structured := log.FromFields(map[string]any{"msg": "x"})
+		require.Equal(t, []Record{{Bucket: "app", Entry: structured}}, process(t, p, structured))
+	})
+}
@@ -0,0 +1,379 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/auth"
+)
+
+// Limits caps the writes to a bucket or a tenant, zero meaning unlimited, the bytes are counted as stored,
+// e.g. once encrypted
+type Limits struct {
+	EntriesPerSecond float64 `json:"entries_per_second,omitempty"`
+	BytesPerDay      int64   `json:"bytes_per_day,omitempty"`
+	MaxEntrySize     int64   `json:"max_entry_size,omitempty"`
+	MaxTotalBytes    int64   `json:"max_total_bytes,omitempty"`
+}
+
+// BucketLimits applies to every bucket matching the pattern, within each tenant on its own
+type BucketLimits struct {
+	Bucket string `json:"bucket"`
+	Limits
+}
+
+// TenantLimits applies to all the buckets of every tenant matching the pattern taken together,
+// the default storage being the tenant with an empty name
+type TenantLimits struct {
+	Tenant string `json:"tenant"`
+	Limits
+}
+
+// Config lists the limits, the first one matching a bucket or a tenant applies, patterns are as in auth.Match
+type Config struct {
+	Buckets []BucketLimits `json:"buckets"`
+	Tenants []TenantLimits `json:"tenants"`
+}
+
+// Load reads the config from a JSON file
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// ExceededError rejects a write, RetryAfter is when it may succeed, zero if it won't unless the limits change
+type ExceededError struct {
+	Scope      string
+	Limit      string
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s quota of %s exceeded", e.Limit, e.Scope)
+}
+
+// TooLargeError rejects an entry bigger than allowed, whatever the usage
+type TooLargeError struct {
+	Scope string
+	Entry int
+	Size  int64
+	Max   int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("entry %d of %d bytes exceeds the max entry size of %s, %d bytes", e.Entry, e.Size, e.Scope, e.Max)
+}
+
+// Totals returns the number of bytes already stored in the bucket of the tenant, in all its buckets if empty,
+// it's called once per bucket and tenant with a total bytes limit, without holding up the other writes,
+// usage is tracked in memory afterwards
+type Totals func(tenant, bucket string) (int64, error)
+
+// Usage is the consumption of a bucket or a tenant
+type Usage struct {
+	Tenant string `json:"tenant"`
+	Bucket string `json:"bucket,omitempty"`
+	Limits Limits `json:"limits"`
+
+	// AvailableEntries is the number of entries which may be written right now
+	AvailableEntries *float64 `json:"available_entries,omitempty"`
+	BytesToday       int64    `json:"bytes_today"`
+	// TotalBytes is only known with a total bytes limit
+	TotalBytes *int64 `json:"total_bytes,omitempty"`
+}
+
+// Quotas enforces the limits of the config
+type Quotas struct {
+	cfg    Config
+	totals Totals
+
+	mu     sync.Mutex
+	scopes map[scopeKey]*scope
+
+	now func() time.Time
+}
+
+type scopeKey struct {
+	tenant string
+	bucket string
+	// tenant scopes cover all the buckets
+	all bool
+}
+
+// scope is the usage of a bucket or a tenant
+type scope struct {
+	limits Limits
+
+	tokens  float64
+	refill  time.Time
+	day     time.Time
+	today   int64
+	total   int64
+	counted bool
+}
+
+func New(cfg Config, totals Totals) *Quotas {
+	return &Quotas{
+		cfg:    cfg,
+		totals: totals,
+		scopes: map[scopeKey]*scope{},
+		now:    time.Now,
+	}
+}
+
+// Reserve accounts for the write of entries of the given sizes to the bucket of the tenant,
+// or rejects it with an ExceededError or a TooLargeError, nothing being accounted for then,
+// the reservation is to be refunded if the write fails
+func (q *Quotas) Reserve(tenant, bucket string, sizes []int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var scopes []*scope
+	var names []string
+	for _, key := range []scopeKey{{tenant: tenant, bucket: bucket}, {tenant: tenant, all: true}} {
+		sc, err := q.scope(key)
+		if err != nil {
+			return err
+		}
+		if sc != nil {
+			scopes = append(scopes, sc)
+			names = append(names, key.String())
+		}
+	}
+
+	now := q.now()
+	var bytes int64
+	for _, size := range sizes {
+		bytes += size
+	}
+
+	for i, sc := range scopes {
+		if err := sc.check(now, names[i], sizes, bytes); err != nil {
+			return err
+		}
+	}
+
+	for _, sc := range scopes {
+		sc.consume(len(sizes), bytes)
+	}
+
+	return nil
+}
+
+// Refund gives back what Reserve accounted for, once the write failed
+func (q *Quotas) Refund(tenant, bucket string, sizes []int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var bytes int64
+	for _, size := range sizes {
+		bytes += size
+	}
+
+	now := q.now()
+	for _, key := range []scopeKey{{tenant: tenant, bucket: bucket}, {tenant: tenant, all: true}} {
+		if sc, ok := q.scopes[key]; ok {
+			sc.refund(now, len(sizes), bytes)
+		}
+	}
+}
+
+// Usage reports the consumption of the tenant and of the bucket, or of all the buckets of the tenant
+// with limits written to since startup if bucket is empty
+func (q *Quotas) Usage(tenant, bucket string) ([]Usage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	keys := []scopeKey{{tenant: tenant, all: true}}
+	if bucket != "" {
+		keys = append(keys, scopeKey{tenant: tenant, bucket: bucket})
+	} else {
+		var buckets []scopeKey
+		for key := range q.scopes {
+			if key.tenant == tenant && !key.all {
+				buckets = append(buckets, key)
+			}
+		}
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i].bucket < buckets[j].bucket })
+		keys = append(keys, buckets...)
+	}
+
+	now := q.now()
+	usage := []Usage{}
+	for _, key := range keys {
+		sc, err := q.scope(key)
+		if err != nil {
+			return nil, err
+		}
+		if sc == nil {
+			continue
+		}
+
+		sc.advance(now)
+		u := Usage{Tenant: key.tenant, Bucket: key.bucket, Limits: sc.limits, BytesToday: sc.today}
+		if sc.limits.EntriesPerSecond > 0 {
+			available := math.Max(sc.tokens, 0)
+			u.AvailableEntries = &available
+		}
+		if sc.counted {
+			total := sc.total
+			u.TotalBytes = &total
+		}
+		usage = append(usage, u)
+	}
+
+	return usage, nil
+}
+
+// scope returns the usage of the bucket or the tenant, nil if it has no limits, it's called with the lock held
+// which is released while counting the bytes already stored
+func (q *Quotas) scope(key scopeKey) (*scope, error) {
+	if sc, ok := q.scopes[key]; ok {
+		return sc, nil
+	}
+
+	limits, ok := q.limits(key)
+	if !ok {
+		return nil, nil
+	}
+
+	var total int64
+	if limits.MaxTotalBytes > 0 {
+		q.mu.Unlock()
+		var err error
+		total, err = q.totals(key.tenant, key.bucket)
+		q.mu.Lock()
+		if err != nil {
+			return nil, err
+		}
+
+		// counted by another write meanwhile
+		if sc, ok := q.scopes[key]; ok {
+			return sc, nil
+		}
+	}
+
+	now := q.now()
+	sc := &scope{limits: limits, tokens: limits.burst(), refill: now, day: day(now)}
+	sc.total, sc.counted = total, limits.MaxTotalBytes > 0
+
+	q.scopes[key] = sc
+	return sc, nil
+}
+
+func (q *Quotas) limits(key scopeKey) (Limits, bool) {
+	if key.all {
+		for _, l := range q.cfg.Tenants {
+			if auth.Match(l.Tenant, key.tenant) {
+				return l.Limits, true
+			}
+		}
+		return Limits{}, false
+	}
+
+	for _, l := range q.cfg.Buckets {
+		if auth.Match(l.Bucket, key.bucket) {
+			return l.Limits, true
+		}
+	}
+	return Limits{}, false
+}
+
+func (k scopeKey) String() string {
+	name := "tenant " + k.tenant
+	if k.tenant == "" {
+		name = "the default tenant"
+	}
+
+	if k.all {
+		return name
+	}
+	return "bucket " + k.bucket + " of " + name
+}
+
+// burst is the number of entries which may be written at once, i.e. a second worth of them
+func (l Limits) burst() float64 {
+	return math.Max(l.EntriesPerSecond, 1)
+}
+
+// advance refills the tokens and starts a new day if due
+func (sc *scope) advance(now time.Time) {
+	if rate := sc.limits.EntriesPerSecond; rate > 0 {
+		sc.tokens = math.Min(sc.tokens+now.Sub(sc.refill).Seconds()*rate, sc.limits.burst())
+	}
+	sc.refill = now
+
+	if d := day(now); d.After(sc.day) {
+		sc.day = d
+		sc.today = 0
+	}
+}
+
+func (sc *scope) check(now time.Time, name string, sizes []int64, bytes int64) error {
+	sc.advance(now)
+
+	if max := sc.limits.MaxEntrySize; max > 0 {
+		for i, size := range sizes {
+			if size > max {
+				return &TooLargeError{Scope: name, Entry: i, Size: size, Max: max}
+			}
+		}
+	}
+
+	// batches bigger than the burst only need a full bucket of tokens, leaving it in debt
+	if rate := sc.limits.EntriesPerSecond; rate > 0 {
+		needed := math.Min(float64(len(sizes)), sc.limits.burst())
+		if sc.tokens < needed {
+			wait := time.Duration(math.Ceil((needed - sc.tokens) / rate * float64(time.Second)))
+			return &ExceededError{Scope: name, Limit: "entries per second", RetryAfter: wait}
+		}
+	}
+
+	if max := sc.limits.BytesPerDay; max > 0 && sc.today+bytes > max {
+		return &ExceededError{Scope: name, Limit: "bytes per day", RetryAfter: sc.day.AddDate(0, 0, 1).Sub(now)}
+	}
+
+	if max := sc.limits.MaxTotalBytes; max > 0 && sc.total+bytes > max {
+		return &ExceededError{Scope: name, Limit: "total bytes"}
+	}
+
+	return nil
+}
+
+func (sc *scope) consume(entries int, bytes int64) {
+	if sc.limits.EntriesPerSecond > 0 {
+		sc.tokens -= float64(entries)
+	}
+	sc.today += bytes
+	sc.total += bytes
+}
+
+func (sc *scope) refund(now time.Time, entries int, bytes int64) {
+	sc.advance(now)
+
+	if sc.limits.EntriesPerSecond > 0 {
+		sc.tokens = math.Min(sc.tokens+float64(entries), sc.limits.burst())
+	}
+	// a new day may have started since
+	sc.today = max(sc.today-bytes, 0)
+	sc.total -= bytes
+}
+
+// day is the start of the UTC day, daily quotas reset at midnight UTC
+func day(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
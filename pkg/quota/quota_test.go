@@ -0,0 +1,181 @@
+package quota
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotas(t *testing.T) {
+	now := time.Date(2023, 1, 2, 23, 0, 0, 0, time.UTC)
+	newQuotas := func(cfg Config) *Quotas {
+		q := New(cfg, func(tenant, bucket string) (int64, error) {
+			if bucket == "" {
+				return 900, nil
+			}
+			return 100, nil
+		})
+		q.now = func() time.Time { return now }
+		return q
+	}
+
+	exceeded := func(t *testing.T, err error) *ExceededError {
+		var exceededErr *ExceededError
+		require.ErrorAs(t, err, &exceededErr)
+		return exceededErr
+	}
+
+	t.Run("entries per second", func(t *testing.T) {
+		q := newQuotas(Config{Buckets: []BucketLimits{{Bucket: "app", Limits: Limits{EntriesPerSecond: 10}}}})
+
+		require.NoError(t, q.Reserve("", "app", make([]int64, 4)))
+		require.NoError(t, q.Reserve("", "app", make([]int64, 6)))
+
+		err := exceeded(t, q.Reserve("", "app", make([]int64, 2)))
+		require.Equal(t, "entries per second", err.Limit)
+		require.Equal(t, 200*time.Millisecond, err.RetryAfter)
+
+		// unlimited buckets and other tenants have their own tokens
+		require.NoError(t, q.Reserve("", "other", make([]int64, 100)))
+		require.NoError(t, q.Reserve("acme", "app", make([]int64, 10)))
+
+		now = now.Add(200 * time.Millisecond)
+		require.NoError(t, q.Reserve("", "app", make([]int64, 2)))
+
+		// a batch bigger than the burst goes through once the bucket is full, leaving it in debt
+		now = now.Add(time.Second)
+		require.NoError(t, q.Reserve("", "app", make([]int64, 30)))
+		err = exceeded(t, q.Reserve("", "app", make([]int64, 1)))
+		require.Equal(t, 2100*time.Millisecond, err.RetryAfter)
+	})
+
+	t.Run("bytes per day", func(t *testing.T) {
+		q := newQuotas(Config{Tenants: []TenantLimits{{Tenant: "*", Limits: Limits{BytesPerDay: 100}}}})
+
+		require.NoError(t, q.Reserve("acme", "app", []int64{40, 40}))
+		require.NoError(t, q.Reserve("globex", "app", []int64{100}))
+
+		err := exceeded(t, q.Reserve("acme", "jobs", []int64{30}))
+		require.Equal(t, "bytes per day", err.Limit)
+		require.Equal(t, "tenant acme", err.Scope)
+		require.Equal(t, time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC).Sub(now), err.RetryAfter)
+
+		now = now.Add(err.RetryAfter)
+		require.NoError(t, q.Reserve("acme", "jobs", []int64{30}))
+	})
+
+	t.Run("max entry size", func(t *testing.T) {
+		q := newQuotas(Config{Buckets: []BucketLimits{{Bucket: "*", Limits: Limits{MaxEntrySize: 10}}}})
+
+		require.NoError(t, q.Reserve("", "app", []int64{10}))
+
+		var tooLarge *TooLargeError
+		require.ErrorAs(t, q.Reserve("", "app", []int64{1, 11}), &tooLarge)
+		require.Equal(t, 1, tooLarge.Entry)
+	})
+
+	t.Run("max total bytes", func(t *testing.T) {
+		q := newQuotas(Config{
+			Buckets: []BucketLimits{{Bucket: "jobs/*", Limits: Limits{MaxTotalBytes: 150}}},
+			Tenants: []TenantLimits{{Tenant: "", Limits: Limits{MaxTotalBytes: 1000}}},
+		})
+
+		require.NoError(t, q.Reserve("", "jobs/nightly", []int64{50}))
+
+		err := exceeded(t, q.Reserve("", "jobs/nightly", []int64{1}))
+		require.Equal(t, "total bytes", err.Limit)
+		require.Zero(t, err.RetryAfter)
+
+		// nothing accounted for when rejected
+		require.NoError(t, q.Reserve("", "app", []int64{50}))
+		err = exceeded(t, q.Reserve("", "app", []int64{1}))
+		require.Equal(t, "the default tenant", err.Scope)
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		q := newQuotas(Config{
+			Buckets: []BucketLimits{{Bucket: "app", Limits: Limits{EntriesPerSecond: 10, MaxTotalBytes: 1000}}},
+			Tenants: []TenantLimits{{Tenant: "acme", Limits: Limits{BytesPerDay: 1000}}},
+		})
+
+		require.NoError(t, q.Reserve("acme", "app", []int64{10, 20}))
+
+		usage, err := q.Usage("acme", "")
+		require.NoError(t, err)
+		require.Len(t, usage, 2)
+
+		require.Equal(t, "", usage[0].Bucket)
+		require.Equal(t, int64(30), usage[0].BytesToday)
+		require.Nil(t, usage[0].TotalBytes)
+
+		require.Equal(t, "app", usage[1].Bucket)
+		require.Equal(t, 8., *usage[1].AvailableEntries)
+		require.Equal(t, int64(130), *usage[1].TotalBytes)
+
+		usage, err = q.Usage("globex", "jobs")
+		require.NoError(t, err)
+		require.Empty(t, usage)
+	})
+
+	t.Run("refund", func(t *testing.T) {
+		q := newQuotas(Config{Buckets: []BucketLimits{{Bucket: "app", Limits: Limits{EntriesPerSecond: 2, BytesPerDay: 100, MaxTotalBytes: 200}}}})
+
+		require.NoError(t, q.Reserve("", "app", []int64{25, 25}))
+		exceeded(t, q.Reserve("", "app", []int64{1}))
+
+		q.Refund("", "app", []int64{25, 25})
+		require.NoError(t, q.Reserve("", "app", []int64{50, 50}))
+
+		usage, err := q.Usage("", "app")
+		require.NoError(t, err)
+		require.Equal(t, int64(100), usage[0].BytesToday)
+		require.Equal(t, int64(200), *usage[0].TotalBytes)
+	})
+
+	t.Run("totals counted without holding up the other writes", func(t *testing.T) {
+		counting, counted := make(chan struct{}), make(chan struct{})
+		q := New(Config{Buckets: []BucketLimits{{Bucket: "*", Limits: Limits{MaxTotalBytes: 10}}}}, func(_, bucket string) (int64, error) {
+			if bucket == "slow" {
+				close(counting)
+				<-counted
+			}
+			return 0, nil
+		})
+
+		done := make(chan error)
+		go func() {
+			done <- q.Reserve("", "slow", []int64{1})
+		}()
+
+		<-counting
+		require.NoError(t, q.Reserve("", "fast", []int64{1}))
+		close(counted)
+		require.NoError(t, <-done)
+	})
+
+	t.Run("totals failing", func(t *testing.T) {
+		q := New(Config{Buckets: []BucketLimits{{Bucket: "*", Limits: Limits{MaxTotalBytes: 10}}}}, func(string, string) (int64, error) {
+			return 0, errors.New("storage down")
+		})
+		require.EqualError(t, q.Reserve("", "app", []int64{1}), "storage down")
+	})
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"buckets": [{"bucket": "jobs/*", "entries_per_second": 100, "max_entry_size": 65536}],
+		"tenants": [{"tenant": "*", "bytes_per_day": 1000000000, "max_total_bytes": 10000000000}]
+	}`), 0o600))
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	require.Equal(t, Config{
+		Buckets: []BucketLimits{{Bucket: "jobs/*", Limits: Limits{EntriesPerSecond: 100, MaxEntrySize: 65536}}},
+		Tenants: []TenantLimits{{Tenant: "*", Limits: Limits{BytesPerDay: 1000000000, MaxTotalBytes: 10000000000}}},
+	}, cfg)
+}
@@ -0,0 +1,335 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// Action tells what replaces a match
+type Action string
+
+const (
+	// ActionMask replaces a match with the mask of the rule, "[REDACTED:<rule>]" by default
+	ActionMask Action = "mask"
+	// ActionHash replaces a match with "[<rule>:<hash>]", the salted hash keeping equal values correlatable
+	ActionHash Action = "hash"
+)
+
+// Built-in detectors, used by the rules without a pattern
+const (
+	DetectorEmail       = "email"
+	DetectorCreditCard  = "credit_card"
+	DetectorIP          = "ip"
+	DetectorBearerToken = "bearer_token"
+	DetectorAWSKey      = "aws_key"
+)
+
+// hashLength is the number of hex digits of the hashes kept in the entries
+const hashLength = 16
+
+// Rule replaces the matches of the pattern, or of the built-in detector of the same name if there's no pattern,
+// only the first capturing group is replaced if the pattern has one
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern,omitempty"`
+	Action  Action `json:"action,omitempty"`
+	Mask    string `json:"mask,omitempty"`
+}
+
+// BucketRules applies to every bucket matching the pattern
+type BucketRules struct {
+	Bucket string `json:"bucket"`
+	Rules  []Rule `json:"rules"`
+}
+
+// Config lists the rules, the first entry matching a bucket applies, patterns are as in auth.Match
+type Config struct {
+	// Salt of the hashes, to be kept secret, or else they're as good as the values for guessable ones
+	Salt    string        `json:"salt"`
+	Buckets []BucketRules `json:"buckets"`
+}
+
+// Load reads the config from a JSON file
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// matcher finds the candidates of a rule, valid rejects the false positives, stringsOnly keeps it off the numbers
+// it'd match too often, e.g. a tenth of the long ones pass the checksum of card numbers
+type matcher struct {
+	re          *regexp.Regexp
+	valid       func(string) bool
+	stringsOnly bool
+}
+
+var detectors = map[string][]matcher{
+	DetectorEmail: {
+		{re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)},
+	},
+	DetectorCreditCard: {
+		{re: regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`), valid: luhn, stringsOnly: true},
+	},
+	DetectorIP: {
+		{re: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), valid: isIP},
+		// full or compressed, not within words, e.g. std::string
+		{re: regexp.MustCompile(`\b[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{1,4}){7}\b|(?:\b[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{1,4}){0,6}|\B)::(?:[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{1,4}){0,6}\b|\B)`), valid: isIPv6},
+	},
+	DetectorBearerToken: {
+		{re: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`)},
+	},
+	DetectorAWSKey: {
+		{re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		{re: regexp.MustCompile(`(?i)aws_?secret_?access_?key\W{0,4}([A-Za-z0-9/+]{40})\b`)},
+	},
+}
+
+type rule struct {
+	name     string
+	action   Action
+	mask     string
+	matchers []matcher
+}
+
+type bucketRules struct {
+	bucket string
+	rules  []rule
+}
+
+// Redactor replaces the secrets and the personal data in the entries before they're written
+type Redactor struct {
+	salt    []byte
+	buckets []bucketRules
+}
+
+func New(cfg Config) (*Redactor, error) {
+	r := &Redactor{salt: []byte(cfg.Salt)}
+
+	for _, b := range cfg.Buckets {
+		br := bucketRules{bucket: b.Bucket}
+		for _, ru := range b.Rules {
+			compiled, err := r.compile(ru)
+			if err != nil {
+				return nil, fmt.Errorf("bucket %s: rule %s: %w", b.Bucket, ru.Name, err)
+			}
+			br.rules = append(br.rules, compiled)
+		}
+		r.buckets = append(r.buckets, br)
+	}
+
+	return r, nil
+}
+
+func (r *Redactor) compile(ru Rule) (rule, error) {
+	if ru.Name == "" {
+		return rule{}, errors.New("missing name")
+	}
+
+	compiled := rule{name: ru.Name, action: ru.Action, mask: ru.Mask}
+	switch ru.Action {
+	case "", ActionMask:
+		compiled.action = ActionMask
+		if compiled.mask == "" {
+			compiled.mask = "[REDACTED:" + ru.Name + "]"
+		}
+	case ActionHash:
+		if len(r.salt) == 0 {
+			return rule{}, errors.New("hashes require a salt")
+		}
+	default:
+		return rule{}, fmt.Errorf("unknown action %q", ru.Action)
+	}
+
+	if ru.Pattern == "" {
+		matchers, ok := detectors[ru.Name]
+		if !ok {
+			return rule{}, errors.New("no pattern and no built-in detector of this name")
+		}
+		compiled.matchers = matchers
+		return compiled, nil
+	}
+
+	re, err := regexp.Compile(ru.Pattern)
+	if err != nil {
+		return rule{}, err
+	}
+	compiled.matchers = []matcher{{re: re}}
+
+	return compiled, nil
+}
+
+// Redact returns the entry with the matches of the rules of the bucket replaced, and how many times every rule fired,
+// structured entries have the values of their fields redacted, not their names
+func (r *Redactor) Redact(bucket string, e log.Entry) (log.Entry, map[string]int) {
+	rules := r.rulesOf(bucket)
+	if len(rules) == 0 {
+		return e, nil
+	}
+
+	fired := map[string]int{}
+	if s, ok := e.(log.Structured); ok {
+		e = log.FromFields(r.redactValue(s.Fields(), rules, fired).(map[string]any))
+	} else {
+		e = log.FromString(r.redactString(e.String(), rules, fired, false))
+	}
+
+	if len(fired) == 0 {
+		return e, nil
+	}
+
+	return e, fired
+}
+
+func (r *Redactor) rulesOf(bucket string) []rule {
+	for _, b := range r.buckets {
+		if auth.Match(b.bucket, bucket) {
+			return b.rules
+		}
+	}
+
+	return nil
+}
+
+// redactValue redacts the strings and the numbers of a decoded JSON value, copying it rather than modifying it
+// in place, the numbers matched become strings
+func (r *Redactor) redactValue(v any, rules []rule, fired map[string]int) any {
+	switch v := v.(type) {
+	case string:
+		return r.redactString(v, rules, fired, false)
+	case json.Number:
+		return r.redactScalar(v, v.String(), rules, fired)
+	case float64:
+		return r.redactScalar(v, strconv.FormatFloat(v, 'f', -1, 64), rules, fired)
+	case int, int64, uint64:
+		return r.redactScalar(v, fmt.Sprint(v), rules, fired)
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, val := range v {
+			m[k] = r.redactValue(val, rules, fired)
+		}
+		return m
+	case []any:
+		l := make([]any, len(v))
+		for i, val := range v {
+			l[i] = r.redactValue(val, rules, fired)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// redactScalar redacts the text of a number, keeping the number as it is if nothing matched
+func (r *Redactor) redactScalar(v any, s string, rules []rule, fired map[string]int) any {
+	if redacted := r.redactString(s, rules, fired, true); redacted != s {
+		return redacted
+	}
+
+	return v
+}
+
+func (r *Redactor) redactString(s string, rules []rule, fired map[string]int, number bool) string {
+	for _, ru := range rules {
+		for _, m := range ru.matchers {
+			if number && m.stringsOnly {
+				continue
+			}
+			s = r.replace(s, ru, m, fired)
+		}
+	}
+
+	return s
+}
+
+// replace replaces the valid matches, or their first capturing group
+func (r *Redactor) replace(s string, ru rule, m matcher, fired map[string]int) string {
+	matches := m.re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, idx := range matches {
+		start, end := idx[0], idx[1]
+		if len(idx) >= 4 && idx[2] >= 0 {
+			start, end = idx[2], idx[3]
+		}
+
+		value := s[start:end]
+		if value == "" || (m.valid != nil && !m.valid(value)) {
+			continue
+		}
+
+		b.WriteString(s[last:start])
+		b.WriteString(r.replacement(ru, value))
+		last = end
+		fired[ru.name]++
+	}
+	b.WriteString(s[last:])
+
+	return b.String()
+}
+
+func (r *Redactor) replacement(ru rule, value string) string {
+	if ru.action == ActionMask {
+		return ru.mask
+	}
+
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(value))
+	return "[" + ru.name + ":" + hex.EncodeToString(mac.Sum(nil))[:hashLength] + "]"
+}
+
+// luhn validates the checksum of card numbers, separators aside
+func luhn(s string) bool {
+	var sum, n int
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+
+	return n >= 13 && sum%10 == 0
+}
+
+func isIP(s string) bool {
+	return net.ParseIP(s) != nil
+}
+
+// isIPv6 rejects the unspecified address, a bare :: being more likely punctuation
+func isIPv6(s string) bool {
+	return s != "::" && isIP(s)
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// latencySmoothing weighs the latest write in the moving average of the storage latency
+	latencySmoothing = 0.2
+
+	// overloadProbeInterval lets a write through now and then while shedding load, to learn when the storage recovers
+	overloadProbeInterval = time.Second
+
+	// idleClientTimeout forgets the clients which haven't made any request for a while
+	idleClientTimeout = 5 * time.Minute
+)
+
+// AdmissionConfig protects the storage from bursts of requests, zero values disable the matching limit
+type AdmissionConfig struct {
+	// ClientRate is the number of requests per second allowed to every client, identified by its credential
+	// if authenticated or else by its IP, with bursts of up to ClientBurst requests, the requests failing
+	// authentication count against their IP, which is checked before the credentials
+	ClientRate  float64
+	ClientBurst int
+
+	// MaxInFlightWrites caps the number of writes processed at once, the others being rejected right away
+	MaxInFlightWrites int
+
+	// LatencyThreshold sheds writes while the average latency of the storage is above it
+	LatencyThreshold time.Duration
+}
+
+// WithAdmission rejects the requests over the limits before they reach the storage,
+// with a 429 response for clients over their rate and a 503 one when the storage is too busy
+func WithAdmission(cfg AdmissionConfig) RESTOption {
+	return func(r *REST) {
+		r.admission = newAdmission(cfg)
+	}
+}
+
+type admission struct {
+	cfg   AdmissionConfig
+	slots chan struct{}
+
+	clientsMu sync.Mutex
+	clients   map[string]*tokenBucket
+	swept     time.Time
+
+	latencyMu sync.Mutex
+	latency   float64
+	probed    time.Time
+
+	now func() time.Time
+}
+
+func newAdmission(cfg AdmissionConfig) *admission {
+	a := &admission{
+		cfg:     cfg,
+		clients: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+	if cfg.MaxInFlightWrites > 0 {
+		a.slots = make(chan struct{}, cfg.MaxInFlightWrites)
+	}
+	if a.cfg.ClientBurst < 1 {
+		a.cfg.ClientBurst = int(math.Max(math.Ceil(cfg.ClientRate), 1))
+	}
+
+	return a
+}
+
+// admitIP is the middleware limiting the rate of every IP before the authentication, so that floods of
+// unauthenticated requests are rejected without checking their credentials, admit gives the token back
+// to the authenticated ones, which are limited per credential instead
+func (r *REST) admitIP() gin.HandlerFunc {
+	a := r.admission
+	return func(c *gin.Context) {
+		if wait, ok := a.allowClient(ipClientID(c)); !ok {
+			r.reject(c, http.StatusTooManyRequests, "rate_limit", "too many requests", wait)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// admit is the middleware enforcing the limits, placed after the authentication to tell the clients apart
+func (r *REST) admit() gin.HandlerFunc {
+	a := r.admission
+	return func(c *gin.Context) {
+		if _, ok := requestPrincipal(c); ok {
+			a.refundClient(ipClientID(c))
+		}
+
+		if wait, ok := a.allowClient(clientID(c)); !ok {
+			r.reject(c, http.StatusTooManyRequests, "rate_limit", "too many requests", wait)
+			return
+		}
+
+		if !isWrite(c) {
+			c.Next()
+			return
+		}
+
+		if a.overloaded() {
+			r.reject(c, http.StatusServiceUnavailable, "overload", "the storage is overloaded", overloadProbeInterval)
+			return
+		}
+
+		if a.slots != nil {
+			select {
+			case a.slots <- struct{}{}:
+				defer func() { <-a.slots }()
+			default:
+				r.reject(c, http.StatusServiceUnavailable, "in_flight", "too many writes in flight", time.Second)
+				return
+			}
+		}
+
+		r.metrics.inFlight.Inc()
+		defer r.metrics.inFlight.Dec()
+
+		c.Next()
+	}
+}
+
+func (r *REST) reject(c *gin.Context, status int, reason, msg string, retryAfter time.Duration) {
+	r.metrics.rejected.WithLabelValues(reason).Inc()
+
+	seconds := (retryAfter + time.Second - 1) / time.Second
+	c.Header("Retry-After", strconv.FormatInt(int64(seconds), 10))
+	c.AbortWithStatusJSON(status, gin.H{"error": msg})
+}
+
+// observeWrite records the latency of a write to the storage
+func (r *REST) observeWrite(d time.Duration) {
+	r.metrics.writeLatency.Observe(d.Seconds())
+
+	if a := r.admission; a != nil {
+		a.latencyMu.Lock()
+		a.latency += latencySmoothing * (d.Seconds() - a.latency)
+		a.latencyMu.Unlock()
+	}
+}
+
+// allowClient takes a token from the bucket of the client, or tells how long to wait for one
+func (a *admission) allowClient(id string) (time.Duration, bool) {
+	if a.cfg.ClientRate <= 0 {
+		return 0, true
+	}
+
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+
+	now := a.now()
+	if now.Sub(a.swept) > idleClientTimeout {
+		for k, b := range a.clients {
+			if now.Sub(b.last) > idleClientTimeout {
+				delete(a.clients, k)
+			}
+		}
+		a.swept = now
+	}
+
+	b, ok := a.clients[id]
+	if !ok {
+		b = &tokenBucket{tokens: float64(a.cfg.ClientBurst), last: now}
+		a.clients[id] = b
+	}
+
+	return b.take(now, a.cfg.ClientRate, float64(a.cfg.ClientBurst))
+}
+
+// refundClient gives back the token taken from the bucket of the client
+func (a *admission) refundClient(id string) {
+	if a.cfg.ClientRate <= 0 {
+		return
+	}
+
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+
+	if b, ok := a.clients[id]; ok {
+		b.tokens = math.Min(b.tokens+1, float64(a.cfg.ClientBurst))
+	}
+}
+
+// overloaded tells whether the write is to be shed, except for a probe every overloadProbeInterval
+func (a *admission) overloaded() bool {
+	if a.cfg.LatencyThreshold <= 0 {
+		return false
+	}
+
+	a.latencyMu.Lock()
+	defer a.latencyMu.Unlock()
+
+	if a.latency <= a.cfg.LatencyThreshold.Seconds() {
+		return false
+	}
+
+	now := a.now()
+	if now.Sub(a.probed) >= overloadProbeInterval {
+		a.probed = now
+		return false
+	}
+
+	return true
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(now time.Time, rate, burst float64) (time.Duration, bool) {
+	b.tokens = math.Min(b.tokens+now.Sub(b.last).Seconds()*rate, burst)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration(math.Ceil((1 - b.tokens) / rate * float64(time.Second))), false
+	}
+
+	b.tokens--
+	return 0, true
+}
+
+// clientID identifies the client by its credential if authenticated, or else by its IP
+func clientID(c *gin.Context) string {
+	if p, ok := requestPrincipal(c); ok {
+		return "principal:" + p.ID
+	}
+
+	return ipClientID(c)
+}
+
+func ipClientID(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// isWrite tells whether the request writes entries to the storage
+func isWrite(c *gin.Context) bool {
+	route := c.FullPath()
+	return c.Request.Method == http.MethodPost && !strings.HasPrefix(route, "/keys") && !strings.HasSuffix(route, "/shred")
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingStorageMock holds every write until released
+type blockingStorageMock struct {
+	storageMock
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStorageMock) WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return map[string]any{"written": 1}, nil
+}
+
+func TestAdmission(t *testing.T) {
+	do := func(r *REST, method, url, ip string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader("a sample log entry"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.RemoteAddr = ip + ":12345"
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("client rate", func(t *testing.T) {
+		r := NewREST(&storageMock{}, "localhost:8000", 10*time.Second, WithAdmission(AdmissionConfig{ClientRate: 0.5, ClientBurst: 2}))
+
+		require.Equal(t, http.StatusOK, do(r, "POST", "/add", "10.0.0.1").Code)
+		require.Equal(t, http.StatusOK, do(r, "GET", "/count", "10.0.0.1").Code)
+
+		w := do(r, "POST", "/add", "10.0.0.1")
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		require.Equal(t, "2", w.Header().Get("Retry-After"))
+
+		// every client has its own bucket
+		require.Equal(t, http.StatusOK, do(r, "POST", "/add", "10.0.0.2").Code)
+	})
+
+	t.Run("before authentication", func(t *testing.T) {
+		store, err := auth.NewStore("")
+		require.NoError(t, err)
+		store.Bootstrap("imk_admin")
+		r := NewREST(&storageMock{}, "localhost:8000", 10*time.Second,
+			WithAuth(store, bucket.NewBucket("audit")), WithAdmission(AdmissionConfig{ClientRate: 0.5, ClientBurst: 2}))
+
+		withKey := func(ip, key string) int {
+			req, _ := http.NewRequest("GET", "/count", nil)
+			req.Header.Set("X-API-Key", key)
+			req.RemoteAddr = ip + ":12345"
+			w := httptest.NewRecorder()
+			r.srv.Handler.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		// the authenticated requests are limited per credential, not counting against their IP
+		require.Equal(t, http.StatusOK, withKey("10.0.0.1", "imk_admin"))
+		require.Equal(t, http.StatusOK, withKey("10.0.0.1", "imk_admin"))
+		require.Equal(t, http.StatusTooManyRequests, withKey("10.0.0.1", "imk_admin"))
+		require.Equal(t, http.StatusUnauthorized, withKey("10.0.0.1", "imk_nope"))
+
+		// the failing ones are limited per IP before their credentials are checked
+		require.Equal(t, http.StatusUnauthorized, withKey("10.0.0.2", "imk_nope"))
+		require.Equal(t, http.StatusUnauthorized, withKey("10.0.0.2", "imk_nope"))
+		require.Equal(t, http.StatusTooManyRequests, withKey("10.0.0.2", "imk_nope"))
+	})
+
+	t.Run("in-flight writes", func(t *testing.T) {
+		s := &blockingStorageMock{started: make(chan struct{}), release: make(chan struct{})}
+		r := NewREST(s, "localhost:8000", 10*time.Second, WithAdmission(AdmissionConfig{MaxInFlightWrites: 1}))
+
+		done := make(chan int)
+		go func() { done <- do(r, "POST", "/add", "10.0.0.1").Code }()
+		<-s.started
+
+		require.Equal(t, http.StatusServiceUnavailable, do(r, "POST", "/add", "10.0.0.2").Code)
+		// reads aren't capped
+		require.Equal(t, http.StatusOK, do(r, "GET", "/count", "10.0.0.2").Code)
+
+		close(s.release)
+		require.Equal(t, http.StatusOK, <-done)
+
+		go func() { <-s.started }()
+		require.Equal(t, http.StatusOK, do(r, "POST", "/add", "10.0.0.2").Code)
+	})
+
+	t.Run("load shedding", func(t *testing.T) {
+		r := NewREST(&storageMock{}, "localhost:8000", 10*time.Second, WithAdmission(AdmissionConfig{LatencyThreshold: 100 * time.Millisecond}))
+
+		now := time.Now()
+		r.admission.now = func() time.Time { return now }
+		r.observeWrite(time.Second)
+
+		// a probe gets through, then writes are shed until the next one
+		require.Equal(t, http.StatusOK, do(r, "POST", "/add", "10.0.0.1").Code)
+		require.Equal(t, http.StatusServiceUnavailable, do(r, "POST", "/add", "10.0.0.1").Code)
+		require.Equal(t, http.StatusOK, do(r, "GET", "/count", "10.0.0.1").Code)
+
+		// the fast writes of the storage mock bring the average latency back down
+		for i := 0; i < 20; i++ {
+			now = now.Add(overloadProbeInterval)
+			require.Equal(t, http.StatusOK, do(r, "POST", "/add", "10.0.0.1").Code)
+		}
+		require.Equal(t, http.StatusOK, do(r, "POST", "/add", "10.0.0.1").Code)
+
+		w := do(r, "GET", "/metrics", "10.0.0.1")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `immulogs_admission_rejected_total{reason="overload"} 1`)
+		require.Contains(t, w.Body.String(), `immulogs_http_requests_total{method="POST",route="/add",status="503"} 1`)
+		require.Contains(t, w.Body.String(), `immulogs_storage_write_seconds_count 23`)
+	})
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+const (
+	// principalContext holds the auth.Principal of an authenticated request
+	principalContext = "immulogs.principal"
+
+	// auditRejectionsInterval is how often the rejections of a client with a given status are recorded
+	auditRejectionsInterval = time.Minute
+)
+
+// WithAuth requires an API key on every route, each key being allowed to use only some of the buckets,
+// every authenticated request is recorded in the audit bucket, and the rejected ones once a minute per client and status
+func WithAuth(store *auth.Store, audit bucket.Bucket) RESTOption {
+	return func(r *REST) {
+		r.keys = store
+		r.auditBucket = audit
+	}
+}
+
+// WithTokens accepts bearer JWTs, their claims being mapped to buckets by the verifier, alongside API keys if enabled,
+// every authenticated request is recorded in the audit bucket, and the rejected ones once a minute per client and status
+func WithTokens(v *auth.TokenVerifier, audit bucket.Bucket) RESTOption {
+	return func(r *REST) {
+		r.tokens = v
+		r.auditBucket = audit
+	}
+}
+
+// WithClientCertificates authenticates the clients presenting a certificate verified by the TLS handshake,
+// their grants being given by the rules matching the certificate subject, unless they use an API key or a token,
+// every authenticated request is recorded in the audit bucket, and the rejected ones once a minute per client and status
+func WithClientCertificates(rules []auth.Rule, audit bucket.Bucket) RESTOption {
+	return func(r *REST) {
+		r.certRules = rules
+		r.clientCerts = true
+		r.auditBucket = audit
+	}
+}
+
+// authenticate is the middleware enforcing the permissions of the API keys and tokens on every route
+func (r *REST) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permission, b, checked := r.routePermission(c)
+
+		p, err := r.principal(c.Request)
+		switch {
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case b == r.auditBucket.String() && permission != auth.PermissionRead:
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "the audit bucket is read-only"})
+		case checked && !p.Allows(permission, b):
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed to " + string(permission) + " " + bucketLabel(b)})
+		default:
+			c.Set(principalContext, p)
+			c.Next()
+		}
+
+		if !rejected(c.Writer.Status()) {
+			r.audit(c, p, permission, b, nil)
+		} else if repeated, ok := r.rejections.sample(c, p); ok {
+			r.audit(c, p, permission, b, repeated)
+		}
+	}
+}
+
+// rejected tells whether the status rejects the request before it's served, as floods of them are to be expected
+func rejected(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// routePermission is the permission the matched route requires on its bucket,
+// unless checked is false and the route enforces permissions on its own
+func (r *REST) routePermission(c *gin.Context) (auth.Permission, string, bool) {
+	route := c.FullPath()
+	switch {
+	case strings.HasPrefix(route, "/keys"):
+		return auth.PermissionAdmin, "", false
+	case route == "/buckets":
+		return auth.PermissionRead, "", false
+	case route == "/usage":
+		return auth.PermissionRead, c.Query("bucket"), true
+	case route == "/checkpoints":
+		return auth.PermissionRead, r.checkpointsBucket.String(), true
+	case strings.HasSuffix(route, "/import"), strings.HasSuffix(route, "/shred"):
+		return auth.PermissionAdmin, c.Param("bucket"), true
+	case c.Request.Method == http.MethodPost:
+		return auth.PermissionWrite, c.Param("bucket"), true
+	default:
+		return auth.PermissionRead, c.Param("bucket"), true
+	}
+}
+
+// principal authenticates the request with the API key of the X-API-Key header,
+// or the bearer token of the Authorization one, which is either an API key or a JWT,
+// or else with the client certificate
+func (r *REST) principal(req *http.Request) (auth.Principal, error) {
+	secret := req.Header.Get("X-API-Key")
+	token, bearer := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if secret == "" && bearer && strings.HasPrefix(token, auth.KeyPrefix) {
+		secret = token
+	}
+
+	switch {
+	case secret != "" && r.keys != nil:
+		key, err := r.keys.Authenticate(secret)
+		if err != nil {
+			return auth.Principal{}, err
+		}
+		return key.Principal(), nil
+	case secret == "" && bearer && r.tokens != nil:
+		return r.tokens.Verify(token)
+	case secret == "" && !bearer && r.clientCerts && req.TLS != nil && len(req.TLS.VerifiedChains) > 0:
+		return auth.CertificatePrincipal(req.TLS.VerifiedChains[0][0], r.certRules), nil
+	default:
+		return auth.Principal{}, errors.New("missing or unsupported credentials")
+	}
+}
+
+// audit records the request in the audit bucket of its tenant, or of the default storage if rejected before the tenant is known,
+// along with the number of the same rejections left out since the last one recorded, if any
+func (r *REST) audit(c *gin.Context, p auth.Principal, permission auth.Permission, b string, repeated *int) {
+	fields := map[string]any{
+		log.TimestampKey: time.Now().UTC().Format(time.RFC3339Nano),
+		"key_id":         p.ID,
+		"key_name":       p.Name,
+		"tenant":         requestTenant(c),
+		"method":         c.Request.Method,
+		"path":           c.Request.URL.Path,
+		"bucket":         b,
+		"permission":     permission,
+		"status":         c.Writer.Status(),
+		"client_ip":      c.ClientIP(),
+	}
+	if repeated != nil {
+		fields["repeated"] = *repeated
+	}
+
+	_, err := r.storageOf(c).WriteOne(r.auditBucket, log.FromFields(fields))
+	if err != nil {
+		stdlog.Printf("auditing %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+	}
+}
+
+// rejectionsAudit samples the rejected requests, keeping one per client and status every auditRejectionsInterval,
+// so that floods of them don't write one audit entry each
+type rejectionsAudit struct {
+	mu      sync.Mutex
+	clients map[string]*rejections
+	swept   time.Time
+
+	now func() time.Time
+}
+
+// rejections counts the ones left out since the last one recorded
+type rejections struct {
+	recorded time.Time
+	repeated int
+}
+
+func newRejectionsAudit() *rejectionsAudit {
+	return &rejectionsAudit{clients: map[string]*rejections{}, now: time.Now}
+}
+
+// sample tells whether the rejection is to be recorded, along with the number of those left out since the last one,
+// the clients idle for idleClientTimeout are forgotten, with the rejections left out then
+func (a *rejectionsAudit) sample(c *gin.Context, p auth.Principal) (*int, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	if now.Sub(a.swept) > idleClientTimeout {
+		for k, rj := range a.clients {
+			if now.Sub(rj.recorded) > idleClientTimeout {
+				delete(a.clients, k)
+			}
+		}
+		a.swept = now
+	}
+
+	key := fmt.Sprintf("%s %s %d", c.ClientIP(), p.ID, c.Writer.Status())
+	rj, ok := a.clients[key]
+	if !ok {
+		a.clients[key] = &rejections{recorded: now}
+		return nil, true
+	}
+
+	if now.Sub(rj.recorded) < auditRejectionsInterval {
+		rj.repeated++
+		return nil, false
+	}
+
+	repeated := rj.repeated
+	*rj = rejections{recorded: now}
+	return &repeated, true
+}
+
+func bucketLabel(b string) string {
+	if b == "" {
+		return "all the buckets"
+	}
+
+	return "bucket " + b
+}
+
+// requestPrincipal is who the request is made by, ok is false when authentication isn't enabled
+func requestPrincipal(c *gin.Context) (auth.Principal, bool) {
+	v, ok := c.Get(principalContext)
+	if !ok {
+		return auth.Principal{}, false
+	}
+
+	return v.(auth.Principal), true
+}
+
+type createKeyRequest struct {
+	Name        string            `json:"name"`
+	Buckets     []string          `json:"buckets"`
+	Permissions []auth.Permission `json:"permissions"`
+}
+
+// registerKeyRoutes adds the admin API managing the keys, a key may only manage keys within the buckets it administers,
+// requests made within a tenant manage the keys bound to it
+func (r *REST) registerKeyRoutes(router gin.IRoutes) {
+	enabled := func() error {
+		if r.keys == nil {
+			return &statusError{http.StatusNotImplemented, errors.New("authentication is not enabled")}
+		}
+		return nil
+	}
+
+	router.POST("/keys", ginWrapper(func(c *gin.Context) (gin.H, error) {
+		if err := enabled(); err != nil {
+			return nil, err
+		}
+
+		var req createKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return nil, &statusError{http.StatusBadRequest, err}
+		}
+
+		if caller, _ := requestPrincipal(c); !caller.Administers(req.Buckets) {
+			return nil, &statusError{http.StatusForbidden, errors.New("not allowed to administer all these buckets")}
+		}
+
+		key, secret, err := r.keys.Create(req.Name, requestTenant(c), req.Buckets, req.Permissions)
+		if err != nil {
+			return nil, &statusError{http.StatusBadRequest, err}
+		}
+
+		return gin.H{"key": key, "secret": secret}, nil
+	}))
+	router.GET("/keys", ginWrapper(func(c *gin.Context) (gin.H, error) {
+		if err := enabled(); err != nil {
+			return nil, err
+		}
+
+		caller, _ := requestPrincipal(c)
+		keys := []auth.Key{}
+		for _, k := range r.keys.Keys() {
+			if caller.Administers(k.Buckets) && withinTenant(c, k) {
+				keys = append(keys, k)
+			}
+		}
+
+		return gin.H{"keys": keys}, nil
+	}))
+	router.DELETE("/keys/:id", ginWrapper(func(c *gin.Context) (gin.H, error) {
+		if err := enabled(); err != nil {
+			return nil, err
+		}
+
+		key, err := r.keys.Get(c.Param("id"))
+		if err == nil && !withinTenant(c, key) {
+			err = auth.ErrNotFound
+		}
+		if errors.Is(err, auth.ErrNotFound) {
+			return nil, &statusError{http.StatusNotFound, err}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if caller, _ := requestPrincipal(c); !caller.Administers(key.Buckets) {
+			return nil, &statusError{http.StatusForbidden, errors.New("not allowed to administer all the buckets of this key")}
+		}
+
+		key, err = r.keys.Revoke(key.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return gin.H{"key": key}, nil
+	}))
+}
+
+// withinTenant tells whether the key may be managed by the request, i.e. is bound to its tenant if it's made within one
+func withinTenant(c *gin.Context, k auth.Key) bool {
+	t := requestTenant(c)
+	return t == "" || k.Tenant == t
+}
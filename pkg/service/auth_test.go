@@ -0,0 +1,227 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/storage"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth(t *testing.T) {
+	store, err := auth.NewStore("")
+	require.NoError(t, err)
+	store.Bootstrap("imk_admin")
+
+	s := storage.NewMemory()
+	r := NewREST(s, "localhost:8000", 10*time.Second, WithAuth(store, bucket.NewBucket("audit")))
+
+	do := func(t *testing.T, method, url, key, body string) (int, map[string]any) {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+
+		var res map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return w.Code, res
+	}
+
+	code, res := do(t, "POST", "/keys", "imk_admin", `{"name":"ci","buckets":["jobs/*"],"permissions":["write","admin"]}`)
+	require.Equal(t, http.StatusOK, code)
+	ci := res["secret"].(string)
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		code, _ := do(t, "GET", "/jobs%2Fnightly/count", "", "")
+		require.Equal(t, http.StatusUnauthorized, code)
+
+		code, _ = do(t, "GET", "/jobs%2Fnightly/count", "imk_nope", "")
+		require.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("permissions", func(t *testing.T) {
+		code, _ := do(t, "POST", "/jobs%2Fnightly/add", ci, "a sample log entry")
+		require.Equal(t, http.StatusOK, code)
+
+		code, _ = do(t, "POST", "/app/add", ci, "a sample log entry")
+		require.Equal(t, http.StatusForbidden, code)
+
+		code, res := do(t, "GET", "/jobs%2Fnightly/count", ci, "")
+		require.Equal(t, http.StatusForbidden, code)
+		require.Equal(t, "not allowed to read bucket jobs/nightly", res["error"])
+
+		code, _ = do(t, "GET", "/jobs%2Fnightly/count", "imk_admin", "")
+		require.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("scoped administration", func(t *testing.T) {
+		code, _ := do(t, "POST", "/keys", ci, `{"name":"everything","buckets":["*"],"permissions":["read"]}`)
+		require.Equal(t, http.StatusForbidden, code)
+
+		code, res := do(t, "POST", "/keys", ci, `{"name":"nightly","buckets":["jobs/nightly"],"permissions":["read"]}`)
+		require.Equal(t, http.StatusOK, code)
+		nightly := res["secret"].(string)
+
+		code, res = do(t, "GET", "/buckets", nightly, "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []any{"jobs/nightly"}, res["buckets"])
+
+		code, res = do(t, "GET", "/keys", ci, "")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, res["keys"], 2)
+
+		id := res["keys"].([]any)[1].(map[string]any)["id"].(string)
+		code, _ = do(t, "DELETE", "/keys/"+id, ci, "")
+		require.Equal(t, http.StatusOK, code)
+
+		code, _ = do(t, "GET", "/jobs%2Fnightly/count", nightly, "")
+		require.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		code, _ := do(t, "POST", "/audit/add", "imk_admin", "forged")
+		require.Equal(t, http.StatusForbidden, code)
+
+		code, res := do(t, "GET", "/audit/last/0", "imk_admin", "")
+		require.Equal(t, http.StatusOK, code)
+
+		entries := res["entries"].([]any)
+		require.NotEmpty(t, entries)
+
+		first := entries[0].(map[string]any)
+		require.Equal(t, "/keys", first["path"])
+		require.Equal(t, "bootstrap", first["key_id"])
+		require.Equal(t, 200., first["status"])
+
+		second := entries[1].(map[string]any)
+		require.Equal(t, "", second["key_id"])
+		require.Equal(t, 401., second["status"])
+	})
+
+	t.Run("audited rejections", func(t *testing.T) {
+		s := storage.NewMemory()
+		r := NewREST(s, "localhost:8000", 10*time.Second, WithAuth(store, bucket.NewBucket("audit")))
+		now := time.Now()
+		r.rejections.now = func() time.Time { return now }
+
+		flood := func(n int) {
+			for i := 0; i < n; i++ {
+				req, _ := http.NewRequest("GET", "/count", nil)
+				w := httptest.NewRecorder()
+				r.srv.Handler.ServeHTTP(w, req)
+				require.Equal(t, http.StatusUnauthorized, w.Code)
+			}
+		}
+
+		// the first one is recorded, the next ones once a minute along with the number left out
+		flood(10)
+		now = now.Add(auditRejectionsInterval)
+		flood(1)
+
+		entries, err := s.All(bucket.NewBucket("audit"))
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+
+		var first, second map[string]any
+		require.NoError(t, json.Unmarshal(entries[0].Bytes(), &first))
+		require.NoError(t, json.Unmarshal(entries[1].Bytes(), &second))
+		require.NotContains(t, first, "repeated")
+		require.Equal(t, 9., second["repeated"])
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/keys", nil)
+		w := httptest.NewRecorder()
+		NewREST(s, "localhost:8000", 10*time.Second).srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+}
+
+func TestTokens(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	v, err := auth.NewTokenVerifier([]auth.VerificationKey{{Key: key.Public()}}, "", "immulogs", []auth.Rule{
+		{Claim: "groups", Value: "sre", Grant: auth.Grant{Buckets: []string{"*"}, Permissions: []auth.Permission{auth.PermissionRead}}},
+	})
+	require.NoError(t, err)
+
+	store, err := auth.NewStore("")
+	require.NoError(t, err)
+	store.Bootstrap("imk_admin")
+
+	audit := bucket.NewBucket("audit")
+	r := NewREST(storage.NewMemory(), "localhost:8000", 10*time.Second, WithAuth(store, audit), WithTokens(v, audit))
+
+	get := func(t *testing.T, url, token string) int {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	token := func(t *testing.T, claims map[string]any) string {
+		claims["sub"] = "alice"
+		token, err := auth.SignToken(key, "", claims)
+		require.NoError(t, err)
+		return token
+	}
+
+	valid := token(t, map[string]any{"aud": "immulogs", "groups": []string{"sre"}, "exp": time.Now().Add(time.Hour).Unix()})
+	require.Equal(t, http.StatusOK, get(t, "/app/count", valid))
+	require.Equal(t, http.StatusOK, get(t, "/audit/last/0", valid))
+
+	noGroup := token(t, map[string]any{"aud": "immulogs", "exp": time.Now().Add(time.Hour).Unix()})
+	require.Equal(t, http.StatusForbidden, get(t, "/app/count", noGroup))
+
+	expired := token(t, map[string]any{"aud": "immulogs", "groups": []string{"sre"}, "exp": time.Now().Add(-time.Hour).Unix()})
+	require.Equal(t, http.StatusUnauthorized, get(t, "/app/count", expired))
+
+	wrongAudience := token(t, map[string]any{"aud": "grafana", "groups": []string{"sre"}, "exp": time.Now().Add(time.Hour).Unix()})
+	require.Equal(t, http.StatusUnauthorized, get(t, "/app/count", wrongAudience))
+
+	// API keys still work as bearer tokens
+	require.Equal(t, http.StatusOK, get(t, "/app/count", "imk_admin"))
+}
+
+func TestClientCertificates(t *testing.T) {
+	rules := []auth.Rule{
+		{Claim: "cn", Value: "billing-*", Grant: auth.Grant{Buckets: []string{"billing"}, Permissions: []auth.Permission{auth.PermissionWrite}}},
+	}
+	r := NewREST(storage.NewMemory(), "localhost:8000", 10*time.Second, WithClientCertificates(rules, bucket.NewBucket("audit")))
+
+	post := func(t *testing.T, url string, cert *x509.Certificate) int {
+		req, _ := http.NewRequest("POST", url, strings.NewReader("a sample log entry"))
+		if cert != nil {
+			// as verified by the TLS handshake
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing-worker"}}
+	require.Equal(t, http.StatusOK, post(t, "/billing/add", cert))
+	require.Equal(t, http.StatusForbidden, post(t, "/app/add", cert))
+	require.Equal(t, http.StatusUnauthorized, post(t, "/billing/add", nil))
+
+	req, _ := http.NewRequest("GET", "/audit/last/1", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	w := httptest.NewRecorder()
+	r.srv.Handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+const (
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeJSONL  = "application/jsonl"
+	contentTypeJSON   = "application/json"
+	contentTypeText   = "text/plain"
+
+	// maxLineSize limits a single line of text and NDJSON batches
+	maxLineSize = 1 << 20
+)
+
+// batchError points at the offending part of a batch request body
+type batchError struct {
+	// Line is set for line-oriented formats (text, NDJSON)
+	Line int
+	// Entry is set for JSON arrays
+	Entry int
+
+	Err error
+}
+
+func (e *batchError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+
+	return fmt.Sprintf("entry %d: %v", e.Entry, e.Err)
+}
+
+func (e *batchError) Unwrap() error {
+	return e.Err
+}
+
+func (e *batchError) response() map[string]any {
+	res := map[string]any{"error": e.Error()}
+	if e.Line > 0 {
+		res["line"] = e.Line
+	} else {
+		res["entry"] = e.Entry
+	}
+
+	return res
+}
+
+// batchDecoder reads log entries one by one off a batch request body
+// Next returns io.EOF once all the entries have been read
+type batchDecoder struct {
+	next    func() (log.Entry, error)
+	closeFn func()
+}
+
+func (d *batchDecoder) Next() (log.Entry, error) {
+	return d.next()
+}
+
+func (d *batchDecoder) Close() {
+	if d.closeFn != nil {
+		d.closeFn()
+	}
+}
+
+// newBatchDecoder picks the body format and compression according to the Content-Type and Content-Encoding headers,
+// a missing Content-Type is treated as newline-separated text
+func newBatchDecoder(contentType, contentEncoding string, body io.Reader) (*batchDecoder, error) {
+	d := &batchDecoder{}
+
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, &statusError{http.StatusBadRequest, fmt.Errorf("malformed gzip body: %w", err)}
+		}
+		body = r
+	case "zstd":
+		r, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, &statusError{http.StatusBadRequest, fmt.Errorf("malformed zstd body: %w", err)}
+		}
+		body = r
+		d.closeFn = r.Close
+	default:
+		return nil, &statusError{http.StatusUnsupportedMediaType, fmt.Errorf("unsupported Content-Encoding %q", contentEncoding)}
+	}
+
+	mediaType := contentTypeText
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, &statusError{http.StatusUnsupportedMediaType, fmt.Errorf("malformed Content-Type %q", contentType)}
+		}
+	}
+
+	switch mediaType {
+	case contentTypeText:
+		d.next = lineDecoder(body, func(line []byte) (log.Entry, error) {
+			return log.FromBytes(line), nil
+		})
+	case contentTypeNDJSON, contentTypeJSONL:
+		d.next = lineDecoder(body, decodeJSONEntry)
+	case contentTypeJSON:
+		d.next = arrayDecoder(body)
+	default:
+		return nil, &statusError{http.StatusUnsupportedMediaType, fmt.Errorf("unsupported Content-Type %q", mediaType)}
+	}
+
+	return d, nil
+}
+
+// lineDecoder decodes every non-blank line with the given function
+func lineDecoder(r io.Reader, decodeFn func([]byte) (log.Entry, error)) func() (log.Entry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	var line int
+	return func() (log.Entry, error) {
+		for scanner.Scan() {
+			line++
+
+			data := bytes.TrimSuffix(scanner.Bytes(), []byte{'\r'})
+			if len(bytes.TrimSpace(data)) == 0 {
+				continue
+			}
+
+			e, err := decodeFn(append([]byte(nil), data...))
+			if err != nil {
+				return nil, &batchError{Line: line, Err: err}
+			}
+
+			return e, nil
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, &batchError{Line: line + 1, Err: err}
+		}
+
+		return nil, io.EOF
+	}
+}
+
+// arrayDecoder decodes a JSON array of strings or objects
+func arrayDecoder(r io.Reader) func() (log.Entry, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var entry int
+	var started, finished bool
+	return func() (log.Entry, error) {
+		if finished {
+			return nil, io.EOF
+		}
+
+		if !started {
+			started = true
+
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, &batchError{Err: fmt.Errorf("malformed JSON array: %w", err)}
+			}
+
+			if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+				return nil, &batchError{Err: errors.New("expected a JSON array")}
+			}
+		}
+
+		if !dec.More() {
+			finished = true
+
+			if _, err := dec.Token(); err != nil {
+				return nil, &batchError{Entry: entry, Err: fmt.Errorf("malformed JSON array: %w", err)}
+			}
+
+			if err := trailingSpace(io.MultiReader(dec.Buffered(), r), dec.InputOffset()); err != nil {
+				return nil, &batchError{Entry: entry, Err: err}
+			}
+
+			return nil, io.EOF
+		}
+
+		entry++
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, &batchError{Entry: entry, Err: fmt.Errorf("malformed JSON at offset %d: %w", dec.InputOffset(), err)}
+		}
+
+		e, err := decodeJSONEntry(raw)
+		if err != nil {
+			return nil, &batchError{Entry: entry, Err: err}
+		}
+
+		return e, nil
+	}
+}
+
+// trailingSpace makes sure that only whitespace is left, offset is where it starts in the body
+func trailingSpace(r io.Reader, offset int64) error {
+	br := bufio.NewReader(r)
+	for {
+		c, err := br.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			offset++
+		default:
+			return fmt.Errorf("unexpected data after the JSON array at offset %d", offset)
+		}
+	}
+}
+
+// decodeJSONEntry turns a JSON string into a plain entry and a JSON object into a structured one
+func decodeJSONEntry(data []byte) (log.Entry, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("malformed JSON: %w", err)
+	}
+
+	if dec.More() {
+		return nil, errors.New("malformed JSON: unexpected data after the entry")
+	}
+
+	switch v := v.(type) {
+	case string:
+		return log.FromString(v), nil
+	case map[string]any:
+		return log.FromFields(v), nil
+	default:
+		return nil, fmt.Errorf("an entry must be a string or an object, got %s", jsonKind(v))
+	}
+}
+
+func jsonKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case json.Number:
+		return "a number"
+	case []any:
+		return "an array"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
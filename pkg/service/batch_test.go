@@ -0,0 +1,201 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatch(t *testing.T) {
+	gzipped := func(t *testing.T, data string) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	zstded := func(t *testing.T, data string) []byte {
+		w, err := zstd.NewWriter(nil)
+		require.NoError(t, err)
+		defer w.Close()
+		return w.EncodeAll([]byte(data), nil)
+	}
+
+	tests := []struct {
+		name            string
+		contentType     string
+		contentEncoding string
+		body            func(t *testing.T) []byte
+		wantStatus      int
+		wantResponse    map[string]any
+		wantEntries     []log.Entry
+	}{
+		{
+			name:        "no content type",
+			body:        func(t *testing.T) []byte { return []byte("line #1\nline #2\r\n\nline #3\n") },
+			wantStatus:  http.StatusOK,
+			wantEntries: []log.Entry{log.FromString("line #1"), log.FromString("line #2"), log.FromString("line #3")},
+		},
+		{
+			name:        "text",
+			contentType: "text/plain; charset=utf-8",
+			body:        func(t *testing.T) []byte { return []byte(`["not", "parsed"]`) },
+			wantStatus:  http.StatusOK,
+			wantEntries: []log.Entry{log.FromString(`["not", "parsed"]`)},
+		},
+		{
+			name:        "ndjson",
+			contentType: "application/x-ndjson",
+			body: func(t *testing.T) []byte {
+				return []byte("\"plain\"\n{\"level\":\"info\",\"n\":1}\n\n")
+			},
+			wantStatus: http.StatusOK,
+			wantEntries: []log.Entry{
+				log.FromString("plain"),
+				log.FromFields(map[string]any{"level": "info", "n": json.Number("1")}),
+			},
+		},
+		{
+			name:        "ndjson malformed line",
+			contentType: "application/x-ndjson",
+			body:        func(t *testing.T) []byte { return []byte("\"ok\"\n\n{\"broken\":\n\"ok\"\n") },
+			wantStatus:  http.StatusBadRequest,
+			wantResponse: map[string]any{
+				"error": "line 3: malformed JSON: unexpected EOF",
+				"line":  3.,
+			},
+		},
+		{
+			name:        "ndjson wrong kind",
+			contentType: "application/x-ndjson",
+			body:        func(t *testing.T) []byte { return []byte("\"ok\"\n42\n") },
+			wantStatus:  http.StatusBadRequest,
+			wantResponse: map[string]any{
+				"error": "line 2: an entry must be a string or an object, got a number",
+				"line":  2.,
+			},
+		},
+		{
+			name:        "json array",
+			contentType: "application/json",
+			body:        func(t *testing.T) []byte { return []byte(`["plain", {"level": "error"}]`) },
+			wantStatus:  http.StatusOK,
+			wantEntries: []log.Entry{
+				log.FromString("plain"),
+				log.FromFields(map[string]any{"level": "error"}),
+			},
+		},
+		{
+			name:        "json array malformed",
+			contentType: "application/json",
+			body:        func(t *testing.T) []byte { return []byte(`["plain", [1]]`) },
+			wantStatus:  http.StatusBadRequest,
+			wantResponse: map[string]any{
+				"error": "entry 2: an entry must be a string or an object, got an array",
+				"entry": 2.,
+			},
+		},
+		{
+			name:        "json array trailing data",
+			contentType: "application/json",
+			body:        func(t *testing.T) []byte { return []byte("[\"plain\"]\n garbage") },
+			wantStatus:  http.StatusBadRequest,
+			wantResponse: map[string]any{
+				"error": "entry 1: unexpected data after the JSON array at offset 11",
+				"entry": 1.,
+			},
+		},
+		{
+			name:        "json array trailing whitespace",
+			contentType: "application/json",
+			body:        func(t *testing.T) []byte { return []byte("[\"plain\"]\r\n") },
+			wantStatus:  http.StatusOK,
+			wantEntries: []log.Entry{log.FromString("plain")},
+		},
+		{
+			name:        "json not an array",
+			contentType: "application/json",
+			body:        func(t *testing.T) []byte { return []byte(`{"level": "error"}`) },
+			wantStatus:  http.StatusBadRequest,
+			wantResponse: map[string]any{
+				"error": "entry 0: expected a JSON array",
+				"entry": 0.,
+			},
+		},
+		{
+			name:            "gzip",
+			contentType:     "application/x-ndjson",
+			contentEncoding: "gzip",
+			body:            func(t *testing.T) []byte { return gzipped(t, "\"a\"\n\"b\"\n") },
+			wantStatus:      http.StatusOK,
+			wantEntries:     []log.Entry{log.FromString("a"), log.FromString("b")},
+		},
+		{
+			name:            "zstd",
+			contentType:     "text/plain",
+			contentEncoding: "zstd",
+			body:            func(t *testing.T) []byte { return zstded(t, "a\nb\n") },
+			wantStatus:      http.StatusOK,
+			wantEntries:     []log.Entry{log.FromString("a"), log.FromString("b")},
+		},
+		{
+			name:            "malformed gzip",
+			contentEncoding: "gzip",
+			body:            func(t *testing.T) []byte { return []byte("not gzip") },
+			wantStatus:      http.StatusBadRequest,
+			wantResponse:    map[string]any{"error": "malformed gzip body: unexpected EOF"},
+		},
+		{
+			name:            "unsupported encoding",
+			contentEncoding: "br",
+			body:            func(t *testing.T) []byte { return []byte("a") },
+			wantStatus:      http.StatusUnsupportedMediaType,
+			wantResponse:    map[string]any{"error": `unsupported Content-Encoding "br"`},
+		},
+		{
+			name:         "unsupported type",
+			contentType:  "application/xml",
+			body:         func(t *testing.T) []byte { return []byte("<a/>") },
+			wantStatus:   http.StatusUnsupportedMediaType,
+			wantResponse: map[string]any{"error": `unsupported Content-Type "application/xml"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &storageMock{}
+			r := NewREST(s, "localhost:8000", 10*time.Second)
+
+			req, _ := http.NewRequest("POST", "/my-bucket-name/batch", bytes.NewBuffer(tt.body(t)))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tt.contentEncoding)
+			}
+
+			w := httptest.NewRecorder()
+			r.srv.Handler.ServeHTTP(w, req)
+
+			gotResponse, _ := ioutil.ReadAll(w.Body)
+			require.Equal(t, tt.wantStatus, w.Code, string(gotResponse))
+			require.Equal(t, tt.wantEntries, s.entries)
+
+			if tt.wantResponse != nil {
+				var got map[string]any
+				require.NoError(t, json.Unmarshal(gotResponse, &got))
+				require.Equal(t, tt.wantResponse, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	stdlog "log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lootek/go-immulogs/pkg/checkpoint"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+const defaultCheckpointsLimit = 100
+
+// Checkpointer periodically signs the count and the storage state of every bucket, and stores these checkpoints
+// in a dedicated bucket, so that third parties holding them can detect history being rewritten or forked later on
+type Checkpointer struct {
+	storage  Storage
+	key      ed25519.PrivateKey
+	bucket   bucket.Bucket
+	interval time.Duration
+
+	stopOnce sync.Once
+	stop     chan struct{}
+
+	// counts of the buckets as of their latest checkpoints, buckets which didn't grow aren't checkpointed again
+	counts map[string]uint64
+}
+
+func NewCheckpointer(s Storage, key ed25519.PrivateKey, b bucket.Bucket, interval time.Duration) *Checkpointer {
+	return &Checkpointer{
+		storage:  s,
+		key:      key,
+		bucket:   b,
+		interval: interval,
+		stop:     make(chan struct{}),
+		counts:   map[string]uint64{},
+	}
+}
+
+func (c *Checkpointer) Start(ctx context.Context) error {
+	if _, ok := c.storage.(Stater); !ok {
+		return errors.New("checkpoints are not supported by this storage")
+	}
+	if _, ok := c.storage.(BucketLister); !ok {
+		return errors.New("checkpoints are not supported by this storage")
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.stop:
+			return nil
+		case <-ticker.C:
+			if err := c.Checkpoint(time.Now()); err != nil {
+				stdlog.Printf("checkpointing: %v", err)
+			}
+		}
+	}
+}
+
+func (c *Checkpointer) Stop() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	return nil
+}
+
+// Checkpoint signs and stores a checkpoint of every bucket which grew since its latest checkpoint
+func (c *Checkpointer) Checkpoint(now time.Time) error {
+	buckets, err := c.storage.(BucketLister).Buckets()
+	if err != nil {
+		return err
+	}
+
+	var checkpoints []log.Entry
+	for _, b := range buckets {
+		if b.String() == c.bucket.String() {
+			continue
+		}
+
+		cnt, state, err := c.storage.(Stater).State(b)
+		if err != nil {
+			return err
+		}
+
+		if last, ok := c.counts[b.String()]; ok && last == cnt {
+			continue
+		}
+
+		cp := checkpoint.New(b.String(), cnt, state, now)
+		cp.Sign(c.key)
+
+		e, err := checkpointEntry(cp)
+		if err != nil {
+			return err
+		}
+
+		checkpoints = append(checkpoints, e)
+		c.counts[b.String()] = cnt
+	}
+
+	if len(checkpoints) == 0 {
+		return nil
+	}
+
+	_, err = c.storage.WriteBatch(c.bucket, checkpoints)
+	return err
+}
+
+func checkpointEntry(cp checkpoint.Checkpoint) (log.Entry, error) {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return nil, err
+	}
+
+	var f map[string]any
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+
+	return log.FromFields(f), nil
+}
+
+// checkpointsHandler lists the latest checkpoints, optionally of a single bucket, along with the key to verify them
+func checkpointsHandler(storageOf func(*gin.Context) Storage, b bucket.Bucket, key ed25519.PublicKey) gin.HandlerFunc {
+	return ginWrapper(func(c *gin.Context) (gin.H, error) {
+		if key == nil {
+			return nil, &statusError{http.StatusNotImplemented, errors.New("checkpoints are not enabled")}
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultCheckpointsLimit)))
+		if err != nil || limit < 1 {
+			return nil, &statusError{http.StatusBadRequest, errors.New("limit must be a positive number")}
+		}
+
+		entries, err := storageOf(c).All(b)
+		if err != nil {
+			return nil, err
+		}
+
+		// the most recent ones, in the storage order
+		checkpoints := []checkpoint.Checkpoint{}
+		for i := len(entries) - 1; i >= 0 && len(checkpoints) < limit; i-- {
+			// anything else written to the bucket isn't a checkpoint
+			var cp checkpoint.Checkpoint
+			if err := json.Unmarshal(entries[i].Bytes(), &cp); err != nil || cp.Signature == nil {
+				continue
+			}
+
+			if name := c.Query("bucket"); name != "" && name != cp.Bucket {
+				continue
+			}
+
+			checkpoints = append(checkpoints, cp)
+		}
+
+		for i, j := 0, len(checkpoints)-1; i < j; i, j = i+1, j-1 {
+			checkpoints[i], checkpoints[j] = checkpoints[j], checkpoints[i]
+		}
+
+		publicKey, err := checkpoint.EncodePublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return gin.H{"checkpoints": checkpoints, "public_key": publicKey}, nil
+	})
+}
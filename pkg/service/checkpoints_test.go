@@ -0,0 +1,93 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/checkpoint"
+	"github.com/lootek/go-immulogs/pkg/storage"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoints(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	s := storage.NewMemory()
+	checkpoints := bucket.NewBucket("checkpoints")
+	cp := NewCheckpointer(s, priv, checkpoints, time.Minute)
+	r := NewREST(s, "localhost:8000", 10*time.Second, WithCheckpoints(checkpoints, pub))
+
+	list := func(t *testing.T, url string) []checkpoint.Checkpoint {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Checkpoints []checkpoint.Checkpoint `json:"checkpoints"`
+			PublicKey   string                  `json:"public_key"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.Contains(t, res.PublicKey, "BEGIN PUBLIC KEY")
+
+		for _, c := range res.Checkpoints {
+			require.NoError(t, c.Verify(pub))
+		}
+
+		return res.Checkpoints
+	}
+
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	_, err = s.WriteBatch(bucket.NewBucket("a"), []log.Entry{log.FromString("#1"), log.FromString("#2")})
+	require.NoError(t, err)
+	_, err = s.WriteOne(bucket.NewBucket("b"), log.FromString("#1"))
+	require.NoError(t, err)
+
+	require.NoError(t, cp.Checkpoint(now))
+
+	got := list(t, "/checkpoints")
+	require.Len(t, got, 2)
+	require.Equal(t, "a", got[0].Bucket)
+	require.Equal(t, uint64(2), got[0].Count)
+	require.Equal(t, "b", got[1].Bucket)
+	require.Equal(t, uint64(1), got[1].Count)
+	require.Equal(t, uint64(2), got[1].TxID)
+	require.Equal(t, now, got[1].CreatedAt)
+
+	t.Run("only buckets which grew", func(t *testing.T) {
+		_, err = s.WriteOne(bucket.NewBucket("b"), log.FromString("#2"))
+		require.NoError(t, err)
+
+		require.NoError(t, cp.Checkpoint(now.Add(time.Minute)))
+		require.Len(t, list(t, "/checkpoints"), 3)
+
+		got := list(t, "/checkpoints?bucket=b")
+		require.Len(t, got, 2)
+		require.Equal(t, uint64(2), got[1].Count)
+		require.NotEqual(t, got[0].TxHash, got[1].TxHash)
+
+		require.NoError(t, cp.Checkpoint(now.Add(2*time.Minute)))
+		require.Len(t, list(t, "/checkpoints"), 3)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		got := list(t, "/checkpoints?limit=1")
+		require.Len(t, got, 1)
+		require.Equal(t, "b", got[0].Bucket)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/checkpoints", nil)
+		w := httptest.NewRecorder()
+		NewREST(s, "localhost:8000", 10*time.Second).srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lootek/go-immulogs/pkg/archive"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+)
+
+var archiveContentTypes = map[string]string{
+	archive.FormatNDJSON: "application/x-ndjson",
+	archive.FormatTarGz:  "application/gzip",
+}
+
+// exportHandler streams a bucket as an archive in the format given by the "format" query parameter, with the proofs
+// leading to the tx given by the "tx" one, e.g. the one of a checkpoint, or else to the current state
+// errors are reported as JSON until the first record is written, afterwards the archive is cut short without its manifest
+func exportHandler(storageOf func(*gin.Context) Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ex, ok := storageOf(c).(Exporter)
+		if !ok {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "exporting is not supported by this storage"})
+			return
+		}
+
+		format := c.DefaultQuery("format", archive.FormatNDJSON)
+		contentType, ok := archiveContentTypes[format]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown archive format %q", format)})
+			return
+		}
+
+		tx, err := strconv.ParseUint(c.DefaultQuery("tx", "0"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tx must be a non-negative number"})
+			return
+		}
+
+		b := bucket.NewBucket(c.Param("bucket"))
+		w, err := archive.NewWriter(format, c.Writer, b.String())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, strings.ReplaceAll(b.String(), "/", "_"), format))
+		disableDeadlines(c.Writer)
+
+		state, err := ex.Export(c.Request.Context(), b, tx, w.Write)
+		if err == nil {
+			_, err = w.Close(state)
+		}
+
+		if err != nil {
+			if !c.Writer.Written() {
+				c.Writer.Header().Del("Content-Type")
+				c.Writer.Header().Del("Content-Disposition")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			_ = c.Error(errors.New("export cut short: " + err.Error()))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/archive"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+// exporterStorageMock exports its entries as consecutive transactions, up to the tx unless 0, failing after failAfter
+// records if set
+type exporterStorageMock struct {
+	storageMock
+	failAfter int
+}
+
+func (s *exporterStorageMock) Export(ctx context.Context, b bucket.Bucket, tx uint64, fn func(archive.Record) error) (*archive.State, error) {
+	if tx == 0 {
+		tx = uint64(len(s.entries))
+	}
+
+	for i, e := range s.entries[:tx] {
+		if s.failAfter > 0 && i == s.failAfter {
+			return nil, errors.New("storage unavailable")
+		}
+
+		if err := fn(archive.Record{Tx: uint64(i + 1), Value: e.String()}); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.failAfter < 0 {
+		return nil, errors.New("storage unavailable")
+	}
+
+	return &archive.State{Database: "db", TxID: tx}, nil
+}
+
+func TestExport(t *testing.T) {
+	get := func(t *testing.T, s Storage, url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		NewREST(s, "localhost:8000", 10*time.Second).srv.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	entries := []log.Entry{log.FromString("#1"), log.FromString("#2")}
+
+	t.Run("ndjson", func(t *testing.T) {
+		w := get(t, &exporterStorageMock{storageMock: storageMock{entries: entries}}, "/jobs%2Fnightly/export")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		require.Equal(t, `attachment; filename="jobs_nightly.ndjson"`, w.Header().Get("Content-Disposition"))
+
+		body, _ := ioutil.ReadAll(w.Body)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		require.Len(t, lines, 3)
+		require.Equal(t, `{"tx":1,"value":"#1"}`, lines[0])
+		require.Equal(t, `{"tx":2,"value":"#2"}`, lines[1])
+		require.Contains(t, lines[2], `"bucket":"jobs/nightly","created_at"`)
+		require.Contains(t, lines[2], `"count":2,"first_tx":1,"last_tx":2`)
+		require.Contains(t, lines[2], `"state":{"database":"db","tx_id":2,"tx_hash":null}`)
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		w := get(t, &exporterStorageMock{storageMock: storageMock{entries: entries}}, "/my-bucket-name/export?format=tar.gz")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+		require.Equal(t, []byte{0x1f, 0x8b}, w.Body.Bytes()[:2])
+	})
+
+	t.Run("at a tx", func(t *testing.T) {
+		w := get(t, &exporterStorageMock{storageMock: storageMock{entries: entries}}, "/my-bucket-name/export?tx=1")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		body, _ := ioutil.ReadAll(w.Body)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		require.Len(t, lines, 2)
+		require.Equal(t, `{"tx":1,"value":"#1"}`, lines[0])
+		require.Contains(t, lines[1], `"state":{"database":"db","tx_id":1,"tx_hash":null}`)
+	})
+
+	t.Run("invalid tx", func(t *testing.T) {
+		w := get(t, &exporterStorageMock{}, "/my-bucket-name/export?tx=-1")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Equal(t, `{"error":"tx must be a non-negative number"}`, w.Body.String())
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		w := get(t, &exporterStorageMock{}, "/my-bucket-name/export?format=zip")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Equal(t, `{"error":"unknown archive format \"zip\""}`, w.Body.String())
+	})
+
+	t.Run("unsupported by storage", func(t *testing.T) {
+		w := get(t, &storageMock{}, "/my-bucket-name/export")
+		require.Equal(t, http.StatusNotImplemented, w.Code)
+		require.Equal(t, `{"error":"exporting is not supported by this storage"}`, w.Body.String())
+	})
+
+	t.Run("failing before the first record", func(t *testing.T) {
+		w := get(t, &exporterStorageMock{failAfter: -1}, "/my-bucket-name/export")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+		require.Empty(t, w.Header().Get("Content-Disposition"))
+		require.Equal(t, `{"error":"storage unavailable"}`, w.Body.String())
+	})
+
+	t.Run("cut short", func(t *testing.T) {
+		w := get(t, &exporterStorageMock{storageMock: storageMock{entries: entries}, failAfter: 1}, "/my-bucket-name/export")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "{\"tx\":1,\"value\":\"#1\"}\n", w.Body.String())
+	})
+}
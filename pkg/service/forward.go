@@ -0,0 +1,340 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/ugorji/go/codec"
+)
+
+// Forward is a Fluent Forward protocol (msgpack over TCP) input
+// as described in https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1
+// Message, Forward, PackedForward and CompressedPackedForward modes are supported
+type Forward struct {
+	storage Storage
+
+	address string
+	timeout time.Duration
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+}
+
+func NewForward(s Storage, address string, timeout time.Duration) *Forward {
+	return &Forward{
+		storage: s,
+		address: address,
+		timeout: timeout,
+		conns:   map[net.Conn]struct{}{},
+	}
+}
+
+func (f *Forward) Start(ctx context.Context) error {
+	l, err := net.Listen("tcp", f.address)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.listener = l
+	f.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go f.serve(ctx, conn)
+	}
+}
+
+func (f *Forward) Stop() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for conn := range f.conns {
+		_ = conn.Close()
+	}
+
+	if f.listener == nil {
+		return nil
+	}
+
+	return f.listener.Close()
+}
+
+func (f *Forward) serve(ctx context.Context, conn net.Conn) {
+	f.mu.Lock()
+	f.conns[conn] = struct{}{}
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+
+		_ = conn.Close()
+	}()
+
+	dec := codec.NewDecoder(conn, forwardHandle)
+	enc := codec.NewEncoder(conn, forwardHandle)
+
+	for ctx.Err() == nil {
+		var msg []any
+		if err := dec.Decode(&msg); err != nil {
+			// either the peer went away or it speaks something we don't understand,
+			// in both cases there's no way to recover the stream
+			return
+		}
+
+		tag, entries, option, err := decodeForwardMessage(msg)
+		if err != nil {
+			return
+		}
+
+		if len(entries) > 0 {
+			// no ack is sent unless the entries are committed, so that the client retries them
+			if _, err := f.storage.WriteBatch(bucket.NewBucket(tag), entries); err != nil {
+				continue
+			}
+		}
+
+		chunk, ok := option["chunk"]
+		if !ok {
+			continue
+		}
+
+		if f.timeout > 0 {
+			_ = conn.SetWriteDeadline(time.Now().Add(f.timeout))
+		}
+
+		if err := enc.Encode(map[string]any{"ack": chunk}); err != nil {
+			return
+		}
+	}
+}
+
+// forwardHandle decodes msgpack maps into JSON-friendly map[string]any
+// and understands the EventTime extension type
+var forwardHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.RawToString = true
+	h.WriteExt = true
+	h.MapType = reflect.TypeOf(map[string]any(nil))
+
+	if err := h.SetBytesExt(reflect.TypeOf(eventTime{}), 0, eventTimeExt{}); err != nil {
+		panic(err)
+	}
+
+	return h
+}()
+
+// eventTime is the Fluent EventTime extension (type 0): big-endian seconds and nanoseconds
+type eventTime struct {
+	time.Time
+}
+
+type eventTimeExt struct{}
+
+func (eventTimeExt) WriteExt(v any) []byte {
+	var t time.Time
+	switch v := v.(type) {
+	case eventTime:
+		t = v.Time
+	case *eventTime:
+		t = v.Time
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+
+	return b
+}
+
+func (eventTimeExt) ReadExt(dst any, b []byte) {
+	if len(b) != 8 {
+		return
+	}
+
+	dst.(*eventTime).Time = time.Unix(int64(binary.BigEndian.Uint32(b)), int64(binary.BigEndian.Uint32(b[4:])))
+}
+
+func decodeForwardMessage(msg []any) (string, []log.Entry, map[string]any, error) {
+	if len(msg) < 2 {
+		return "", nil, nil, fmt.Errorf("malformed message of %d elements", len(msg))
+	}
+
+	tag, ok := msg[0].(string)
+	if !ok {
+		return "", nil, nil, fmt.Errorf("malformed tag of type %T", msg[0])
+	}
+
+	optionAt := func(i int) (map[string]any, error) {
+		if len(msg) <= i || msg[i] == nil {
+			return nil, nil
+		}
+
+		option, ok := msg[i].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("malformed option of type %T", msg[i])
+		}
+
+		return option, nil
+	}
+
+	switch v := msg[1].(type) {
+	case []any:
+		// Forward mode: [tag, [[time, record], ...], option]
+		option, err := optionAt(2)
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		var entries []log.Entry
+		for _, e := range v {
+			entry, err := decodeForwardEntry(e)
+			if err != nil {
+				return "", nil, nil, err
+			}
+
+			entries = append(entries, entry)
+		}
+
+		return tag, entries, option, nil
+
+	case string, []byte:
+		// PackedForward mode: [tag, msgpack stream of [time, record], option]
+		option, err := optionAt(2)
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		var r io.Reader
+		if s, ok := v.(string); ok {
+			r = bytes.NewBufferString(s)
+		} else {
+			r = bytes.NewBuffer(v.([]byte))
+		}
+
+		switch option["compressed"] {
+		case nil, "text":
+		case "gzip":
+			if r, err = gzip.NewReader(r); err != nil {
+				return "", nil, nil, err
+			}
+		default:
+			return "", nil, nil, fmt.Errorf("unsupported compression %v", option["compressed"])
+		}
+
+		var entries []log.Entry
+		dec := codec.NewDecoder(r, forwardHandle)
+		for {
+			var e []any
+			if err := dec.Decode(&e); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return "", nil, nil, err
+			}
+
+			entry, err := decodeForwardEntry(e)
+			if err != nil {
+				return "", nil, nil, err
+			}
+
+			entries = append(entries, entry)
+		}
+
+		return tag, entries, option, nil
+
+	default:
+		// Message mode: [tag, time, record, option]
+		if len(msg) < 3 {
+			return "", nil, nil, fmt.Errorf("malformed message of %d elements", len(msg))
+		}
+
+		option, err := optionAt(3)
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		entry, err := decodeForwardEntry([]any{msg[1], msg[2]})
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		return tag, []log.Entry{entry}, option, nil
+	}
+}
+
+func decodeForwardEntry(e any) (log.Entry, error) {
+	pair, ok := e.([]any)
+	if !ok || len(pair) != 2 {
+		return nil, fmt.Errorf("malformed entry %v", e)
+	}
+
+	var ts time.Time
+	switch t := pair[0].(type) {
+	case eventTime:
+		ts = t.Time
+	case int64:
+		ts = time.Unix(t, 0)
+	case uint64:
+		ts = time.Unix(int64(t), 0)
+	case float64:
+		ts = time.Unix(0, int64(t*float64(time.Second)))
+	default:
+		return nil, fmt.Errorf("malformed time of type %T", pair[0])
+	}
+
+	record, ok := pair[1].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("malformed record of type %T", pair[1])
+	}
+
+	fields := make(map[string]any, len(record)+1)
+	for k, v := range record {
+		fields[k] = forwardValue(v)
+	}
+
+	if _, ok := fields[log.TimestampKey]; !ok {
+		fields[log.TimestampKey] = ts.UTC().Format(time.RFC3339Nano)
+	}
+
+	return log.FromFields(fields), nil
+}
+
+// forwardValue turns msgpack binaries into strings so that they don't end up base64-encoded in JSON
+func forwardValue(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case []any:
+		for i := range v {
+			v[i] = forwardValue(v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = forwardValue(v[k])
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+)
+
+type failingStorageMock struct {
+	storageMock
+}
+
+func (s *failingStorageMock) WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, error) {
+	return nil, errors.New("storage unavailable")
+}
+
+func TestForward(t *testing.T) {
+	ts := time.Date(2023, 3, 1, 12, 30, 0, 123, time.UTC)
+
+	packed := func(t *testing.T, compressed bool) []byte {
+		var buf bytes.Buffer
+		enc := codec.NewEncoder(&buf, forwardHandle)
+		require.NoError(t, enc.Encode([]any{eventTime{ts}, map[string]any{"msg": "packed #1"}}))
+		require.NoError(t, enc.Encode([]any{ts.Unix(), map[string]any{"msg": "packed #2"}}))
+
+		if !compressed {
+			return buf.Bytes()
+		}
+
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		_, err := w.Write(buf.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		return gz.Bytes()
+	}
+
+	tests := []struct {
+		name    string
+		message func(t *testing.T) []any
+		want    []log.Entry
+	}{
+		{"message mode", func(t *testing.T) []any {
+			return []any{"app", eventTime{ts}, map[string]any{"msg": "single"}, map[string]any{"chunk": "c1"}}
+		}, []log.Entry{
+			log.FromFields(map[string]any{"msg": "single", log.TimestampKey: "2023-03-01T12:30:00.000000123Z"}),
+		}},
+		{"forward mode", func(t *testing.T) []any {
+			return []any{"app", []any{
+				[]any{eventTime{ts}, map[string]any{"msg": "forward #1"}},
+				[]any{ts.Unix(), map[string]any{"msg": "forward #2", log.TimestampKey: "original"}},
+			}, map[string]any{"chunk": "c1"}}
+		}, []log.Entry{
+			log.FromFields(map[string]any{"msg": "forward #1", log.TimestampKey: "2023-03-01T12:30:00.000000123Z"}),
+			log.FromFields(map[string]any{"msg": "forward #2", log.TimestampKey: "original"}),
+		}},
+		{"packed forward mode", func(t *testing.T) []any {
+			return []any{"app", packed(t, false), map[string]any{"chunk": "c1", "size": 2}}
+		}, []log.Entry{
+			log.FromFields(map[string]any{"msg": "packed #1", log.TimestampKey: "2023-03-01T12:30:00.000000123Z"}),
+			log.FromFields(map[string]any{"msg": "packed #2", log.TimestampKey: "2023-03-01T12:30:00Z"}),
+		}},
+		{"compressed packed forward mode", func(t *testing.T) []any {
+			return []any{"app", packed(t, true), map[string]any{"chunk": "c1", "size": 2, "compressed": "gzip"}}
+		}, []log.Entry{
+			log.FromFields(map[string]any{"msg": "packed #1", log.TimestampKey: "2023-03-01T12:30:00.000000123Z"}),
+			log.FromFields(map[string]any{"msg": "packed #2", log.TimestampKey: "2023-03-01T12:30:00Z"}),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &storageMock{}
+			f := NewForward(s, "", time.Second)
+
+			server, client := net.Pipe()
+			defer client.Close()
+
+			ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancelFn()
+			go f.serve(ctx, server)
+
+			err := codec.NewEncoder(client, forwardHandle).Encode(tt.message(t))
+			require.NoError(t, err)
+
+			var ack map[string]any
+			err = codec.NewDecoder(client, forwardHandle).Decode(&ack)
+			require.NoError(t, err)
+			require.Equal(t, map[string]any{"ack": "c1"}, ack)
+
+			require.Equal(t, tt.want, s.entries)
+		})
+	}
+
+	t.Run("no ack unless committed", func(t *testing.T) {
+		f := NewForward(&failingStorageMock{}, "", time.Second)
+
+		server, client := net.Pipe()
+		defer client.Close()
+
+		ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelFn()
+		go f.serve(ctx, server)
+
+		err := codec.NewEncoder(client, forwardHandle).Encode([]any{"app", ts.Unix(), map[string]any{"msg": "lost"}, map[string]any{"chunk": "c1"}})
+		require.NoError(t, err)
+
+		require.NoError(t, client.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		var ack map[string]any
+		err = codec.NewDecoder(client, forwardHandle).Decode(&ack)
+		require.Error(t, err)
+		require.Nil(t, ack)
+	})
+
+	t.Run("malformed message", func(t *testing.T) {
+		for _, msg := range [][]any{
+			{"app"},
+			{1, 2, 3},
+			{"app", ts.Unix()},
+			{"app", []any{"not an entry"}},
+			{"app", ts.Unix(), "not a record"},
+			{"app", "packed", map[string]any{"compressed": "lz4"}},
+		} {
+			_, _, _, err := decodeForwardMessage(msg)
+			require.Error(t, err, msg)
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	stdlog "log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+const (
+	gelfMaxChunks    = 128
+	gelfChunkTimeout = 5 * time.Second
+	gelfMaxPacket    = 65536
+
+	// gelfMaxMessage is the default limit of the size of a message, decompressed
+	gelfMaxMessage = 1 << 20
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+var errGELFTooBig = errors.New("message too big")
+
+// gelfLevels are syslog severity names indexed by their numeric value
+var gelfLevels = []string{"emergency", "alert", "critical", "error", "warning", "notice", "info", "debug"}
+
+// GELF is a Graylog Extended Log Format input, see https://go2docs.graylog.org/current/getting_in_log_data/gelf.html
+// It accepts chunked and compressed (gzip/zlib) messages over UDP and null-byte framed messages over TCP
+type GELF struct {
+	storage Storage
+
+	udpAddress  string
+	tcpAddress  string
+	bucketField string
+	maxMessage  int
+
+	mu       sync.Mutex
+	udpConn  net.PacketConn
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+
+	chunksMu sync.Mutex
+	chunks   map[string]*gelfChunks
+}
+
+type gelfChunks struct {
+	parts    [][]byte
+	received int
+	since    time.Time
+}
+
+// NewGELF creates a GELF input listening on the given UDP and TCP addresses (either may be left empty)
+// bucketField names the GELF field (e.g. "_container_name") whose value is used as the bucket
+func NewGELF(s Storage, udpAddress, tcpAddress, bucketField string) *GELF {
+	return &GELF{
+		storage:     s,
+		udpAddress:  udpAddress,
+		tcpAddress:  tcpAddress,
+		bucketField: bucketField,
+		maxMessage:  gelfMaxMessage,
+		conns:       map[net.Conn]struct{}{},
+		chunks:      map[string]*gelfChunks{},
+	}
+}
+
+// WithMaxMessageSize limits the size of the messages, decompressed, the bigger ones are dropped
+// and the TCP connections sending them are closed
+func (g *GELF) WithMaxMessageSize(n int) *GELF {
+	g.maxMessage = n
+	return g
+}
+
+func (g *GELF) Start(ctx context.Context) error {
+	errCh := make(chan error, 2)
+
+	if g.udpAddress != "" {
+		conn, err := net.ListenPacket("udp", g.udpAddress)
+		if err != nil {
+			return err
+		}
+
+		g.mu.Lock()
+		g.udpConn = conn
+		g.mu.Unlock()
+
+		go func() {
+			errCh <- g.serveUDP(ctx, conn)
+		}()
+	} else {
+		errCh <- nil
+	}
+
+	if g.tcpAddress != "" {
+		l, err := net.Listen("tcp", g.tcpAddress)
+		if err != nil {
+			_ = g.Stop()
+			return err
+		}
+
+		g.mu.Lock()
+		g.listener = l
+		g.mu.Unlock()
+
+		go func() {
+			errCh <- g.acceptTCP(ctx, l)
+		}()
+	} else {
+		errCh <- nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errCh; err != nil {
+			_ = g.Stop()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *GELF) Stop() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for conn := range g.conns {
+		_ = conn.Close()
+	}
+
+	var err error
+	if g.udpConn != nil {
+		err = g.udpConn.Close()
+		g.udpConn = nil
+	}
+
+	if g.listener != nil {
+		if lErr := g.listener.Close(); err == nil {
+			err = lErr
+		}
+		g.listener = nil
+	}
+
+	return err
+}
+
+func (g *GELF) serveUDP(ctx context.Context, conn net.PacketConn) error {
+	buf := make([]byte, gelfMaxPacket)
+	for ctx.Err() == nil {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		// malformed datagrams are dropped, there's no one to report them to
+		if err := g.handlePacket(append([]byte(nil), buf[:n]...)); errors.Is(err, errGELFTooBig) {
+			stdlog.Printf("gelf: dropping a message from %s: %v", addr, err)
+		}
+	}
+
+	return nil
+}
+
+func (g *GELF) acceptTCP(ctx context.Context, l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go g.serveTCP(ctx, conn)
+	}
+}
+
+func (g *GELF) serveTCP(ctx context.Context, conn net.Conn) {
+	g.mu.Lock()
+	g.conns[conn] = struct{}{}
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.conns, conn)
+		g.mu.Unlock()
+
+		_ = conn.Close()
+	}()
+
+	// the frames are null-byte terminated, but for the last one which may end with the connection
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 0, min(4096, g.maxMessage+1)), g.maxMessage+1)
+	sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	for ctx.Err() == nil && sc.Scan() {
+		if data := sc.Bytes(); len(bytes.TrimSpace(data)) > 0 {
+			_ = g.handleMessage(data)
+		}
+	}
+
+	if errors.Is(sc.Err(), bufio.ErrTooLong) {
+		stdlog.Printf("gelf: closing the connection of %s: %v", conn.RemoteAddr(), errGELFTooBig)
+	}
+}
+
+// handlePacket handles a single UDP datagram which may be a chunk of a bigger message
+func (g *GELF) handlePacket(data []byte) error {
+	if !bytes.HasPrefix(data, gelfChunkMagic) {
+		return g.handleCompressed(data)
+	}
+
+	// magic (2 bytes), message ID (8 bytes), sequence number (1 byte), sequence count (1 byte)
+	if len(data) < 12 {
+		return errors.New("malformed chunk header")
+	}
+
+	id, seq, count := string(data[2:10]), int(data[10]), int(data[11])
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		return fmt.Errorf("malformed chunk %d of %d", seq, count)
+	}
+
+	g.chunksMu.Lock()
+
+	now := time.Now()
+	for k, c := range g.chunks {
+		if now.Sub(c.since) > gelfChunkTimeout {
+			delete(g.chunks, k)
+		}
+	}
+
+	c, ok := g.chunks[id]
+	if !ok {
+		c = &gelfChunks{parts: make([][]byte, count), since: now}
+		g.chunks[id] = c
+	}
+
+	if len(c.parts) != count {
+		delete(g.chunks, id)
+		g.chunksMu.Unlock()
+		return errors.New("inconsistent chunk count")
+	}
+
+	if c.parts[seq] == nil {
+		c.parts[seq] = data[12:]
+		c.received++
+	}
+
+	if c.received < count {
+		g.chunksMu.Unlock()
+		return nil
+	}
+
+	delete(g.chunks, id)
+	g.chunksMu.Unlock()
+
+	return g.handleCompressed(bytes.Join(c.parts, nil))
+}
+
+func (g *GELF) handleCompressed(data []byte) error {
+	var r io.Reader
+	var err error
+
+	switch {
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case len(data) >= 2 && data[0] == 0x78:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		if len(data) > g.maxMessage {
+			return errGELFTooBig
+		}
+		return g.handleMessage(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	// one byte over the limit tells a message which is too big from one which just fits
+	data, err = io.ReadAll(io.LimitReader(r, int64(g.maxMessage)+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > g.maxMessage {
+		return errGELFTooBig
+	}
+
+	return g.handleMessage(data)
+}
+
+func (g *GELF) handleMessage(data []byte) error {
+	b, e, err := decodeGELF(data, g.bucketField)
+	if err != nil {
+		return err
+	}
+
+	if _, err := g.storage.WriteOne(b, e); err != nil {
+		stdlog.Printf("gelf: writing to %s: %v", b, err)
+		return err
+	}
+
+	return nil
+}
+
+func decodeGELF(data []byte, bucketField string) (bucket.Bucket, log.Entry, error) {
+	var msg map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&msg); err != nil {
+		return nil, nil, err
+	}
+
+	shortMessage, ok := msg["short_message"].(string)
+	if !ok {
+		return nil, nil, errors.New("short_message is required")
+	}
+
+	var bucketName string
+	if v, ok := msg[bucketField]; ok && bucketField != "" {
+		bucketName = fmt.Sprint(v)
+	}
+
+	fields := map[string]any{}
+	for k, v := range msg {
+		if strings.HasPrefix(k, "_") && k != "_id" {
+			fields[strings.TrimPrefix(k, "_")] = v
+		}
+	}
+
+	fields[log.MessageKey] = shortMessage
+	if v, ok := msg["full_message"]; ok {
+		fields["full_message"] = v
+	}
+
+	if v, ok := msg["host"]; ok {
+		fields[log.HostKey] = v
+	}
+
+	if v, ok := msg["facility"]; ok {
+		fields["facility"] = v
+	}
+
+	level := int64(1) // GELF default: alert
+	if v, ok := msg["level"]; ok {
+		n, _ := v.(json.Number)
+		l, err := n.Int64()
+		if err != nil {
+			return nil, nil, fmt.Errorf("malformed level: %w", err)
+		}
+		level = l
+	}
+
+	if level >= 0 && level < int64(len(gelfLevels)) {
+		fields[log.LevelKey] = gelfLevels[level]
+	} else {
+		fields[log.LevelKey] = level
+	}
+
+	ts := time.Now()
+	if v, ok := msg["timestamp"]; ok {
+		n, _ := v.(json.Number)
+		f, err := n.Float64()
+		if err != nil {
+			return nil, nil, fmt.Errorf("malformed timestamp: %w", err)
+		}
+
+		sec, frac := math.Modf(f)
+		ts = time.Unix(int64(sec), int64(math.Round(frac*1e6))*int64(time.Microsecond))
+	}
+	fields[log.TimestampKey] = ts.UTC().Format(time.RFC3339Nano)
+
+	return bucket.NewBucket(bucketName), log.FromFields(fields), nil
+}
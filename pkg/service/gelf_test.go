@@ -0,0 +1,177 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+type bucketStorageMock struct {
+	storageMock
+	buckets []bucket.Bucket
+}
+
+func (s *bucketStorageMock) WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error) {
+	s.buckets = append(s.buckets, b)
+	return s.storageMock.WriteOne(b, e)
+}
+
+const gelfSample = `{"version":"1.1","host":"example.org","short_message":"A short message","full_message":"Backtrace here\n\nmore stuff","timestamp":1385053862.3072,"level":3,"_user_id":9001,"_container_name":"web","_id":"ignored"}`
+
+var gelfSampleEntry = log.FromFields(map[string]any{
+	log.MessageKey:   "A short message",
+	"full_message":   "Backtrace here\n\nmore stuff",
+	log.HostKey:      "example.org",
+	log.LevelKey:     "error",
+	log.TimestampKey: "2013-11-21T17:11:02.3072Z",
+	"user_id":        json.Number("9001"),
+	"container_name": "web",
+})
+
+func TestGELF(t *testing.T) {
+	compress := func(t *testing.T, algo string, data []byte) []byte {
+		var buf bytes.Buffer
+		var w interface {
+			Write([]byte) (int, error)
+			Close() error
+		}
+		switch algo {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "zlib":
+			w = zlib.NewWriter(&buf)
+		}
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	chunk := func(id string, seq, count int, data []byte) []byte {
+		return append(append(append([]byte{0x1e, 0x0f}, id...), byte(seq), byte(count)), data...)
+	}
+
+	for name, packets := range map[string]func(t *testing.T) [][]byte{
+		"plain": func(t *testing.T) [][]byte { return [][]byte{[]byte(gelfSample)} },
+		"gzip":  func(t *testing.T) [][]byte { return [][]byte{compress(t, "gzip", []byte(gelfSample))} },
+		"zlib":  func(t *testing.T) [][]byte { return [][]byte{compress(t, "zlib", []byte(gelfSample))} },
+		"chunked": func(t *testing.T) [][]byte {
+			data := compress(t, "gzip", []byte(gelfSample))
+			half := len(data) / 2
+			return [][]byte{
+				chunk("msgid001", 1, 2, data[half:]),
+				chunk("msgid001", 0, 2, data[:half]),
+			}
+		},
+	} {
+		t.Run("udp "+name, func(t *testing.T) {
+			s := &bucketStorageMock{}
+			g := NewGELF(s, "", "", "_container_name")
+
+			for _, p := range packets(t) {
+				require.NoError(t, g.handlePacket(p))
+			}
+
+			require.Len(t, s.entries, 1)
+			require.Equal(t, gelfSampleEntry, s.entries[0])
+			require.Equal(t, []bucket.Bucket{bucket.NewBucket("web")}, s.buckets)
+			require.Empty(t, g.chunks)
+		})
+	}
+
+	t.Run("udp chunk timeout", func(t *testing.T) {
+		s := &bucketStorageMock{}
+		g := NewGELF(s, "", "", "")
+
+		require.NoError(t, g.handlePacket(chunk("msgid002", 0, 2, []byte(`{"short_`))))
+		g.chunks["msgid002"].since = time.Now().Add(-2 * gelfChunkTimeout)
+
+		require.NoError(t, g.handlePacket(chunk("msgid003", 0, 2, []byte(`{"short_`))))
+		require.NotContains(t, g.chunks, "msgid002")
+		require.Contains(t, g.chunks, "msgid003")
+		require.Empty(t, s.entries)
+	})
+
+	t.Run("udp malformed chunk", func(t *testing.T) {
+		g := NewGELF(&bucketStorageMock{}, "", "", "")
+		require.Error(t, g.handlePacket([]byte{0x1e, 0x0f, 1, 2}))
+		require.Error(t, g.handlePacket(chunk("msgid004", 3, 2, nil)))
+		require.Error(t, g.handlePacket(chunk("msgid004", 0, gelfMaxChunks+1, nil)))
+	})
+
+	t.Run("tcp null-byte framed", func(t *testing.T) {
+		s := &bucketStorageMock{}
+		g := NewGELF(s, "", "", "host")
+
+		server, client := net.Pipe()
+		ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelFn()
+
+		done := make(chan struct{})
+		go func() {
+			g.serveTCP(ctx, server)
+			close(done)
+		}()
+
+		_, err := client.Write([]byte(gelfSample + "\x00" + `{"short_message":"second","host":"other"}` + "\x00"))
+		require.NoError(t, err)
+		require.NoError(t, client.Close())
+		<-done
+
+		require.Len(t, s.entries, 2)
+		require.Equal(t, gelfSampleEntry, s.entries[0])
+		require.Equal(t, "second", s.entries[1].(log.Structured).Fields()[log.MessageKey])
+		require.Equal(t, "alert", s.entries[1].(log.Structured).Fields()[log.LevelKey])
+		require.Equal(t, []bucket.Bucket{bucket.NewBucket("example.org"), bucket.NewBucket("other")}, s.buckets)
+	})
+
+	t.Run("message too big", func(t *testing.T) {
+		s := &bucketStorageMock{}
+		g := NewGELF(s, "", "", "").WithMaxMessageSize(len(gelfSample))
+
+		require.NoError(t, g.handlePacket(compress(t, "gzip", []byte(gelfSample))))
+
+		bomb := compress(t, "gzip", bytes.Repeat([]byte(" "), 1<<20))
+		require.ErrorIs(t, g.handlePacket(bomb), errGELFTooBig)
+		require.ErrorIs(t, g.handlePacket([]byte(gelfSample+" ")), errGELFTooBig)
+
+		// the connection is closed on the first frame over the limit
+		server, client := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			g.serveTCP(context.Background(), server)
+			close(done)
+		}()
+
+		_, err := client.Write([]byte(gelfSample + "\x00"))
+		require.NoError(t, err)
+		_, err = client.Write(bytes.Repeat([]byte(" "), len(gelfSample)+1))
+		require.NoError(t, err)
+		<-done
+
+		_, err = client.Write([]byte(gelfSample + "\x00"))
+		require.Error(t, err)
+		require.Len(t, s.entries, 2)
+	})
+
+	t.Run("malformed message", func(t *testing.T) {
+		for _, msg := range []string{
+			`not json`,
+			`{"host":"no short message"}`,
+			`{"short_message":"x","level":"high"}`,
+			`{"short_message":"x","level":1.5}`,
+		} {
+			_, _, err := decodeGELF([]byte(msg), "")
+			require.Error(t, err, msg)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lootek/go-immulogs/pkg/archive"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+const (
+	importFormatArchive = "archive"
+	importFormatNDJSON  = "ndjson"
+)
+
+// importHandler replays an archive, or plain NDJSON entries, into a bucket in chunks, keeping the order and the entries intact
+// the "skip" query parameter skips the leading entries already imported by an earlier, interrupted, import
+func importHandler(storageOf func(*gin.Context) Storage) gin.HandlerFunc {
+	return ginWrapper(func(c *gin.Context) (gin.H, error) {
+		s := storageOf(c)
+		chunkSize, err := streamChunkSize(c.Request)
+		if err != nil {
+			return nil, err
+		}
+
+		skip, err := strconv.ParseUint(c.DefaultQuery("skip", "0"), 10, 64)
+		if err != nil {
+			return nil, &statusError{http.StatusBadRequest, errors.New("skip must be a non-negative number")}
+		}
+
+		var dec *batchDecoder
+		var ar *archive.Reader
+		switch format := c.DefaultQuery("format", importFormatArchive); format {
+		case importFormatArchive:
+			ar, err = archive.NewReader(c.Request.Body)
+			if err != nil {
+				return nil, &statusError{http.StatusBadRequest, fmt.Errorf("malformed archive: %w", err)}
+			}
+			dec = archiveDecoder(ar)
+		case importFormatNDJSON:
+			dec, err = newBatchDecoder(contentTypeNDJSON, c.GetHeader("Content-Encoding"), c.Request.Body)
+			if err != nil {
+				return nil, err
+			}
+			defer dec.Close()
+		default:
+			return nil, &statusError{http.StatusBadRequest, fmt.Errorf("unknown import format %q", format)}
+		}
+
+		disableDeadlines(c.Writer)
+
+		for skipped := uint64(0); skipped < skip; skipped++ {
+			if _, err := dec.Next(); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, &statusError{http.StatusBadRequest, err}
+			}
+		}
+
+		b := bucket.NewBucket(c.Param("bucket"))
+		summary, err := ingestStream(s, b, dec, chunkSize)
+		res := summary.response()
+		res["skipped"] = skip
+
+		if err != nil {
+			var batchErr *batchError
+			if errors.As(err, &batchErr) {
+				for k, v := range batchErr.response() {
+					res[k] = v
+				}
+				return res, err
+			}
+
+			res["error"] = err.Error()
+			return res, &statusError{http.StatusBadRequest, err}
+		}
+
+		if summary.Err != nil {
+			return res, summary.status()
+		}
+
+		if ar != nil {
+			// the entries are already committed, the client still learns the archive wasn't the one described by its manifest
+			if _, err := ar.Manifest(); err != nil {
+				res["complete"] = false
+				res["error"] = err.Error()
+				return res, &statusError{http.StatusUnprocessableEntity, err}
+			}
+			res["complete"] = true
+		}
+
+		return res, nil
+	})
+}
+
+// archiveDecoder turns the records of an archive back into the entries they were exported from
+func archiveDecoder(ar *archive.Reader) *batchDecoder {
+	return &batchDecoder{next: func() (log.Entry, error) {
+		r, err := ar.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		return log.FromBytes([]byte(r.Value)), nil
+	}}
+}
+
+// importerOf is the storage the imports write to, the entries are restored as they were exported, so they're
+// neither processed nor redacted, the signatures, but of the entries the storage keeps as they are, and the quotas
+// are still enforced
+func (r *REST) importerOf(c *gin.Context) Storage {
+	var s Storage = r.storageOf(c)
+	var preserved func(log.Entry) bool
+	if imp, ok := s.(Importer); ok {
+		s, preserved = importedStorage{Storage: s, importer: imp}, imp.Preserves
+	}
+
+	s = timedStorage{Storage: s, observe: r.observeWrite}
+	if r.quotas != nil {
+		s = LimitStorage(s, r.quotas, requestTenant(c))
+	}
+	if r.registry != nil {
+		s = verifiedStorage{Storage: s, check: r.checkSignatures, skip: preserved}
+	}
+
+	return s
+}
+
+// importedStorage writes the entries to the storage as imported ones
+type importedStorage struct {
+	Storage
+	importer Importer
+}
+
+func (s importedStorage) WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error) {
+	return s.importer.Import(b, []log.Entry{e})
+}
+
+func (s importedStorage) WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, error) {
+	return s.importer.Import(b, e)
+}
+
+// StoredSize counts the preserved entries as they are
+func (s importedStorage) StoredSize(b bucket.Bucket, e log.Entry) int64 {
+	if s.importer.Preserves(e) {
+		return int64(len(e.Bytes()))
+	}
+
+	return storedSize(s.Storage, b, e)
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/archive"
+	"github.com/lootek/go-immulogs/pkg/redact"
+	"github.com/lootek/go-immulogs/pkg/signing"
+	"github.com/lootek/go-immulogs/pkg/storage"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+// exported is an archive of the values, without proofs
+func exported(t *testing.T, format string, values ...string) []byte {
+	var buf bytes.Buffer
+	w, err := archive.NewWriter(format, &buf, "old-bucket")
+	require.NoError(t, err)
+
+	for _, v := range values {
+		require.NoError(t, w.Write(archive.Record{Value: v}))
+	}
+
+	_, err = w.Close(nil)
+	require.NoError(t, err)
+
+	return buf.Bytes()
+}
+
+func TestImport(t *testing.T) {
+	post := func(t *testing.T, s Storage, url string, body []byte) (int, map[string]any) {
+		req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		NewREST(s, "localhost:8000", 10*time.Second).srv.Handler.ServeHTTP(w, req)
+
+		var res map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return w.Code, res
+	}
+
+	values := []string{"#1", `{"message":"#2","timestamp":"2023-01-02T03:04:05Z"}`, "#3"}
+	want := []log.Entry{log.FromString("#1"), log.FromString(`{"message":"#2","timestamp":"2023-01-02T03:04:05Z"}`), log.FromString("#3")}
+
+	for _, format := range []string{archive.FormatNDJSON, archive.FormatTarGz} {
+		t.Run(format+" archive", func(t *testing.T) {
+			s := &storageMock{}
+			code, res := post(t, s, "/my-bucket-name/import?chunk=2", exported(t, format, values...))
+			require.Equal(t, http.StatusOK, code)
+			require.Equal(t, map[string]any{"written": 3., "failed": 0., "skipped": 0., "complete": true}, res)
+			require.Equal(t, want, s.entries)
+		})
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		s := &storageMock{}
+		code, res := post(t, s, "/my-bucket-name/import?skip=2", exported(t, archive.FormatNDJSON, values...))
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]any{"written": 1., "failed": 0., "skipped": 2., "complete": true}, res)
+		require.Equal(t, want[2:], s.entries)
+	})
+
+	t.Run("incomplete archive", func(t *testing.T) {
+		data := exported(t, archive.FormatNDJSON, values...)
+		lines := strings.SplitAfter(string(data), "\n")
+
+		s := &storageMock{}
+		code, res := post(t, s, "/my-bucket-name/import", []byte(strings.Join(lines[:2], "")))
+		require.Equal(t, http.StatusUnprocessableEntity, code)
+		require.Equal(t, map[string]any{
+			"written":  2.,
+			"failed":   0.,
+			"skipped":  0.,
+			"complete": false,
+			"error":    archive.ErrIncomplete.Error(),
+		}, res)
+		require.Equal(t, want[:2], s.entries)
+	})
+
+	t.Run("plain ndjson", func(t *testing.T) {
+		s := &storageMock{}
+		code, res := post(t, s, "/my-bucket-name/import?format=ndjson", []byte("\"#1\"\n{\"message\":\"#2\"}\n"))
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]any{"written": 2., "failed": 0., "skipped": 0.}, res)
+		require.Equal(t, []log.Entry{log.FromString("#1"), log.FromFields(map[string]any{"message": "#2"})}, s.entries)
+	})
+
+	t.Run("malformed archive", func(t *testing.T) {
+		s := &storageMock{}
+		code, res := post(t, s, "/my-bucket-name/import", []byte("{\"value\":\"#1\"}\nnot json\n"))
+		require.Equal(t, http.StatusBadRequest, code)
+		require.Equal(t, "line 2: invalid character 'o' in literal null (expecting 'u')", res["error"])
+		require.Equal(t, 1., res["written"])
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		keys, err := storage.NewKeyring("", bytes.Repeat([]byte{1}, 32))
+		require.NoError(t, err)
+		m := storage.NewMemory()
+		enc := storage.NewEncrypted(m, keys, nil)
+
+		_, err = enc.WriteOne(bucket.NewBucket("app"), log.FromString("to jane@example.com"))
+		require.NoError(t, err)
+		sealed, err := m.All(bucket.NewBucket("app"))
+		require.NoError(t, err)
+
+		red, err := redact.New(redact.Config{Buckets: []redact.BucketRules{{Bucket: "*", Rules: []redact.Rule{{Name: redact.DetectorEmail}}}}})
+		require.NoError(t, err)
+		policies, err := signing.ParsePolicies([]string{"app=require"})
+		require.NoError(t, err)
+		r := NewREST(enc, "localhost:8000", 10*time.Second, WithRedaction(red), WithSignatures(signing.Registry{}, policies))
+
+		do := func(method, url string, body []byte) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest(method, url, bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			r.srv.Handler.ServeHTTP(w, req)
+			return w
+		}
+
+		// the encrypted entries are restored as they were, neither redacted nor verified again
+		w := do("POST", "/app/import", exported(t, archive.FormatNDJSON, sealed[0].String()))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, http.StatusForbidden, do("POST", "/app/import", exported(t, archive.FormatNDJSON, "#2")).Code)
+
+		w = do("GET", "/app/last/0", nil)
+		require.JSONEq(t, `{"entries":["to jane@example.com","to jane@example.com"],"signatures":[{"status":"unsigned"},{"status":"unsigned"}]}`, w.Body.String())
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		code, res := post(t, &storageMock{}, "/my-bucket-name/import?format=csv", nil)
+		require.Equal(t, http.StatusBadRequest, code)
+		require.Equal(t, map[string]any{"error": `unknown import format "csv"`}, res)
+	})
+}
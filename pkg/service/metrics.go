@@ -0,0 +1,94 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are exposed in the Prometheus format at GET /metrics
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests     *prometheus.CounterVec
+	rejected     *prometheus.CounterVec
+	inFlight     prometheus.Gauge
+	writeLatency prometheus.Histogram
+	redactions   *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "immulogs_http_requests_total",
+			Help: "HTTP requests by route and response status.",
+		}, []string{"method", "route", "status"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "immulogs_admission_rejected_total",
+			Help: "Requests rejected by the admission control, by reason.",
+		}, []string{"reason"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "immulogs_writes_in_flight",
+			Help: "Write requests being processed.",
+		}),
+		writeLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "immulogs_storage_write_seconds",
+			Help:    "Latency of the writes to the storage.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		redactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "immulogs_redactions_total",
+			Help: "Values redacted from the entries written, by rule.",
+		}, []string{"rule"}),
+	}
+
+	m.registry.MustRegister(m.requests, m.rejected, m.inFlight, m.writeLatency, m.redactions)
+	return m
+}
+
+// countRequests is the middleware counting the requests by route and status
+func (m *metrics) countRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	}
+}
+
+func (m *metrics) handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// timedStorage measures the latency of the writes to the storage
+type timedStorage struct {
+	Storage
+	observe func(time.Duration)
+}
+
+func (t timedStorage) WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error) {
+	defer t.since(time.Now())
+	return t.Storage.WriteOne(b, e)
+}
+
+func (t timedStorage) WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, error) {
+	defer t.since(time.Now())
+	return t.Storage.WriteBatch(b, e)
+}
+
+func (t timedStorage) StoredSize(b bucket.Bucket, e log.Entry) int64 {
+	return storedSize(t.Storage, b, e)
+}
+
+func (t timedStorage) since(start time.Time) {
+	t.observe(time.Since(start))
+}
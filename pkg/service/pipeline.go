@@ -0,0 +1,152 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/pipeline"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// WithPipeline runs the entries through the processors of their bucket before they're written,
+// the metrics of the processors are exposed along with the others, entries are rerouted only
+// to the buckets the request may write to, the audit and the checkpoints ones aside
+func WithPipeline(p *pipeline.Pipeline) RESTOption {
+	return func(r *REST) {
+		r.pipeline = p
+		r.metrics.registry.MustRegister(p.Collectors()...)
+	}
+}
+
+// processedStorage runs the entries through the pipeline before writing them to the storage
+type processedStorage struct {
+	Storage
+	pipeline *pipeline.Pipeline
+	// allow refuses the buckets the entries can't be rerouted to
+	allow func(b string) error
+}
+
+// ProcessStorage runs the entries written to the storage through the pipeline, rerouting none to the reserved buckets
+func ProcessStorage(s Storage, p *pipeline.Pipeline, reserved ...bucket.Bucket) Storage {
+	return processedStorage{Storage: s, pipeline: p, allow: refuseReserved(reserved)}
+}
+
+func (s processedStorage) WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error) {
+	return s.WriteBatch(b, []log.Entry{e})
+}
+
+// WriteBatch writes the processed entries with a write per bucket, in the order they first appear, once every bucket
+// rerouted to is allowed, the response is the one of the bucket written to unless the entries were dropped or rerouted,
+// in which case it has the result of every bucket, a write failing in one of them doesn't prevent the others'
+func (s processedStorage) WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, error) {
+	var order []string
+	batches := map[string][]log.Entry{}
+	for _, entry := range e {
+		records, err := s.pipeline.Process(b.String(), entry)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range records {
+			if _, ok := batches[r.Bucket]; !ok {
+				if r.Bucket != b.String() {
+					if err := s.allow(r.Bucket); err != nil {
+						return nil, err
+					}
+				}
+				order = append(order, r.Bucket)
+			}
+			batches[r.Bucket] = append(batches[r.Bucket], r.Entry)
+		}
+	}
+
+	switch {
+	case len(order) == 0:
+		return map[string]any{"written": 0, "dropped": len(e)}, nil
+	case len(order) == 1 && order[0] == b.String():
+		return s.write(b, batches[order[0]])
+	}
+
+	results := make(map[string]any, len(order))
+	var written int
+	var failed error
+	for _, name := range order {
+		res, err := s.write(bucket.NewBucket(name), batches[name])
+		if err != nil {
+			if res == nil {
+				res = map[string]any{}
+			}
+			res["error"] = err.Error()
+			results[name] = res
+
+			if failed == nil {
+				failed = bucketError(name, err)
+			}
+			continue
+		}
+
+		results[name] = res
+		written += len(batches[name])
+	}
+
+	res := map[string]any{"written": written, "buckets": results}
+	if failed != nil {
+		res["error"] = failed.Error()
+	}
+
+	return res, failed
+}
+
+func (s processedStorage) write(b bucket.Bucket, e []log.Entry) (map[string]any, error) {
+	if len(e) == 1 {
+		return s.Storage.WriteOne(b, e[0])
+	}
+
+	return s.Storage.WriteBatch(b, e)
+}
+
+// bucketError is the error of the write to a bucket, with the status picked by the storage if any
+func bucketError(name string, err error) error {
+	status := http.StatusInternalServerError
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		status = statusErr.status
+	}
+
+	return &statusError{status, fmt.Errorf("bucket %s: %w", name, err)}
+}
+
+// refuseReserved refuses to reroute the entries to any of the buckets
+func refuseReserved(reserved []bucket.Bucket) func(b string) error {
+	return func(b string) error {
+		for _, r := range reserved {
+			if r != nil && r.String() != "" && r.String() == b {
+				return &statusError{http.StatusForbidden, fmt.Errorf("not allowed to reroute to the reserved bucket %s", b)}
+			}
+		}
+
+		return nil
+	}
+}
+
+// allowReroute refuses to reroute the entries of the request to the audit and the checkpoints buckets,
+// and to the buckets the request may not write to
+func (r *REST) allowReroute(c *gin.Context) func(b string) error {
+	reserved := refuseReserved([]bucket.Bucket{r.auditBucket, r.checkpointsBucket})
+	p, authenticated := requestPrincipal(c)
+
+	return func(b string) error {
+		if err := reserved(b); err != nil {
+			return err
+		}
+		if authenticated && !p.Allows(auth.PermissionWrite, b) {
+			return &statusError{http.StatusForbidden, errors.New("not allowed to reroute to " + bucketLabel(b))}
+		}
+
+		return nil
+	}
+}
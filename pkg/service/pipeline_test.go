@@ -0,0 +1,163 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/pipeline"
+	"github.com/lootek/go-immulogs/pkg/storage"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline(t *testing.T) {
+	p, err := pipeline.New(pipeline.Config{Buckets: []pipeline.BucketProcessors{
+		{Bucket: "app", Processors: []pipeline.ProcessorConfig{
+			{Type: pipeline.TypeSplit},
+			{Type: pipeline.TypeDrop, Match: `^DEBUG`},
+			{Type: pipeline.TypeReroute, Match: `^ERROR`, Bucket: "errors"},
+		}},
+	}})
+	require.NoError(t, err)
+
+	s := storage.NewMemory()
+	r := NewREST(s, "localhost:8000", 10*time.Second, WithPipeline(p))
+
+	post := func(t *testing.T, url, contentType, body string) string {
+		req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	entries := func(t *testing.T, b string) []log.Entry {
+		entries, err := s.All(bucket.NewBucket(b))
+		require.NoError(t, err)
+		return entries
+	}
+
+	t.Run("processed", func(t *testing.T) {
+		require.JSONEq(t, `{"written":2}`, post(t, "/app/add", "text/plain", "INFO a\nDEBUG b\nINFO c"))
+		require.Equal(t, []log.Entry{log.FromString("INFO a"), log.FromString("INFO c")}, entries(t, "app"))
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		require.JSONEq(t, `{"written":0,"dropped":1}`, post(t, "/app/add", "text/plain", "DEBUG d"))
+	})
+
+	t.Run("rerouted", func(t *testing.T) {
+		require.JSONEq(t, `{"written":2,"buckets":{"app":{"written":1},"errors":{"written":1}}}`,
+			post(t, "/app/batch", "application/x-ndjson", "\"ERROR e\"\n\"INFO f\""))
+		require.Equal(t, []log.Entry{log.FromString("ERROR e")}, entries(t, "errors"))
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/metrics", nil)
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		require.Contains(t, w.Body.String(), `immulogs_processor_entries_total{chain="app",processor="drop-2",result="dropped"} 2`)
+		require.Contains(t, w.Body.String(), `immulogs_processor_entries_total{chain="app",processor="reroute-3",result="rerouted"} 1`)
+	})
+}
+
+// bucketFailingStorage fails the writes to one of the buckets
+type bucketFailingStorage struct {
+	Storage
+	failing string
+}
+
+func (s bucketFailingStorage) WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error) {
+	return s.WriteBatch(b, []log.Entry{e})
+}
+
+func (s bucketFailingStorage) WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, error) {
+	if b.String() == s.failing {
+		return nil, errors.New("storage unavailable")
+	}
+
+	return s.Storage.WriteBatch(b, e)
+}
+
+func TestPipelineReroute(t *testing.T) {
+	p, err := pipeline.New(pipeline.Config{Buckets: []pipeline.BucketProcessors{
+		{Bucket: "app", Processors: []pipeline.ProcessorConfig{
+			{Type: pipeline.TypeReroute, Match: `^ERROR`, Bucket: "errors"},
+			{Type: pipeline.TypeReroute, Match: `^AUDIT`, Bucket: "audit"},
+		}},
+	}})
+	require.NoError(t, err)
+
+	post := func(t *testing.T, r *REST, key, body string) (int, map[string]any) {
+		req, _ := http.NewRequest("POST", "/app/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+
+		var res map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return w.Code, res
+	}
+
+	t.Run("permissions", func(t *testing.T) {
+		store, err := auth.NewStore("")
+		require.NoError(t, err)
+		store.Bootstrap("imk_admin")
+		_, secret, err := store.Create("app", "", []string{"app"}, []auth.Permission{auth.PermissionWrite})
+		require.NoError(t, err)
+
+		s := storage.NewMemory()
+		r := NewREST(s, "localhost:8000", 10*time.Second, WithAuth(store, bucket.NewBucket("audit")), WithPipeline(p))
+
+		// nothing is written unless every bucket rerouted to is allowed
+		code, res := post(t, r, secret, "\"INFO a\"\n\"ERROR b\"")
+		require.Equal(t, http.StatusForbidden, code)
+		require.Equal(t, "not allowed to reroute to bucket errors", res["error"])
+		cnt, err := s.Count(bucket.NewBucket("app"))
+		require.NoError(t, err)
+		require.Zero(t, cnt)
+
+		code, res = post(t, r, "imk_admin", "\"INFO a\"\n\"AUDIT b\"")
+		require.Equal(t, http.StatusForbidden, code)
+		require.Equal(t, "not allowed to reroute to the reserved bucket audit", res["error"])
+
+		code, _ = post(t, r, "imk_admin", "\"INFO a\"\n\"ERROR b\"")
+		require.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("reserved", func(t *testing.T) {
+		s := ProcessStorage(storage.NewMemory(), p, bucket.NewBucket("audit"))
+		_, err := s.WriteOne(bucket.NewBucket("app"), log.FromString("AUDIT a"))
+		require.EqualError(t, err, "not allowed to reroute to the reserved bucket audit")
+	})
+
+	t.Run("partially written", func(t *testing.T) {
+		s := storage.NewMemory()
+		r := NewREST(bucketFailingStorage{Storage: s, failing: "errors"}, "localhost:8000", 10*time.Second, WithPipeline(p))
+
+		code, res := post(t, r, "", "\"ERROR a\"\n\"INFO b\"")
+		require.Equal(t, http.StatusInternalServerError, code)
+		require.Equal(t, map[string]any{
+			"written": 1.,
+			"buckets": map[string]any{
+				"errors": map[string]any{"error": "storage unavailable"},
+				"app":    map[string]any{"written": 1.},
+			},
+			"error": "bucket errors: storage unavailable",
+		}, res)
+		entries, err := s.All(bucket.NewBucket("app"))
+		require.NoError(t, err)
+		require.Equal(t, []log.Entry{log.FromString("INFO b")}, entries)
+	})
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lootek/go-immulogs/pkg/quota"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// WithQuotas enforces the quotas on every write, rejected ones get a 429 response with a Retry-After header,
+// or a 413 one for entries bigger than allowed, the consumption is reported at GET /usage
+func WithQuotas(q *quota.Quotas) RESTOption {
+	return func(r *REST) {
+		r.quotas = q
+	}
+}
+
+// limitedStorage enforces the quotas of the tenant before writing to the storage
+type limitedStorage struct {
+	Storage
+	quotas *quota.Quotas
+	tenant string
+}
+
+// LimitStorage enforces the quotas of the tenant on the writes to the storage, counting the bytes as stored
+func LimitStorage(s Storage, q *quota.Quotas, tenant string) Storage {
+	return limitedStorage{Storage: s, quotas: q, tenant: tenant}
+}
+
+func (l limitedStorage) WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error) {
+	sizes := []int64{storedSize(l.Storage, b, e)}
+	if err := l.quotas.Reserve(l.tenant, b.String(), sizes); err != nil {
+		return nil, err
+	}
+
+	res, err := l.Storage.WriteOne(b, e)
+	if err != nil {
+		l.quotas.Refund(l.tenant, b.String(), sizes)
+	}
+
+	return res, err
+}
+
+func (l limitedStorage) WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, error) {
+	sizes := make([]int64, len(e))
+	for i := range e {
+		sizes[i] = storedSize(l.Storage, b, e[i])
+	}
+
+	if err := l.quotas.Reserve(l.tenant, b.String(), sizes); err != nil {
+		return nil, err
+	}
+
+	res, err := l.Storage.WriteBatch(b, e)
+	if err != nil {
+		l.quotas.Refund(l.tenant, b.String(), sizes)
+	}
+
+	return res, err
+}
+
+// StorageTotals counts the bytes already stored, in the storage of the tenant, for the total bytes quotas
+func StorageTotals(s Storage, tenants Tenants) quota.Totals {
+	return func(tenant, b string) (int64, error) {
+		if tenant != "" {
+			var ok bool
+			if s, ok = tenants(tenant); !ok {
+				return 0, nil
+			}
+		}
+
+		sz, ok := s.(Sizer)
+		if !ok {
+			return 0, errors.New("total bytes quotas are not supported by the storage")
+		}
+
+		return sz.Size(bucket.NewBucket(b))
+	}
+}
+
+// writerOf is the storage the request writes to, processing the entries, redacting them, verifying
+// the signatures of the result and then enforcing the quotas, if enabled, the inputs other than
+// the REST one get the same wrapping from the daemon, signatures aside, around the storage they're given
+func (r *REST) writerOf(c *gin.Context) Storage {
+	var s Storage = timedStorage{Storage: r.storageOf(c), observe: r.observeWrite}
+	if r.quotas != nil {
+		s = LimitStorage(s, r.quotas, requestTenant(c))
+	}
+	if r.registry != nil {
+		s = verifiedStorage{Storage: s, check: r.checkSignatures}
+	}
+	if r.redactor != nil {
+		s = redactedStorage{Storage: s, redactor: r.redactor, fired: r.countRedactions}
+	}
+	if r.pipeline != nil {
+		s = processedStorage{Storage: s, pipeline: r.pipeline, allow: r.allowReroute(c)}
+	}
+
+	return s
+}
+
+// quotaStatus is the response status of an error rejecting a write because of the quotas, if it is one
+func quotaStatus(c *gin.Context, err error) (int, bool) {
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		if exceeded.RetryAfter > 0 {
+			seconds := (exceeded.RetryAfter + time.Second - 1) / time.Second
+			c.Header("Retry-After", strconv.FormatInt(int64(seconds), 10))
+		}
+		return http.StatusTooManyRequests, true
+	}
+
+	var tooLarge *quota.TooLargeError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, true
+	}
+
+	return 0, false
+}
+
+func (r *REST) usageHandler() gin.HandlerFunc {
+	return ginWrapper(func(c *gin.Context) (gin.H, error) {
+		if r.quotas == nil {
+			return nil, &statusError{http.StatusNotImplemented, errors.New("quotas are not enabled")}
+		}
+
+		usage, err := r.quotas.Usage(requestTenant(c), c.Query("bucket"))
+		if err != nil {
+			return nil, err
+		}
+
+		return gin.H{"usage": usage}, nil
+	})
+}
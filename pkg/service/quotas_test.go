@@ -0,0 +1,151 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/archive"
+	"github.com/lootek/go-immulogs/pkg/quota"
+	"github.com/lootek/go-immulogs/pkg/storage"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotas(t *testing.T) {
+	s := storage.NewMemory().WithTenants(map[string]string{"acme": ""})
+	tenants := func(name string) (Storage, bool) {
+		return s.Tenant(name)
+	}
+
+	_, err := s.WriteOne(bucket.NewBucket("jobs"), log.FromString("0123456789"))
+	require.NoError(t, err)
+
+	q := quota.New(quota.Config{
+		Buckets: []quota.BucketLimits{
+			{Bucket: "app", Limits: quota.Limits{EntriesPerSecond: 2, MaxEntrySize: 20}},
+			{Bucket: "jobs", Limits: quota.Limits{MaxTotalBytes: 15}},
+		},
+	}, StorageTotals(s, tenants))
+	r := NewREST(s, "localhost:8000", 10*time.Second, WithTenants(tenants, ""), WithQuotas(q))
+
+	post := func(t *testing.T, url, tenant, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set(TenantHeader, tenant)
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("rate", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, post(t, "/app/add", "", "#1").Code)
+		require.Equal(t, http.StatusOK, post(t, "/app/add", "", "#2").Code)
+
+		w := post(t, "/app/add", "", "#3")
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		require.Equal(t, "1", w.Header().Get("Retry-After"))
+		require.Contains(t, w.Body.String(), "entries per second quota of bucket app of the default tenant exceeded")
+
+		// the tenants have their own quotas
+		require.Equal(t, http.StatusOK, post(t, "/app/add", "acme", "#1").Code)
+
+		cnt, err := s.Count(bucket.NewBucket("app"))
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), cnt)
+	})
+
+	t.Run("entry size", func(t *testing.T) {
+		w := post(t, "/app/add", "acme", strings.Repeat("x", 21))
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		require.Empty(t, w.Header().Get("Retry-After"))
+	})
+
+	t.Run("total bytes", func(t *testing.T) {
+		// counted from what's already stored
+		require.Equal(t, http.StatusOK, post(t, "/jobs/add", "", "01234").Code)
+
+		w := post(t, "/jobs/add", "", "0")
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		require.Empty(t, w.Header().Get("Retry-After"))
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/usage?bucket=jobs", nil)
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Usage []quota.Usage `json:"usage"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.Len(t, res.Usage, 1)
+		require.Equal(t, int64(15), *res.Usage[0].TotalBytes)
+		require.Equal(t, int64(5), res.Usage[0].BytesToday)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/usage", nil)
+		w := httptest.NewRecorder()
+		NewREST(s, "localhost:8000", 10*time.Second).srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+}
+
+func TestQuotasEncrypted(t *testing.T) {
+	keys, err := storage.NewKeyring("", bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	m := storage.NewMemory()
+	enc := storage.NewEncrypted(m, keys, nil)
+
+	q := quota.New(quota.Config{
+		Buckets: []quota.BucketLimits{{Bucket: "*", Limits: quota.Limits{MaxTotalBytes: 1 << 20}}},
+	}, StorageTotals(enc, nil))
+	r := NewREST(enc, "localhost:8000", 10*time.Second, WithQuotas(q))
+
+	post := func(t *testing.T, url string, body []byte) int {
+		req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// one stored beforehand, counted as stored, then the written and imported ones, reserved as they'll be stored,
+	// the ones imported encrypted as they are
+	_, err = enc.WriteOne(bucket.NewBucket("app"), log.FromString("#0"))
+	require.NoError(t, err)
+	sealed, err := m.All(bucket.NewBucket("app"))
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusOK, post(t, "/app/add", []byte("a secret entry")))
+	require.Equal(t, http.StatusOK, post(t, "/app/import", exported(t, archive.FormatNDJSON, "#2", sealed[0].String())))
+
+	stored, err := enc.Size(bucket.NewBucket("app"))
+	require.NoError(t, err)
+
+	usage, err := q.Usage("", "app")
+	require.NoError(t, err)
+	require.Equal(t, stored, *usage[0].TotalBytes)
+}
+
+func TestLimitStorageRefund(t *testing.T) {
+	q := quota.New(quota.Config{
+		Buckets: []quota.BucketLimits{{Bucket: "*", Limits: quota.Limits{BytesPerDay: 10}}},
+	}, nil)
+
+	s := LimitStorage(&failingStorageMock{}, q, "")
+	for n := 0; n < 3; n++ {
+		_, err := s.WriteBatch(bucket.NewBucket("app"), []log.Entry{log.FromString("01234"), log.FromString("56789")})
+		require.EqualError(t, err, "storage unavailable")
+	}
+
+	usage, err := q.Usage("", "app")
+	require.NoError(t, err)
+	require.Zero(t, usage[0].BytesToday)
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/lootek/go-immulogs/pkg/redact"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// WithRedaction redacts the entries before they're written, since they can't be removed afterwards,
+// the write responses count how many times every rule fired under "redacted"
+func WithRedaction(red *redact.Redactor) RESTOption {
+	return func(r *REST) {
+		r.redactor = red
+	}
+}
+
+// redactedStorage redacts the entries before writing them to the storage
+type redactedStorage struct {
+	Storage
+	redactor *redact.Redactor
+	fired    func(rule string, n int)
+}
+
+// RedactStorage redacts the entries written to the storage
+func RedactStorage(s Storage, red *redact.Redactor) Storage {
+	return redactedStorage{Storage: s, redactor: red}
+}
+
+func (s redactedStorage) WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error) {
+	e, fired := s.redactor.Redact(b.String(), e)
+
+	res, err := s.Storage.WriteOne(b, e)
+	return s.record(res, fired), err
+}
+
+func (s redactedStorage) WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, error) {
+	redacted := make([]log.Entry, len(e))
+	total := map[string]int{}
+	for i := range e {
+		var fired map[string]int
+		redacted[i], fired = s.redactor.Redact(b.String(), e[i])
+		for rule, n := range fired {
+			total[rule] += n
+		}
+	}
+
+	res, err := s.Storage.WriteBatch(b, redacted)
+	return s.record(res, total), err
+}
+
+// record adds the rules which fired to the response of a write
+func (s redactedStorage) record(res map[string]any, fired map[string]int) map[string]any {
+	if len(fired) == 0 {
+		return res
+	}
+
+	if s.fired != nil {
+		for rule, n := range fired {
+			s.fired(rule, n)
+		}
+	}
+
+	return withFields(res, gin.H{"redacted": fired})
+}
+
+func (r *REST) countRedactions(rule string, n int) {
+	r.metrics.redactions.WithLabelValues(rule).Add(float64(n))
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/redact"
+	"github.com/lootek/go-immulogs/pkg/storage"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedaction(t *testing.T) {
+	red, err := redact.New(redact.Config{Buckets: []redact.BucketRules{
+		{Bucket: "app", Rules: []redact.Rule{{Name: redact.DetectorEmail}, {Name: redact.DetectorIP}}},
+	}})
+	require.NoError(t, err)
+
+	s := storage.NewMemory()
+	r := NewREST(s, "localhost:8000", 10*time.Second, WithRedaction(red))
+
+	post := func(t *testing.T, url, contentType, body string) map[string]any {
+		req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res
+	}
+
+	t.Run("add", func(t *testing.T) {
+		res := post(t, "/app/add", "text/plain", "login of jane@example.com from 10.0.0.1")
+		require.Equal(t, map[string]any{"email": 1., "ip": 1.}, res["redacted"])
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		res := post(t, "/app/batch", "application/x-ndjson", `{"user":"joe@example.com"}`+"\n"+`{"user":"ann@example.com"}`)
+		require.Equal(t, map[string]any{"email": 2.}, res["redacted"])
+	})
+
+	t.Run("other bucket", func(t *testing.T) {
+		res := post(t, "/other/add", "text/plain", "jane@example.com")
+		require.NotContains(t, res, "redacted")
+	})
+
+	entries, err := s.All(bucket.NewBucket("app"))
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, "login of [REDACTED:email] from [REDACTED:ip]", entries[0].String())
+	require.Equal(t, `{"user":"[REDACTED:email]"}`, entries[1].String())
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	r.srv.Handler.ServeHTTP(w, req)
+	require.Contains(t, w.Body.String(), `immulogs_redactions_total{rule="email"} 3`)
+	require.Contains(t, w.Body.String(), `immulogs_redactions_total{rule="ip"} 1`)
+}
@@ -2,36 +2,116 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/pipeline"
+	"github.com/lootek/go-immulogs/pkg/quota"
+	"github.com/lootek/go-immulogs/pkg/redact"
+	"github.com/lootek/go-immulogs/pkg/signing"
 	"github.com/lootek/go-immulogs/pkg/storage/bucket"
 	"github.com/lootek/go-immulogs/pkg/storage/log"
 )
 
+const defaultSearchLimit = 100
+
 type REST struct {
 	srv     *http.Server
 	storage Storage
 
 	address string
 	timeout time.Duration
+
+	checkpointsBucket bucket.Bucket
+	checkpointsKey    ed25519.PublicKey
+
+	registry signing.Registry
+	policies signing.Policies
+
+	keys        *auth.Store
+	tokens      *auth.TokenVerifier
+	certRules   []auth.Rule
+	clientCerts bool
+	auditBucket bucket.Bucket
+	rejections  *rejectionsAudit
+
+	tenants      Tenants
+	tenantDomain string
+
+	quotas   *quota.Quotas
+	redactor *redact.Redactor
+	pipeline *pipeline.Pipeline
+
+	admission *admission
+	metrics   *metrics
+
+	tls *tls.Config
+}
+
+// RESTOption enables an optional feature of the REST API
+type RESTOption func(*REST)
+
+// WithCheckpoints exposes the checkpoints stored in the bucket at GET /checkpoints, along with the key they're signed with
+func WithCheckpoints(b bucket.Bucket, key ed25519.PublicKey) RESTOption {
+	return func(r *REST) {
+		r.checkpointsBucket = b
+		r.checkpointsKey = key
+	}
+}
+
+// WithTLS serves HTTPS, clients certificates being verified if the config requires it
+func WithTLS(cfg *tls.Config) RESTOption {
+	return func(r *REST) {
+		r.tls = cfg
+	}
 }
 
-func NewREST(s Storage, address string, timeout time.Duration) *REST {
+func NewREST(s Storage, address string, timeout time.Duration, opts ...RESTOption) *REST {
+	r := &REST{
+		storage:    s,
+		address:    address,
+		timeout:    timeout,
+		metrics:    newMetrics(),
+		rejections: newRejectionsAudit(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
 	globalRouter := gin.New()
+	// escaped slashes in bucket names, e.g. jobs%2Fnightly, must not split the path
+	globalRouter.UseRawPath = true
 	globalRouter.Use(
 		gin.Logger(),
 		gin.Recovery(),
-		// gin.BasicAuth(),
+		r.metrics.countRequests(),
 	)
+	authenticated := r.keys != nil || r.tokens != nil || r.clientCerts
+	if authenticated && r.admission != nil {
+		globalRouter.Use(r.admitIP())
+	}
+	if authenticated {
+		globalRouter.Use(r.authenticate())
+	}
+	if r.admission != nil {
+		globalRouter.Use(r.admit())
+	}
+	if r.tenants != nil {
+		globalRouter.Use(r.resolveTenant())
+	}
 
 	// TODO: Is there a better way to make bucket an optional parameter?
 	for _, router := range []gin.IRoutes{globalRouter, globalRouter.Group("/:bucket")} {
 		router.POST("/add", ginWrapper(func(c *gin.Context) (gin.H, error) {
+			s := r.writerOf(c)
 			var entry log.Entry
 			if err := c.Bind(&entry); err != nil {
 				return nil, err
@@ -50,38 +130,58 @@ func NewREST(s Storage, address string, timeout time.Duration) *REST {
 			return addLog(s, b, entry)
 		}))
 		router.POST("/batch", ginWrapper(func(c *gin.Context) (gin.H, error) {
-			var entries []log.Entry
+			s := r.writerOf(c)
+			entries, err := readBatch(c.Request)
+			if err != nil {
+				var batchErr *batchError
+				if errors.As(err, &batchErr) {
+					return batchErr.response(), err
+				}
+				return nil, err
+			}
+
+			b := bucket.NewBucket(c.Param("bucket"))
+			return addLogsBatch(s, b, entries)
+		}))
+		router.POST("/stream", ginWrapper(func(c *gin.Context) (gin.H, error) {
+			s := r.writerOf(c)
+			chunkSize, err := streamChunkSize(c.Request)
+			if err != nil {
+				return nil, err
+			}
 
-			// try parsing input by gin framework
-			if err := c.Bind(&entries); err != nil {
+			dec, err := newBatchDecoder(c.GetHeader("Content-Type"), c.GetHeader("Content-Encoding"), c.Request.Body)
+			if err != nil {
 				return nil, err
 			}
+			defer dec.Close()
 
-			// fallback to manual parsing
-			if entries == nil {
-				data, err := c.GetRawData()
-				if err != nil {
-					return nil, err
-				}
+			disableDeadlines(c.Writer)
 
-				// try JSON-encoded list
-				var logs []string
-				err = json.Unmarshal(data, &logs)
-				if err != nil {
+			b := bucket.NewBucket(c.Param("bucket"))
+			summary, err := ingestStream(s, b, dec, chunkSize)
+			if err != nil {
+				res := summary.response()
 
-					// fallback to splitting the given string by the newline character
-					logs = strings.Split(string(data), "\n")
+				var batchErr *batchError
+				if errors.As(err, &batchErr) {
+					for k, v := range batchErr.response() {
+						res[k] = v
+					}
+					return res, err
 				}
 
-				for _, s := range logs {
-					entries = append(entries, log.FromString(s))
-				}
+				return res, &statusError{http.StatusInternalServerError, err}
 			}
 
-			b := bucket.NewBucket(c.Param("bucket"))
-			return addLogsBatch(s, b, entries)
+			if summary.Err != nil {
+				return summary.response(), summary.status()
+			}
+
+			return summary.response(), nil
 		}))
 		router.GET("/last/:n", ginWrapper(func(c *gin.Context) (gin.H, error) {
+			s := r.storageOf(c)
 			n, err := strconv.ParseInt(c.Param("n"), 10, 64)
 			if err != nil {
 				return nil, err
@@ -93,9 +193,10 @@ func NewREST(s Storage, address string, timeout time.Duration) *REST {
 				return nil, err
 			}
 
-			return map[string]any{"entries": entries}, err
+			return withSignatures(gin.H{"entries": entries}, r.signatures(entries)), err
 		}))
 		router.GET("/count", ginWrapper(func(c *gin.Context) (gin.H, error) {
+			s := r.storageOf(c)
 			b := bucket.NewBucket(c.Param("bucket"))
 			cnt, err := count(s, b)
 			if err != nil {
@@ -104,25 +205,116 @@ func NewREST(s Storage, address string, timeout time.Duration) *REST {
 
 			return map[string]any{"count": cnt}, err
 		}))
+		router.GET("/search", ginWrapper(func(c *gin.Context) (gin.H, error) {
+			s := r.storageOf(c)
+			limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSearchLimit)))
+			if err != nil || limit < 1 {
+				return nil, &statusError{http.StatusBadRequest, errors.New("limit must be a positive number")}
+			}
+
+			b := bucket.NewBucket(c.Param("bucket"))
+			entries, err := search(s, b, c.Query("q"), limit)
+			if err != nil {
+				return nil, err
+			}
+
+			return withSignatures(gin.H{"entries": entries}, r.signatures(entries)), err
+		}))
+		router.GET("/verify", ginWrapper(func(c *gin.Context) (gin.H, error) {
+			s := r.storageOf(c)
+			v, ok := s.(Verifier)
+			if !ok {
+				return nil, &statusError{http.StatusNotImplemented, errors.New("verification is not supported by this storage")}
+			}
+
+			b := bucket.NewBucket(c.Param("bucket"))
+			return v.Verify(b)
+		}))
 	}
 
-	r := &REST{
-		srv: &http.Server{
-			Addr:         address,
-			Handler:      globalRouter,
-			ReadTimeout:  timeout,
-			WriteTimeout: timeout,
-		},
+	globalRouter.GET("/:bucket/export", exportHandler(r.storageOf))
+	globalRouter.POST("/:bucket/import", importHandler(r.importerOf))
+	globalRouter.POST("/:bucket/shred", shredHandler(r.storageOf))
+
+	r.registerKeyRoutes(globalRouter)
+	globalRouter.GET("/checkpoints", checkpointsHandler(r.storageOf, r.checkpointsBucket, r.checkpointsKey))
+
+	globalRouter.GET("/usage", r.usageHandler())
+	globalRouter.GET("/metrics", r.metrics.handler())
+
+	globalRouter.GET("/buckets", ginWrapper(func(c *gin.Context) (gin.H, error) {
+		s := r.storageOf(c)
+		l, ok := s.(BucketLister)
+		if !ok {
+			return nil, &statusError{http.StatusNotImplemented, errors.New("listing buckets is not supported by this storage")}
+		}
+
+		buckets, err := l.Buckets()
+		if err != nil {
+			return nil, err
+		}
+
+		p, authenticated := requestPrincipal(c)
+		names := make([]string, 0, len(buckets))
+		for _, b := range buckets {
+			if authenticated && !p.Allows(auth.PermissionRead, b.String()) {
+				continue
+			}
+			names = append(names, b.String())
+		}
+
+		return map[string]any{"buckets": names}, nil
+	}))
+
+	r.srv = &http.Server{
+		Addr:         address,
+		Handler:      globalRouter,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+		TLSConfig:    r.tls,
 	}
 
 	return r
 }
 
+// statusError lets a handler pick the HTTP status of its error response
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
 func ginWrapper(fn func(c *gin.Context) (gin.H, error)) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		res, err := fn(c)
 
 		if err != nil {
+			if status, ok := quotaStatus(c, err); ok {
+				if res == nil {
+					res = gin.H{"error": err.Error()}
+				}
+
+				c.JSON(status, res)
+				return
+			}
+
+			var statusErr *statusError
+			if errors.As(err, &statusErr) {
+				if res == nil {
+					res = gin.H{"error": err.Error()}
+				}
+
+				c.JSON(statusErr.status, res)
+				return
+			}
+
 			if res != nil {
 				c.JSON(http.StatusBadRequest, res)
 				return
@@ -136,8 +328,25 @@ func ginWrapper(fn func(c *gin.Context) (gin.H, error)) func(c *gin.Context) {
 	}
 }
 
+// Handler exposes the routes, e.g. to serve them with httptest
+func (r REST) Handler() http.Handler {
+	return r.srv.Handler
+}
+
 func (r REST) Start(context.Context) error {
-	return r.srv.ListenAndServe()
+	var err error
+	if r.srv.TLSConfig != nil {
+		// the certificates come from the config
+		err = r.srv.ListenAndServeTLS("", "")
+	} else {
+		err = r.srv.ListenAndServe()
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
 }
 
 func (r REST) Stop() error {
@@ -148,6 +357,28 @@ func addLog(s Storage, b bucket.Bucket, e log.Entry) (map[string]any, error) {
 	return s.WriteOne(b, e)
 }
 
+// readBatch decodes all the entries of a batch request according to its Content-Type and Content-Encoding
+func readBatch(req *http.Request) ([]log.Entry, error) {
+	dec, err := newBatchDecoder(req.Header.Get("Content-Type"), req.Header.Get("Content-Encoding"), req.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	var entries []log.Entry
+	for {
+		e, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+}
+
 func addLogsBatch(s Storage, b bucket.Bucket, e []log.Entry) (map[string]any, error) {
 	return s.WriteBatch(b, e)
 }
@@ -163,3 +394,26 @@ func lastN(s Storage, b bucket.Bucket, n int64) ([]log.Entry, error) {
 func count(s Storage, b bucket.Bucket) (uint64, error) {
 	return s.Count(b)
 }
+
+// search returns up to limit most recent entries containing q, case-insensitively
+func search(s Storage, b bucket.Bucket, q string, limit int) ([]log.Entry, error) {
+	entries, err := s.All(b)
+	if err != nil {
+		return nil, err
+	}
+
+	q = strings.ToLower(q)
+	found := []log.Entry{}
+	for i := len(entries) - 1; i >= 0 && len(found) < limit; i-- {
+		if strings.Contains(strings.ToLower(entries[i].String()), q) {
+			found = append(found, entries[i])
+		}
+	}
+
+	// back to the storage order
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+
+	return found, nil
+}
@@ -62,8 +62,6 @@ func TestREST(t *testing.T) {
 			err := r.Start(ctx)
 			require.NoError(t, err)
 		}()
-		defer r.Stop()
-		defer cancelFn()
 
 		t.Run(testCase, func(t *testing.T) {
 			t.Run("count empty", func(t *testing.T) {
@@ -156,5 +154,82 @@ func TestREST(t *testing.T) {
 				require.Equal(t, expectedJSON, gotResponse)
 			})
 		})
+
+		cancelFn()
+		require.NoError(t, r.Stop())
+	}
+}
+
+type capableStorageMock struct {
+	storageMock
+}
+
+func (s *capableStorageMock) Buckets() ([]bucket.Bucket, error) {
+	return []bucket.Bucket{bucket.NewBucket("a"), bucket.NewBucket("b")}, nil
+}
+
+func (s *capableStorageMock) Verify(b bucket.Bucket) (map[string]any, error) {
+	return map[string]any{"verified": len(s.entries), "failed": []string{}}, nil
+}
+
+func TestRESTQueries(t *testing.T) {
+	get := func(t *testing.T, r *REST, url string) (int, string) {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+
+		gotResponse, _ := ioutil.ReadAll(w.Body)
+		return w.Code, string(gotResponse)
+	}
+
+	entries := []log.Entry{
+		log.FromString("GET /index.html 200"),
+		log.FromString("POST /login 500"),
+		log.FromFields(map[string]any{"message": "get /favicon.ico", "status": 404}),
+		log.FromString("GET /about 200"),
 	}
+
+	t.Run("search", func(t *testing.T) {
+		r := NewREST(&storageMock{entries: entries}, "localhost:8000", 10*time.Second)
+
+		code, got := get(t, r, "/my-bucket-name/search?q=get")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, `{"entries":["GET /index.html 200",{"message":"get /favicon.ico","status":404},"GET /about 200"]}`, got)
+
+		code, got = get(t, r, "/my-bucket-name/search?q=get&limit=2")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, `{"entries":[{"message":"get /favicon.ico","status":404},"GET /about 200"]}`, got)
+
+		code, got = get(t, r, "/search?q=nothing")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, `{"entries":[]}`, got)
+
+		code, got = get(t, r, "/search?limit=0")
+		require.Equal(t, http.StatusBadRequest, code)
+		require.Equal(t, `{"error":"limit must be a positive number"}`, got)
+	})
+
+	t.Run("unsupported by storage", func(t *testing.T) {
+		r := NewREST(&storageMock{}, "localhost:8000", 10*time.Second)
+
+		code, got := get(t, r, "/buckets")
+		require.Equal(t, http.StatusNotImplemented, code)
+		require.Equal(t, `{"error":"listing buckets is not supported by this storage"}`, got)
+
+		code, got = get(t, r, "/my-bucket-name/verify")
+		require.Equal(t, http.StatusNotImplemented, code)
+		require.Equal(t, `{"error":"verification is not supported by this storage"}`, got)
+	})
+
+	t.Run("supported by storage", func(t *testing.T) {
+		r := NewREST(&capableStorageMock{storageMock{entries: entries}}, "localhost:8000", 10*time.Second)
+
+		code, got := get(t, r, "/buckets")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, `{"buckets":["a","b"]}`, got)
+
+		code, got = get(t, r, "/my-bucket-name/verify")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, `{"failed":[],"verified":4}`, got)
+	})
 }
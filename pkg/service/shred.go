@@ -0,0 +1,26 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+)
+
+// shredHandler destroys the encryption keys of a bucket, the response tells how many were destroyed
+func shredHandler(storageOf func(*gin.Context) Storage) gin.HandlerFunc {
+	return ginWrapper(func(c *gin.Context) (gin.H, error) {
+		s, ok := storageOf(c).(Shredder)
+		if !ok {
+			return nil, &statusError{http.StatusNotImplemented, errors.New("shredding is not supported by this storage")}
+		}
+
+		n, err := s.Shred(bucket.NewBucket(c.Param("bucket")))
+		if err != nil {
+			return nil, err
+		}
+
+		return gin.H{"shredded": n}, nil
+	})
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShred(t *testing.T) {
+	keys, err := storage.NewKeyring("", bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	r := NewREST(storage.NewEncrypted(storage.NewMemory(), keys, nil), "localhost:8000", 10*time.Second)
+
+	do := func(t *testing.T, r *REST, method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, do(t, r, "POST", "/app/add", "a secret entry").Code)
+	require.JSONEq(t, `{"entries":["a secret entry"]}`, do(t, r, "GET", "/app/last/1", "").Body.String())
+
+	w := do(t, r, "POST", "/app/shred", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"shredded":1}`, w.Body.String())
+
+	require.JSONEq(t, `{"entries":["[shredded]"]}`, do(t, r, "GET", "/app/last/1", "").Body.String())
+	require.JSONEq(t, `{"count":1}`, do(t, r, "GET", "/app/count", "").Body.String())
+
+	t.Run("not encrypted", func(t *testing.T) {
+		w := do(t, NewREST(storage.NewMemory(), "localhost:8000", 10*time.Second), "POST", "/app/shred", "")
+		require.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+}
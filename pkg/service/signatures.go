@@ -0,0 +1,117 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lootek/go-immulogs/pkg/signing"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// WithSignatures verifies the signatures of the entries against the registry, on writes according to the policy
+// of their bucket, and on reads which then return the result of the verification of every entry
+func WithSignatures(registry signing.Registry, policies signing.Policies) RESTOption {
+	return func(r *REST) {
+		r.registry = registry
+		r.policies = policies
+	}
+}
+
+// checkSignatures enforces the policy of the bucket on the entries but the skipped ones, if any,
+// the returned fields are added to the write response
+func (r *REST) checkSignatures(b bucket.Bucket, entries []log.Entry, skip func(log.Entry) bool) (gin.H, error) {
+	if r.registry == nil {
+		return nil, nil
+	}
+
+	policy := r.policies.For(b.String())
+	if policy == signing.PolicyOff {
+		return nil, nil
+	}
+
+	var unverified int
+	for i, e := range entries {
+		if skip != nil && skip(e) {
+			continue
+		}
+
+		res := r.registry.Verify(e)
+		if res.Status == signing.StatusValid {
+			continue
+		}
+
+		if policy == signing.PolicyRequire {
+			err := fmt.Errorf("entry %d: signature %s", i+1, res.Status)
+			return gin.H{"error": err.Error(), "entry": i + 1}, &statusError{http.StatusForbidden, err}
+		}
+
+		unverified++
+	}
+
+	return gin.H{"unverified": unverified}, nil
+}
+
+// verifiedStorage enforces the signature policy of the buckets on the entries as they're written,
+// i.e. once processed and redacted, so that they read back as they were verified
+type verifiedStorage struct {
+	Storage
+	check func(b bucket.Bucket, entries []log.Entry, skip func(log.Entry) bool) (gin.H, error)
+	skip  func(log.Entry) bool
+}
+
+func (s verifiedStorage) WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error) {
+	checked, err := s.check(b, []log.Entry{e}, s.skip)
+	if err != nil {
+		return checked, err
+	}
+
+	res, err := s.Storage.WriteOne(b, e)
+	return withFields(res, checked), err
+}
+
+func (s verifiedStorage) WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, error) {
+	checked, err := s.check(b, e, s.skip)
+	if err != nil {
+		return checked, err
+	}
+
+	res, err := s.Storage.WriteBatch(b, e)
+	return withFields(res, checked), err
+}
+
+// signatures verifies the entries read, nil when signatures aren't enabled
+func (r *REST) signatures(entries []log.Entry) []signing.Result {
+	if r.registry == nil {
+		return nil
+	}
+
+	results := make([]signing.Result, 0, len(entries))
+	for _, e := range entries {
+		results = append(results, r.registry.Verify(e))
+	}
+
+	return results
+}
+
+// withFields adds the fields to a response, if any
+func withFields(res map[string]any, fields gin.H) map[string]any {
+	if res == nil || len(fields) == 0 {
+		return res
+	}
+
+	for k, v := range fields {
+		res[k] = v
+	}
+
+	return res
+}
+
+func withSignatures(res gin.H, signatures []signing.Result) gin.H {
+	if signatures != nil {
+		res["signatures"] = signatures
+	}
+
+	return res
+}
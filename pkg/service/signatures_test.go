@@ -0,0 +1,142 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/client"
+	"github.com/lootek/go-immulogs/pkg/redact"
+	"github.com/lootek/go-immulogs/pkg/signing"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatures(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	policies, err := signing.ParsePolicies([]string{"flag", "audit=require"})
+	require.NoError(t, err)
+
+	sign := func(t *testing.T, s string) log.Entry {
+		e, err := signing.Sign(log.FromString(s), "billing", priv)
+		require.NoError(t, err)
+		return e
+	}
+
+	post := func(t *testing.T, r *REST, url string, entries ...log.Entry) (int, map[string]any) {
+		body, err := client.EncodeNDJSON(entries)
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest("POST", url, strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+
+		var res map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return w.Code, res
+	}
+
+	t.Run("required", func(t *testing.T) {
+		s := &storageMock{}
+		r := NewREST(s, "localhost:8000", 10*time.Second, WithSignatures(signing.Registry{"billing": pub}, policies))
+
+		code, res := post(t, r, "/audit/batch", sign(t, "#1"), log.FromString("#2"))
+		require.Equal(t, http.StatusForbidden, code)
+		require.Equal(t, map[string]any{"error": "entry 2: signature unsigned", "entry": 2.}, res)
+		require.Empty(t, s.entries)
+
+		code, res = post(t, r, "/audit/batch", sign(t, "#1"), sign(t, "#2"))
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]any{"written": 2., "unverified": 0.}, res)
+
+		req, _ := http.NewRequest("POST", "/audit/add", strings.NewReader("#3"))
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		req, _ = http.NewRequest("POST", "/audit/add", strings.NewReader(sign(t, "#3").String()))
+		w = httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Len(t, s.entries, 3)
+	})
+
+	t.Run("required streamed and imported", func(t *testing.T) {
+		s := &storageMock{}
+		r := NewREST(s, "localhost:8000", 10*time.Second, WithSignatures(signing.Registry{"billing": pub}, policies))
+
+		for n, url := range []string{"/audit/stream", "/audit/import?format=ndjson"} {
+			code, res := post(t, r, url, log.FromString("#1"))
+			require.Equal(t, http.StatusForbidden, code, url)
+			require.Equal(t, "entry 1: signature unsigned", res["error"], url)
+			require.Equal(t, 1., res["failed"], url)
+			require.Len(t, s.entries, n, url)
+
+			code, res = post(t, r, url, sign(t, "#1"))
+			require.Equal(t, http.StatusOK, code, url)
+			require.Equal(t, 0., res["unverified"], url)
+		}
+		require.Len(t, s.entries, 2)
+	})
+
+	t.Run("redacted", func(t *testing.T) {
+		red, err := redact.New(redact.Config{Buckets: []redact.BucketRules{{Bucket: "*", Rules: []redact.Rule{{Name: redact.DetectorEmail}}}}})
+		require.NoError(t, err)
+
+		s := &storageMock{}
+		r := NewREST(s, "localhost:8000", 10*time.Second, WithSignatures(signing.Registry{"billing": pub}, policies), WithRedaction(red))
+
+		// the signatures are verified once redacted, as the entries are read back
+		code, res := post(t, r, "/audit/batch", sign(t, "#1"), sign(t, "to jane@example.com"))
+		require.Equal(t, http.StatusForbidden, code)
+		require.Equal(t, "entry 2: signature invalid", res["error"])
+		require.Empty(t, s.entries)
+
+		code, res = post(t, r, "/app/batch", sign(t, "#1"), sign(t, "to jane@example.com"))
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 1., res["unverified"])
+	})
+
+	t.Run("flagged", func(t *testing.T) {
+		s := &storageMock{}
+		r := NewREST(s, "localhost:8000", 10*time.Second, WithSignatures(signing.Registry{"billing": pub}, policies))
+
+		code, res := post(t, r, "/app/batch", sign(t, "#1"), log.FromString("#2"))
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]any{"written": 2., "unverified": 1.}, res)
+
+		req, _ := http.NewRequest("GET", "/app/last/0", nil)
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var got struct {
+			Signatures []signing.Result `json:"signatures"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		require.Equal(t, []signing.Result{
+			{Producer: "billing", Status: signing.StatusValid},
+			{Status: signing.StatusUnsigned},
+		}, got.Signatures)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := NewREST(&storageMock{}, "localhost:8000", 10*time.Second)
+
+		code, res := post(t, r, "/audit/batch", log.FromString("#1"))
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]any{"written": 1.}, res)
+
+		req, _ := http.NewRequest("GET", "/audit/last/0", nil)
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, `{"entries":["#1"]}`, w.Body.String())
+	})
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 
+	"github.com/lootek/go-immulogs/pkg/archive"
 	"github.com/lootek/go-immulogs/pkg/storage/bucket"
 	"github.com/lootek/go-immulogs/pkg/storage/log"
 )
@@ -18,3 +19,59 @@ type Storage interface {
 	Last(b bucket.Bucket, n uint64) ([]log.Entry, error)
 	Count(b bucket.Bucket) (uint64, error)
 }
+
+// BucketLister is implemented by storages able to enumerate their buckets
+type BucketLister interface {
+	Buckets() ([]bucket.Bucket, error)
+}
+
+// Sizer is implemented by storages able to count the bytes they hold, as stored, e.g. encrypted,
+// in all the buckets for the empty one
+type Sizer interface {
+	Size(b bucket.Bucket) (int64, error)
+}
+
+// StoredSizer is implemented by storages storing the entries with more bytes than they're written with, e.g. encrypted,
+// so that the quotas count the writes the way Sizer counts what's stored
+type StoredSizer interface {
+	StoredSize(b bucket.Bucket, e log.Entry) int64
+}
+
+// storedSize is the number of bytes the entry is stored with, as it is unless the storage says otherwise
+func storedSize(s Storage, b bucket.Bucket, e log.Entry) int64 {
+	if sz, ok := s.(StoredSizer); ok {
+		return sz.StoredSize(b, e)
+	}
+
+	return int64(len(e.Bytes()))
+}
+
+// Importer is implemented by storages restoring some of the imported entries as they were exported, e.g. already
+// encrypted ones, which the other writes don't accept as they are
+type Importer interface {
+	Import(b bucket.Bucket, e []log.Entry) (map[string]any, error)
+	Preserves(e log.Entry) bool
+}
+
+// Verifier is implemented by storages able to cryptographically verify the entries they hold
+type Verifier interface {
+	Verify(b bucket.Bucket) (map[string]any, error)
+}
+
+// Exporter is implemented by storages able to stream all the entries of a bucket, along with their proofs if any
+// the returned state is the one the proofs lead to, the one of the tx unless 0, nil for storages without proofs
+type Exporter interface {
+	Export(ctx context.Context, b bucket.Bucket, tx uint64, fn func(archive.Record) error) (*archive.State, error)
+}
+
+// Stater is implemented by storages which can tell the state they're at, e.g. the latest immudb transaction,
+// along with the number of entries of a bucket committed up to that state
+type Stater interface {
+	State(b bucket.Bucket) (uint64, archive.State, error)
+}
+
+// Shredder is implemented by storages encrypting the entries, destroying the keys of a bucket makes its entries
+// unreadable for good, while they're kept along with their proofs
+type Shredder interface {
+	Shred(b bucket.Bucket) (int, error)
+}
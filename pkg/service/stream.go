@@ -0,0 +1,142 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+const (
+	defaultStreamChunk = 1000
+	maxStreamChunk     = 10000
+)
+
+// ingestSummary is the outcome of a streamed ingestion
+type ingestSummary struct {
+	Written uint64
+	Failed  uint64
+	FirstTx any
+	LastTx  any
+
+	// Unverified counts the entries written without a valid signature, if signatures are verified
+	Unverified *int
+
+	// Err is the first storage error, the remaining chunks are still attempted
+	Err error
+}
+
+func (s ingestSummary) response() map[string]any {
+	res := map[string]any{
+		"written": s.Written,
+		"failed":  s.Failed,
+	}
+
+	if s.FirstTx != nil {
+		res["first_tx"] = s.FirstTx
+		res["last_tx"] = s.LastTx
+	}
+
+	if s.Unverified != nil {
+		res["unverified"] = *s.Unverified
+	}
+
+	if s.Err != nil {
+		res["error"] = s.Err.Error()
+	}
+
+	return res
+}
+
+// status is the error of the response, with the status picked by the storage if any, e.g. for a rejected signature
+func (s ingestSummary) status() error {
+	var statusErr *statusError
+	if errors.As(s.Err, &statusErr) {
+		return s.Err
+	}
+
+	return &statusError{http.StatusInternalServerError, s.Err}
+}
+
+// streamChunkSize reads the chunk size from the "chunk" query parameter
+func streamChunkSize(req *http.Request) (int, error) {
+	v := req.URL.Query().Get("chunk")
+	if v == "" {
+		return defaultStreamChunk, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxStreamChunk {
+		return 0, &statusError{http.StatusBadRequest, fmt.Errorf("chunk must be a number between 1 and %d", maxStreamChunk)}
+	}
+
+	return n, nil
+}
+
+// ingestStream commits the entries read by dec in chunks of at most chunkSize entries,
+// so that only a single chunk is kept in memory at a time
+func ingestStream(s Storage, b bucket.Bucket, dec *batchDecoder, chunkSize int) (ingestSummary, error) {
+	var summary ingestSummary
+	chunk := make([]log.Entry, 0, chunkSize)
+
+	commit := func() {
+		if len(chunk) == 0 {
+			return
+		}
+
+		res, err := s.WriteBatch(b, chunk)
+		if err != nil {
+			summary.Failed += uint64(len(chunk))
+			if summary.Err == nil {
+				summary.Err = err
+			}
+		} else {
+			summary.Written += uint64(len(chunk))
+			if tx, ok := res["id"]; ok {
+				if summary.FirstTx == nil {
+					summary.FirstTx = tx
+				}
+				summary.LastTx = tx
+			}
+			if n, ok := res["unverified"].(int); ok {
+				if summary.Unverified == nil {
+					summary.Unverified = new(int)
+				}
+				*summary.Unverified += n
+			}
+		}
+
+		// storages may keep a reference to the written slice, so it's never reused
+		chunk = make([]log.Entry, 0, chunkSize)
+	}
+
+	for {
+		e, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			commit()
+			return summary, nil
+		}
+		if err != nil {
+			// entries before the malformed one are still committed, the client learns where to resume from
+			commit()
+			return summary, err
+		}
+
+		chunk = append(chunk, e)
+		if len(chunk) >= chunkSize {
+			commit()
+		}
+	}
+}
+
+// disableDeadlines lifts the server-wide timeouts which would otherwise cut off long uploads
+func disableDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+// txStorageMock numbers its transactions and fails the ones listed in failTx
+type txStorageMock struct {
+	storageMock
+	tx     int
+	chunks []int
+	failTx map[int]bool
+}
+
+func (s *txStorageMock) WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, error) {
+	s.tx++
+	if s.failTx[s.tx] {
+		return nil, errors.New("storage unavailable")
+	}
+
+	s.chunks = append(s.chunks, len(e))
+	s.entries = append(s.entries, e...)
+	return map[string]any{"id": s.tx}, nil
+}
+
+func TestStream(t *testing.T) {
+	lines := func(n int) string {
+		var sb strings.Builder
+		for i := 1; i <= n; i++ {
+			sb.WriteString(fmt.Sprintf("a sample log entry #%d\n", i))
+		}
+		return sb.String()
+	}
+
+	tests := []struct {
+		name         string
+		query        string
+		contentType  string
+		body         string
+		failTx       map[int]bool
+		wantStatus   int
+		wantResponse map[string]any
+		wantChunks   []int
+	}{
+		{
+			name:         "default chunk",
+			body:         lines(5),
+			wantStatus:   http.StatusOK,
+			wantResponse: map[string]any{"written": 5., "failed": 0., "first_tx": 1., "last_tx": 1.},
+			wantChunks:   []int{5},
+		},
+		{
+			name:         "bounded chunks",
+			query:        "?chunk=2",
+			body:         lines(5),
+			wantStatus:   http.StatusOK,
+			wantResponse: map[string]any{"written": 5., "failed": 0., "first_tx": 1., "last_tx": 3.},
+			wantChunks:   []int{2, 2, 1},
+		},
+		{
+			name:         "failed chunk",
+			query:        "?chunk=2",
+			body:         lines(5),
+			failTx:       map[int]bool{2: true},
+			wantStatus:   http.StatusInternalServerError,
+			wantResponse: map[string]any{"written": 3., "failed": 2., "first_tx": 1., "last_tx": 3., "error": "storage unavailable"},
+			wantChunks:   []int{2, 1},
+		},
+		{
+			name:        "malformed entry",
+			query:       "?chunk=2",
+			contentType: "application/x-ndjson",
+			body:        "\"#1\"\n\"#2\"\n\"#3\"\n{\n\"#5\"\n",
+			wantStatus:  http.StatusBadRequest,
+			wantResponse: map[string]any{
+				"written": 3., "failed": 0., "first_tx": 1., "last_tx": 2.,
+				"error": "line 4: malformed JSON: unexpected EOF", "line": 4.,
+			},
+			wantChunks: []int{2, 1},
+		},
+		{
+			name:         "invalid chunk",
+			query:        "?chunk=0",
+			body:         lines(1),
+			wantStatus:   http.StatusBadRequest,
+			wantResponse: map[string]any{"error": "chunk must be a number between 1 and 10000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &txStorageMock{failTx: tt.failTx}
+			r := NewREST(s, "localhost:8000", 10*time.Second)
+
+			req, _ := http.NewRequest("POST", "/my-bucket-name/stream"+tt.query, bytes.NewBufferString(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			w := httptest.NewRecorder()
+			r.srv.Handler.ServeHTTP(w, req)
+
+			gotResponse, _ := ioutil.ReadAll(w.Body)
+			require.Equal(t, tt.wantStatus, w.Code, string(gotResponse))
+
+			var got map[string]any
+			require.NoError(t, json.Unmarshal(gotResponse, &got))
+			require.Equal(t, tt.wantResponse, got)
+			require.Equal(t, tt.wantChunks, s.chunks)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TenantHeader names the tenant of a request, unless its credential is bound to one
+const TenantHeader = "X-Immulogs-Tenant"
+
+// tenantContext holds the tenantStorage of a request made within a tenant
+const tenantContext = "immulogs.tenant"
+
+// Tenants returns the storage of a tenant, false if there is no such tenant
+type Tenants func(name string) (Storage, bool)
+
+type tenantStorage struct {
+	name    string
+	storage Storage
+}
+
+// WithTenants isolates the tenants in their own storage, the tenant of a request being the one its credential
+// is bound to, or else the one of the X-Immulogs-Tenant header or of the subdomain of domain, if set,
+// requests without any tenant use the default storage
+func WithTenants(tenants Tenants, domain string) RESTOption {
+	return func(r *REST) {
+		r.tenants = tenants
+		r.tenantDomain = domain
+	}
+}
+
+// resolveTenant is the middleware picking the storage of the tenant of the request
+func (r *REST) resolveTenant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, err := r.tenantOf(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		if name == "" {
+			c.Next()
+			return
+		}
+
+		s, ok := r.tenants(name)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown tenant %q", name)})
+			return
+		}
+
+		c.Set(tenantContext, tenantStorage{name: name, storage: s})
+		c.Next()
+	}
+}
+
+// tenantOf is the tenant the request is made within, a credential bound to a tenant can't be used for another one
+func (r *REST) tenantOf(c *gin.Context) (string, error) {
+	requested := c.GetHeader(TenantHeader)
+	if requested == "" {
+		requested = subdomain(c.Request.Host, r.tenantDomain)
+	}
+
+	p, _ := requestPrincipal(c)
+	switch {
+	case p.Tenant == "":
+		return requested, nil
+	case requested == "" || requested == p.Tenant:
+		return p.Tenant, nil
+	default:
+		return "", fmt.Errorf("not allowed in tenant %s", requested)
+	}
+}
+
+// subdomain is the part of host before domain, e.g. acme for acme.logs.example.com and logs.example.com
+func subdomain(host, domain string) string {
+	if domain == "" {
+		return ""
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(domain))
+	if !ok || strings.Contains(sub, ".") {
+		return ""
+	}
+
+	return sub
+}
+
+// storageOf is the storage of the tenant of the request, the default one if it isn't made within a tenant
+func (r *REST) storageOf(c *gin.Context) Storage {
+	if t, ok := c.Get(tenantContext); ok {
+		return t.(tenantStorage).storage
+	}
+
+	return r.storage
+}
+
+// requestTenant is the tenant the request is made within, empty for the default storage
+func requestTenant(c *gin.Context) string {
+	if t, ok := c.Get(tenantContext); ok {
+		return t.(tenantStorage).name
+	}
+
+	return ""
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/storage"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenants(t *testing.T) {
+	s := storage.NewMemory().WithTenants(map[string]string{"acme": "", "globex": ""})
+	tenants := func(name string) (Storage, bool) {
+		return s.Tenant(name)
+	}
+
+	store, err := auth.NewStore("")
+	require.NoError(t, err)
+	store.Bootstrap("imk_admin")
+
+	r := NewREST(s, "localhost:8000", 10*time.Second, WithAuth(store, bucket.NewBucket("audit")), WithTenants(tenants, "logs.example.com"))
+
+	do := func(t *testing.T, method, url, host, tenant, key, body string) (int, map[string]any) {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Host = host
+		if tenant != "" {
+			req.Header.Set(TenantHeader, tenant)
+		}
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		r.srv.Handler.ServeHTTP(w, req)
+
+		var res map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return w.Code, res
+	}
+
+	count := func(t *testing.T, tenant string) float64 {
+		code, res := do(t, "GET", "/app/count", "", tenant, "imk_admin", "")
+		require.Equal(t, http.StatusOK, code)
+		return res["count"].(float64)
+	}
+
+	t.Run("resolved", func(t *testing.T) {
+		code, _ := do(t, "POST", "/app/add", "", "acme", "imk_admin", "a sample log entry")
+		require.Equal(t, http.StatusOK, code)
+
+		code, _ = do(t, "POST", "/app/add", "globex.logs.example.com:8000", "", "imk_admin", "a sample log entry")
+		require.Equal(t, http.StatusOK, code)
+
+		require.Equal(t, 1., count(t, "acme"))
+		require.Equal(t, 1., count(t, "globex"))
+		require.Equal(t, 0., count(t, ""))
+
+		code, _ = do(t, "GET", "/app/count", "", "initech", "imk_admin", "")
+		require.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("bound keys", func(t *testing.T) {
+		code, res := do(t, "POST", "/keys", "", "acme", "imk_admin", `{"name":"acme-ci","buckets":["*"],"permissions":["read","write","admin"]}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "acme", res["key"].(map[string]any)["tenant"])
+		acme := res["secret"].(string)
+
+		// within its tenant without naming it
+		code, _ = do(t, "POST", "/app/add", "", "", acme, "a sample log entry")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 2., count(t, "acme"))
+
+		code, _ = do(t, "GET", "/app/count", "", "globex", acme, "")
+		require.Equal(t, http.StatusForbidden, code)
+		code, _ = do(t, "GET", "/app/count", "globex.logs.example.com", "", acme, "")
+		require.Equal(t, http.StatusForbidden, code)
+
+		// keys of other tenants aren't visible
+		code, res = do(t, "GET", "/keys", "", "", acme, "")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, res["keys"], 1)
+
+		code, _ = do(t, "DELETE", "/keys/bootstrap", "", "", acme, "")
+		require.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("audited within the tenant", func(t *testing.T) {
+		code, res := do(t, "GET", "/audit/last/1", "", "globex", "imk_admin", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "globex", res["entries"].([]any)[0].(map[string]any)["tenant"])
+	})
+}
+
+func TestSubdomain(t *testing.T) {
+	for _, tt := range []struct {
+		host   string
+		domain string
+		want   string
+	}{
+		{"acme.logs.example.com", "logs.example.com", "acme"},
+		{"ACME.logs.example.com:8000", "logs.example.com", "acme"},
+		{"logs.example.com", "logs.example.com", ""},
+		{"a.b.logs.example.com", "logs.example.com", ""},
+		{"acme.example.org", "logs.example.com", ""},
+		{"acme.logs.example.com", "", ""},
+	} {
+		require.Equal(t, tt.want, subdomain(tt.host, tt.domain), tt.host)
+	}
+}
@@ -0,0 +1,203 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/lootek/go-immulogs/pkg/checkpoint"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+// Signed entries are structured entries carrying the name of their producer and an ed25519 signature
+// the signature covers the compact JSON encoding, with sorted keys, of all the fields but the signature itself
+const (
+	ProducerField  = "_producer"
+	SignatureField = "_signature"
+)
+
+type Status string
+
+const (
+	StatusValid           Status = "valid"
+	StatusInvalid         Status = "invalid"
+	StatusUnsigned        Status = "unsigned"
+	StatusUnknownProducer Status = "unknown_producer"
+)
+
+// Result is the outcome of the verification of an entry
+type Result struct {
+	Producer string `json:"producer,omitempty"`
+	Status   Status `json:"status"`
+}
+
+// Sign returns a copy of the entry signed by the producer, plain entries become structured ones with a message field
+func Sign(e log.Entry, producer string, key ed25519.PrivateKey) (log.Structured, error) {
+	var v any = map[string]any{log.MessageKey: e.String()}
+	if s, ok := e.(log.Structured); ok {
+		v = s.Fields()
+	}
+
+	// round-tripped so that the fields are exactly what the server decodes
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	fields[ProducerField] = producer
+
+	payload, err := signedPayload(fields)
+	if err != nil {
+		return nil, err
+	}
+	fields[SignatureField] = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+
+	return log.FromFields(fields), nil
+}
+
+// Registry holds the public keys of the producers by their names
+type Registry map[string]ed25519.PublicKey
+
+// LoadRegistry reads the PEM encoded public keys of a directory, each named after its producer, e.g. billing.pem
+func LoadRegistry(dir string) (Registry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	r := Registry{}
+	for _, path := range paths {
+		key, err := checkpoint.LoadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		r[strings.TrimSuffix(filepath.Base(path), ".pem")] = key
+	}
+
+	if len(r) == 0 {
+		return nil, fmt.Errorf("%s: no producer keys", dir)
+	}
+
+	return r, nil
+}
+
+// Verify checks the signature of the entry against the key of its producer
+func (r Registry) Verify(e log.Entry) Result {
+	fields, err := decodeFields(e.Bytes())
+	if err != nil {
+		return Result{Status: StatusUnsigned}
+	}
+
+	encoded, ok := fields[SignatureField].(string)
+	if !ok {
+		return Result{Status: StatusUnsigned}
+	}
+
+	producer, _ := fields[ProducerField].(string)
+	key, ok := r[producer]
+	if !ok {
+		return Result{Producer: producer, Status: StatusUnknownProducer}
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Result{Producer: producer, Status: StatusInvalid}
+	}
+
+	payload, err := signedPayload(fields)
+	if err != nil || !ed25519.Verify(key, payload, sig) {
+		return Result{Producer: producer, Status: StatusInvalid}
+	}
+
+	return Result{Producer: producer, Status: StatusValid}
+}
+
+func decodeFields(data []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return nil, errors.New("not an object")
+	}
+
+	return fields, nil
+}
+
+func signedPayload(fields map[string]any) ([]byte, error) {
+	signed := make(map[string]any, len(fields))
+	for k, v := range fields {
+		if k != SignatureField {
+			signed[k] = v
+		}
+	}
+
+	return json.Marshal(signed)
+}
+
+// Policy tells what happens to entries without a valid signature written to a bucket
+type Policy string
+
+const (
+	// PolicyOff accepts all the entries
+	PolicyOff Policy = "off"
+	// PolicyFlag accepts all the entries but reports how many aren't validly signed
+	PolicyFlag Policy = "flag"
+	// PolicyRequire rejects writes with any entry which isn't validly signed
+	PolicyRequire Policy = "require"
+)
+
+// Policies are the policies of the buckets, Default applies to the buckets without their own
+type Policies struct {
+	Default Policy
+	Buckets map[string]Policy
+}
+
+// ParsePolicies reads policies given as bucket=policy, or just policy for the default one
+func ParsePolicies(specs []string) (Policies, error) {
+	p := Policies{Default: PolicyOff, Buckets: map[string]Policy{}}
+
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			name, value = "", spec
+		}
+
+		policy := Policy(value)
+		switch policy {
+		case PolicyOff, PolicyFlag, PolicyRequire:
+		default:
+			return Policies{}, fmt.Errorf("unknown signature policy %q", value)
+		}
+
+		if name == "" {
+			p.Default = policy
+		} else {
+			p.Buckets[name] = policy
+		}
+	}
+
+	return p, nil
+}
+
+func (p Policies) For(bucket string) Policy {
+	if policy, ok := p.Buckets[bucket]; ok {
+		return policy
+	}
+
+	return p.Default
+}
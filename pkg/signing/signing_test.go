@@ -0,0 +1,99 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigning(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	r := Registry{"billing": pub}
+
+	t.Run("structured", func(t *testing.T) {
+		signed, err := Sign(log.FromFields(map[string]any{
+			"message": "<paid>",
+			"amount":  12.5,
+			"at":      time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		}), "billing", priv)
+		require.NoError(t, err)
+		require.Equal(t, "billing", signed.Fields()[ProducerField])
+
+		require.Equal(t, Result{Producer: "billing", Status: StatusValid}, r.Verify(signed))
+		// as stored and read back
+		require.Equal(t, Result{Producer: "billing", Status: StatusValid}, r.Verify(log.FromBytes(signed.Bytes())))
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		signed, err := Sign(log.FromString("a sample log entry"), "billing", priv)
+		require.NoError(t, err)
+		require.Equal(t, "a sample log entry", signed.Fields()[log.MessageKey])
+		require.Equal(t, StatusValid, r.Verify(signed).Status)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		signed, err := Sign(log.FromString("a sample log entry"), "billing", priv)
+		require.NoError(t, err)
+
+		signed.Fields()[log.MessageKey] = "another log entry"
+		require.Equal(t, Result{Producer: "billing", Status: StatusInvalid}, r.Verify(signed))
+	})
+
+	t.Run("impersonated", func(t *testing.T) {
+		_, other, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+
+		signed, err := Sign(log.FromString("a sample log entry"), "billing", other)
+		require.NoError(t, err)
+		require.Equal(t, StatusInvalid, r.Verify(signed).Status)
+
+		signed, err = Sign(log.FromString("a sample log entry"), "shipping", other)
+		require.NoError(t, err)
+		require.Equal(t, Result{Producer: "shipping", Status: StatusUnknownProducer}, r.Verify(signed))
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		require.Equal(t, StatusUnsigned, r.Verify(log.FromString("a sample log entry")).Status)
+		require.Equal(t, StatusUnsigned, r.Verify(log.FromString("null")).Status)
+		require.Equal(t, StatusUnsigned, r.Verify(log.FromFields(map[string]any{"message": "a sample log entry"})).Status)
+	})
+
+	t.Run("load registry", func(t *testing.T) {
+		dir := t.TempDir()
+
+		_, err := LoadRegistry(dir)
+		require.Error(t, err)
+
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "billing.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+		got, err := LoadRegistry(dir)
+		require.NoError(t, err)
+		require.Equal(t, r, got)
+	})
+}
+
+func TestPolicies(t *testing.T) {
+	p, err := ParsePolicies([]string{"flag", "audit=require", "debug=off"})
+	require.NoError(t, err)
+	require.Equal(t, PolicyFlag, p.For("app"))
+	require.Equal(t, PolicyRequire, p.For("audit"))
+	require.Equal(t, PolicyOff, p.For("debug"))
+
+	p, err = ParsePolicies(nil)
+	require.NoError(t, err)
+	require.Equal(t, PolicyOff, p.For("app"))
+
+	_, err = ParsePolicies([]string{"audit=strict"})
+	require.Error(t, err)
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lootek/go-immulogs/pkg/archive"
+	"github.com/lootek/go-immulogs/pkg/auth"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+)
+
+const (
+	// sealedPrefix starts the stored value of the encrypted entries, followed by the key ID and the ciphertext
+	sealedPrefix = "immulogs:sealed:v1:"
+
+	// ShreddedEntry is read in place of the entries whose key was shredded
+	ShreddedEntry = "[shredded]"
+	// UnreadableEntry is read in place of the entries which can't be decrypted, followed by the reason
+	UnreadableEntry = "[unreadable]"
+)
+
+const (
+	// keyIDSize is the number of random bytes of the key IDs, hex encoded
+	keyIDSize = 8
+	// sealOverhead is the nonce and the tag AES-GCM adds to the plaintext
+	sealOverhead = 12 + 16
+)
+
+// errUnsupported is returned by the optional features the encrypted storage lacks
+var errUnsupported = errors.New("not supported by the storage")
+
+// Backend is the storage the entries are encrypted for, e.g. ImmuDB or Memory
+type Backend interface {
+	Start(context.Context) error
+	Stop() error
+
+	WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error)
+	WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, error)
+
+	All(b bucket.Bucket) ([]log.Entry, error)
+	Last(b bucket.Bucket, n uint64) ([]log.Entry, error)
+	Count(b bucket.Bucket) (uint64, error)
+}
+
+// Encrypted encrypts the entries with AES-GCM before writing them to the backend, with a data key per bucket
+// kept in the keyring, only their values are encrypted so that the proofs of the backend hold as they are
+type Encrypted struct {
+	Backend
+	keys    *Keyring
+	tenant  string
+	buckets []string
+}
+
+// NewEncrypted encrypts the entries of the buckets matching any of the patterns, as in auth.Match, all if none
+func NewEncrypted(s Backend, keys *Keyring, buckets []string) *Encrypted {
+	return &Encrypted{Backend: s, keys: keys, buckets: buckets}
+}
+
+// ForTenant encrypts the entries of the storage of the tenant, with keys of its own
+func (e *Encrypted) ForTenant(name string, s Backend) *Encrypted {
+	return &Encrypted{Backend: s, keys: e.keys, tenant: name, buckets: e.buckets}
+}
+
+func (e *Encrypted) WriteOne(b bucket.Bucket, entry log.Entry) (map[string]any, error) {
+	sealed, err := e.seal(b, []log.Entry{entry})
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Backend.WriteOne(b, sealed[0])
+}
+
+func (e *Encrypted) WriteBatch(b bucket.Bucket, entries []log.Entry) (map[string]any, error) {
+	sealed, err := e.seal(b, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Backend.WriteBatch(b, sealed)
+}
+
+// Import writes the entries as they were exported, the encrypted ones as they are, provided they were encrypted
+// for the bucket, and the others encrypted as new ones
+func (e *Encrypted) Import(b bucket.Bucket, entries []log.Entry) (map[string]any, error) {
+	imported := make([]log.Entry, len(entries))
+	for i, entry := range entries {
+		if !e.Preserves(entry) {
+			sealed, err := e.seal(b, []log.Entry{entry})
+			if err != nil {
+				return nil, err
+			}
+			imported[i] = sealed[0]
+			continue
+		}
+
+		if _, err := e.decrypt(b, entry.String()); err != nil && !errors.Is(err, ErrShredded) {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		imported[i] = entry
+	}
+
+	return e.Backend.WriteBatch(b, imported)
+}
+
+// Preserves tells whether the entry is imported as it is, i.e. if it's already encrypted
+func (e *Encrypted) Preserves(entry log.Entry) bool {
+	return strings.HasPrefix(entry.String(), sealedPrefix)
+}
+
+func (e *Encrypted) All(b bucket.Bucket) ([]log.Entry, error) {
+	entries, err := e.Backend.All(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.open(b, entries), nil
+}
+
+func (e *Encrypted) Last(b bucket.Bucket, n uint64) ([]log.Entry, error) {
+	entries, err := e.Backend.Last(b, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.open(b, entries), nil
+}
+
+// Shred destroys the keys of the bucket, its entries can't be read anymore but they're still there,
+// along with their proofs, it returns the number of keys destroyed
+func (e *Encrypted) Shred(b bucket.Bucket) (int, error) {
+	return e.keys.Shred(e.tenant, b.String())
+}
+
+func (e *Encrypted) Buckets() ([]bucket.Bucket, error) {
+	l, ok := e.Backend.(interface {
+		Buckets() ([]bucket.Bucket, error)
+	})
+	if !ok {
+		return nil, errUnsupported
+	}
+
+	return l.Buckets()
+}
+
+// Size counts the bytes of the encrypted entries, as stored
+func (e *Encrypted) Size(b bucket.Bucket) (int64, error) {
+	s, ok := e.Backend.(interface {
+		Size(bucket.Bucket) (int64, error)
+	})
+	if !ok {
+		return 0, errUnsupported
+	}
+
+	return s.Size(b)
+}
+
+// StoredSize is the number of bytes the entry is stored with in the bucket, once encrypted if it is
+func (e *Encrypted) StoredSize(b bucket.Bucket, entry log.Entry) int64 {
+	n := len(entry.Bytes())
+	if !e.encrypts(b) {
+		return int64(n)
+	}
+
+	return int64(len(sealedPrefix) + hex.EncodedLen(keyIDSize) + 1 + base64.RawStdEncoding.EncodedLen(n+sealOverhead))
+}
+
+// Verify verifies the encrypted entries, shredded ones included
+func (e *Encrypted) Verify(b bucket.Bucket) (map[string]any, error) {
+	v, ok := e.Backend.(interface {
+		Verify(bucket.Bucket) (map[string]any, error)
+	})
+	if !ok {
+		return nil, errUnsupported
+	}
+
+	return v.Verify(b)
+}
+
+func (e *Encrypted) State(b bucket.Bucket) (uint64, archive.State, error) {
+	s, ok := e.Backend.(interface {
+		State(bucket.Bucket) (uint64, archive.State, error)
+	})
+	if !ok {
+		return 0, archive.State{}, errUnsupported
+	}
+
+	return s.State(b)
+}
+
+// Export streams the encrypted entries, since the proofs are of those, they're imported back as they are
+func (e *Encrypted) Export(ctx context.Context, b bucket.Bucket, tx uint64, fn func(archive.Record) error) (*archive.State, error) {
+	ex, ok := e.Backend.(interface {
+		Export(context.Context, bucket.Bucket, uint64, func(archive.Record) error) (*archive.State, error)
+	})
+	if !ok {
+		return nil, errUnsupported
+	}
+
+	return ex.Export(ctx, b, tx, fn)
+}
+
+func (e *Encrypted) encrypts(b bucket.Bucket) bool {
+	if len(e.buckets) == 0 {
+		return true
+	}
+
+	for _, pattern := range e.buckets {
+		if auth.Match(pattern, b.String()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// seal encrypts the entries, those looking already encrypted included, only Import writes them as they are,
+// the buckets not encrypted reject them, since they'd be read as encrypted
+func (e *Encrypted) seal(b bucket.Bucket, entries []log.Entry) ([]log.Entry, error) {
+	if !e.encrypts(b) {
+		for i, entry := range entries {
+			if strings.HasPrefix(entry.String(), sealedPrefix) {
+				return nil, fmt.Errorf("entry %d: starts with the reserved prefix %q", i+1, sealedPrefix)
+			}
+		}
+		return entries, nil
+	}
+
+	id, aead, err := e.keys.current(e.tenant, b.String())
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]log.Entry, len(entries))
+	for i, entry := range entries {
+		ciphertext, err := seal(aead, entry.Bytes(), b.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		sealed[i] = log.FromString(sealedPrefix + id + ":" + base64.RawStdEncoding.EncodeToString(ciphertext))
+	}
+
+	return sealed, nil
+}
+
+// open decrypts the entries, the ones written before the encryption was enabled are returned as they are,
+// the ones which can't be decrypted are replaced one by one, by ShreddedEntry or UnreadableEntry
+func (e *Encrypted) open(b bucket.Bucket, entries []log.Entry) []log.Entry {
+	opened := make([]log.Entry, len(entries))
+	for i, entry := range entries {
+		if !strings.HasPrefix(entry.String(), sealedPrefix) {
+			opened[i] = entry
+			continue
+		}
+
+		plain, err := e.decrypt(b, entry.String())
+		switch {
+		case errors.Is(err, ErrShredded):
+			opened[i] = log.FromString(ShreddedEntry)
+		case err != nil:
+			opened[i] = log.FromString(UnreadableEntry + " " + err.Error())
+		default:
+			opened[i] = log.FromBytes(plain)
+		}
+	}
+
+	return opened
+}
+
+// decrypt opens a value encrypted with one of the keys of the bucket, of any bucket for the empty one,
+// the bucket of the key being the one the value was encrypted for
+func (e *Encrypted) decrypt(b bucket.Bucket, value string) ([]byte, error) {
+	id, encoded, _ := strings.Cut(strings.TrimPrefix(value, sealedPrefix), ":")
+	keyBucket, aead, err := e.keys.get(e.tenant, id)
+	switch {
+	case err != nil && !errors.Is(err, ErrShredded):
+		return nil, err
+	case b.String() != "" && keyBucket != b.String():
+		return nil, fmt.Errorf("key %s is not one of the bucket", id)
+	case err != nil:
+		return nil, err
+	}
+
+	ciphertext, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, ciphertext, []byte(keyBucket))
+}
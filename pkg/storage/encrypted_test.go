@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lootek/go-immulogs/pkg/archive"
+	"github.com/lootek/go-immulogs/pkg/storage/bucket"
+	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncrypted(t *testing.T) {
+	master := bytes.Repeat([]byte{7}, 32)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	keys, err := NewKeyring(path, master)
+	require.NoError(t, err)
+
+	m := NewMemory().WithTenants(map[string]string{"acme": ""})
+	e := NewEncrypted(m, keys, []string{"app*"})
+	app, other := bucket.NewBucket("app"), bucket.NewBucket("other")
+
+	_, err = e.WriteOne(app, log.FromString("a secret entry"))
+	require.NoError(t, err)
+	_, err = e.WriteBatch(app, []log.Entry{log.FromString("#1"), log.FromFields(map[string]any{"msg": "#2"})})
+	require.NoError(t, err)
+	_, err = e.WriteOne(other, log.FromString("a public entry"))
+	require.NoError(t, err)
+
+	t.Run("read", func(t *testing.T) {
+		entries, err := e.All(app)
+		require.NoError(t, err)
+		require.Equal(t, []log.Entry{log.FromString("a secret entry"), log.FromString("#1"), log.FromString(`{"msg":"#2"}`)}, entries)
+
+		entries, err = e.Last(other, 1)
+		require.NoError(t, err)
+		require.Equal(t, []log.Entry{log.FromString("a public entry")}, entries)
+	})
+
+	t.Run("global read", func(t *testing.T) {
+		// every entry is decrypted with the key of its own bucket
+		_, err := e.WriteOne(bucket.NewBucket("app-b"), log.FromString("another secret entry"))
+		require.NoError(t, err)
+
+		entries, err := e.All(bucket.NewBucket(""))
+		require.NoError(t, err)
+		require.ElementsMatch(t, []log.Entry{
+			log.FromString("a secret entry"),
+			log.FromString("#1"),
+			log.FromString(`{"msg":"#2"}`),
+			log.FromString("a public entry"),
+			log.FromString("another secret entry"),
+		}, entries)
+	})
+
+	t.Run("stored encrypted", func(t *testing.T) {
+		entries, err := m.All(app)
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		for _, entry := range entries {
+			require.True(t, strings.HasPrefix(entry.String(), sealedPrefix))
+			require.NotContains(t, entry.String(), "secret")
+		}
+
+		// the ciphertext is bound to its bucket, the entries which can't be decrypted are reported one by one
+		_, err = m.WriteBatch(bucket.NewBucket("app2"), []log.Entry{entries[0], log.FromString("a plain entry")})
+		require.NoError(t, err)
+		entries, err = e.All(bucket.NewBucket("app2"))
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.Regexp(t, `^\[unreadable\] key [0-9a-f]+ is not one of the bucket$`, entries[0].String())
+		require.Equal(t, log.FromString("a plain entry"), entries[1])
+	})
+
+	t.Run("reloaded", func(t *testing.T) {
+		reloaded, err := NewKeyring(path, master)
+		require.NoError(t, err)
+		entries, err := NewEncrypted(m, reloaded, nil).All(app)
+		require.NoError(t, err)
+		require.Equal(t, log.FromString("a secret entry"), entries[0])
+
+		_, err = NewKeyring(path, bytes.Repeat([]byte{8}, 32))
+		require.ErrorContains(t, err, "wrong master key")
+	})
+
+	t.Run("export and import", func(t *testing.T) {
+		var records []archive.Record
+		_, err := e.Export(context.Background(), app, 0, func(r archive.Record) error {
+			records = append(records, r)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+
+		// already encrypted entries are written as they are by imports only, the other writes encrypt them again
+		_, err = e.WriteOne(app, log.FromString(records[0].Value))
+		require.NoError(t, err)
+		_, err = e.Import(app, []log.Entry{log.FromString(records[0].Value), log.FromString("a plain entry")})
+		require.NoError(t, err)
+		entries, err := e.All(app)
+		require.NoError(t, err)
+		require.Equal(t, []log.Entry{
+			log.FromString(records[0].Value),
+			log.FromString("a secret entry"),
+			log.FromString("a plain entry"),
+		}, entries[3:])
+
+		// provided they were encrypted for the bucket
+		_, err = e.Import(bucket.NewBucket("app2"), []log.Entry{log.FromString(records[0].Value)})
+		require.ErrorContains(t, err, "entry 1: key")
+		_, err = e.WriteOne(other, log.FromString(records[0].Value))
+		require.ErrorContains(t, err, "entry 1: starts with the reserved prefix")
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		acme, _ := m.Tenant("acme")
+		te := e.ForTenant("acme", acme)
+		_, err := te.WriteOne(app, log.FromString("a tenant entry"))
+		require.NoError(t, err)
+
+		n, err := te.Shred(app)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		// the keys of the default storage are left alone
+		entries, err := e.All(app)
+		require.NoError(t, err)
+		require.Equal(t, log.FromString("a secret entry"), entries[0])
+	})
+
+	t.Run("shred", func(t *testing.T) {
+		n, err := e.Shred(app)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		entries, err := e.All(app)
+		require.NoError(t, err)
+		require.Len(t, entries, 6)
+		for _, entry := range entries {
+			require.Equal(t, ShreddedEntry, entry.String())
+		}
+
+		// the new entries get a new key
+		_, err = e.WriteOne(app, log.FromString("a new entry"))
+		require.NoError(t, err)
+		entries, err = e.All(app)
+		require.NoError(t, err)
+		require.Equal(t, log.FromString("a new entry"), entries[6])
+
+		// for good
+		reloaded, err := NewKeyring(path, master)
+		require.NoError(t, err)
+		entries, err = NewEncrypted(m, reloaded, nil).All(app)
+		require.NoError(t, err)
+		require.Equal(t, ShreddedEntry, entries[0].String())
+		require.Equal(t, "a new entry", entries[6].String())
+
+		// only the new key and the one of app-b are left in the keyring file
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, 2, strings.Count(string(data), `"wrapped"`))
+		require.Equal(t, 2, strings.Count(string(data), `"shredded_at"`))
+	})
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, 32)
+	dir := t.TempDir()
+
+	for name, data := range map[string][]byte{
+		"raw": key,
+		"hex": []byte(hex.EncodeToString(key) + "\n"),
+		"b64": []byte("q6urq6urq6urq6urq6urq6urq6urq6urq6urq6urq6s="),
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+
+		got, err := LoadMasterKey(path)
+		require.NoError(t, err, name)
+		require.Equal(t, key, got, name)
+	}
+
+	path := filepath.Join(dir, "short")
+	require.NoError(t, os.WriteFile(path, []byte("abcd"), 0o600))
+	_, err := LoadMasterKey(path)
+	require.EqualError(t, err, path+": not a 32 bytes key")
+}
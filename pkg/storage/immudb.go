@@ -2,19 +2,30 @@ package storage
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/codenotary/immudb/embedded/store"
 	"github.com/codenotary/immudb/pkg/api/schema"
 	immudb "github.com/codenotary/immudb/pkg/client"
 	"github.com/google/uuid"
+	"github.com/lootek/go-immulogs/pkg/archive"
 	"github.com/lootek/go-immulogs/pkg/storage/bucket"
 	"github.com/lootek/go-immulogs/pkg/storage/log"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 const (
 	defaultTimeout = 3 * time.Second
+
+	// exportPageSize is the number of keys scanned at once while exporting
+	exportPageSize = 1000
 )
 
 type ImmuDB struct {
@@ -23,15 +34,41 @@ type ImmuDB struct {
 
 	client ImmuClient
 	opts   *immudb.Options
+	ids    sortableIDs
+
+	// tenants have their own database, and so their own client and session
+	tenants map[string]*ImmuDB
 }
 
 func NewImmuDB(opts *immudb.Options) *ImmuDB {
+	ctx, cancelFn := context.WithCancel(context.Background())
+
 	return &ImmuDB{
-		client: immuClientWrapper{immudb.NewClient().WithOptions(opts)},
-		opts:   opts,
+		ctx:      ctx,
+		cancelFn: cancelFn,
+		client:   immuClientWrapper{immudb.NewClient().WithOptions(opts)},
+		opts:     opts,
 	}
 }
 
+// WithTenants isolates every tenant in its own database, given by the name of the tenant
+func (i *ImmuDB) WithTenants(databases map[string]string) *ImmuDB {
+	i.tenants = make(map[string]*ImmuDB, len(databases))
+	for tenant, database := range databases {
+		opts := *i.opts
+		opts.Database = database
+		i.tenants[tenant] = NewImmuDB(&opts)
+	}
+
+	return i
+}
+
+// Tenant is the storage of the tenant, false if there is no such tenant
+func (i *ImmuDB) Tenant(name string) (*ImmuDB, bool) {
+	t, ok := i.tenants[name]
+	return t, ok
+}
+
 // immuClientWrapper is a hack to make ImmuClient possible to implement locally (and mockable for tests)
 // immudb.ImmuClient is impossible to implement here directly as it relies on an unexported type immudb.*immuClient
 type immuClientWrapper struct {
@@ -43,6 +80,30 @@ func (i immuClientWrapper) WithOptions(options *immudb.Options) ImmuClient {
 	return i
 }
 
+// VerifiableGet returns the entry along with its proofs, unverified, so that they can be verified elsewhere
+func (i immuClientWrapper) VerifiableGet(ctx context.Context, req *schema.VerifiableGetRequest) (*schema.VerifiableEntry, error) {
+	sc := i.GetServiceClient()
+
+	ve, err := sc.VerifiableGet(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ProveSinceTx == 0 || ve.VerifiableTx == nil || ve.VerifiableTx.DualProof == nil {
+		return ve, nil
+	}
+
+	// the server may leave out the linear advance proof, which the client would fetch on its own while verifying
+	// it's filled in here instead so that the proof is complete offline
+	dp := schema.DualProofFromProto(ve.VerifiableTx.DualProof)
+	if err := schema.FillMissingLinearAdvanceProof(ctx, dp, dp.SourceTxHeader.ID, dp.TargetTxHeader.ID, sc); err != nil {
+		return nil, err
+	}
+	ve.VerifiableTx.DualProof = schema.DualProofToProto(dp)
+
+	return ve, nil
+}
+
 // ImmuClient is a subset of insanely huge immudb.ImmuClient
 // it contains only the functions we really need
 type ImmuClient interface {
@@ -52,22 +113,36 @@ type ImmuClient interface {
 	Set(ctx context.Context, key []byte, value []byte) (*schema.TxHeader, error)
 	Scan(ctx context.Context, req *schema.ScanRequest) (*schema.Entries, error)
 	SetAll(ctx context.Context, kvList *schema.SetRequest) (*schema.TxHeader, error)
+	VerifiedGet(ctx context.Context, key []byte, opts ...immudb.GetOption) (*schema.Entry, error)
+	VerifiableGet(ctx context.Context, req *schema.VerifiableGetRequest) (*schema.VerifiableEntry, error)
+	CurrentState(ctx context.Context) (*schema.ImmutableState, error)
+	TxByID(ctx context.Context, tx uint64) (*schema.Tx, error)
 }
 
+// Start opens the session, the storage is stopped along with the context, the context of the writes is
+// created in NewImmuDB so that it's there before the session is
 func (i *ImmuDB) Start(ctx context.Context) error {
-	ctx, cancelFn := context.WithCancel(context.Background())
-	i.ctx = ctx
-	i.cancelFn = cancelFn
+	context.AfterFunc(ctx, i.cancelFn)
 
 	err := i.client.OpenSession(ctx, []byte(i.opts.Username), []byte(i.opts.Password), i.opts.Database)
 	if err != nil {
 		return err
 	}
 
+	for tenant, t := range i.tenants {
+		if err := t.Start(ctx); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	}
+
 	return nil
 }
 
 func (i *ImmuDB) Stop() error {
+	for _, t := range i.tenants {
+		t.Stop()
+	}
+
 	i.cancelFn()
 	return nil
 }
@@ -109,26 +184,73 @@ func (i *ImmuDB) WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, err
 	return resp, nil
 }
 
+// All pages through the entries of the bucket, the scans are limited by the server
 func (i *ImmuDB) All(b bucket.Bucket) ([]log.Entry, error) {
-	return i.Last(b, 0)
+	var entries []log.Entry
+	err := i.scanBucket(i.ctx, b, func(e *schema.Entry) (bool, error) {
+		entries = append(entries, log.FromBytes(e.Value))
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
 }
 
+// Last pages through the entries of the bucket from the most recent ones, the keys sorting in the order of writes,
+// the entries of all the buckets are sorted by their ID since the keys start with the bucket
 func (i *ImmuDB) Last(b bucket.Bucket, n uint64) ([]log.Entry, error) {
-	ctx, cancelFn := context.WithTimeout(i.ctx, defaultTimeout)
-	defer cancelFn()
+	if n == 0 {
+		return i.All(b)
+	}
+	if b.String() == "" {
+		return i.lastOfAll(n)
+	}
 
-	scanned, err := i.client.Scan(ctx, &schema.ScanRequest{
-		Prefix: b.Bytes(),
-		Desc:   false,
-		Limit:  n,
+	var entries []log.Entry
+	var seek []byte
+	for uint64(len(entries)) < n {
+		page, err := i.scanPage(i.ctx, scanPrefix(b), seek, true)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range page {
+			if inBucket(e.Key, b) && uint64(len(entries)) < n {
+				entries = append(entries, log.FromBytes(e.Value))
+			}
+		}
+
+		if len(page) < exportPageSize {
+			break
+		}
+		seek = page[len(page)-1].Key
+	}
+
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// lastOfAll returns the n most recent entries of all the buckets
+func (i *ImmuDB) lastOfAll(n uint64) ([]log.Entry, error) {
+	var scanned []*schema.Entry
+	err := i.scanBucket(i.ctx, bucket.NewBucket(""), func(e *schema.Entry) (bool, error) {
+		scanned = append(scanned, e)
+		return true, nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	var entries []log.Entry
-	for _, e := range scanned.Entries {
-		entries = append(entries, log.FromBytes(e.Value))
+	sort.SliceStable(scanned, func(a, b int) bool { return idOf(scanned[a].Key) < idOf(scanned[b].Key) })
+	if uint64(len(scanned)) > n {
+		scanned = scanned[uint64(len(scanned))-n:]
+	}
+
+	entries := make([]log.Entry, len(scanned))
+	for i, e := range scanned {
+		entries[i] = log.FromBytes(e.Value)
 	}
 
 	return entries, nil
@@ -139,6 +261,307 @@ func (i *ImmuDB) Count(b bucket.Bucket) (uint64, error) {
 	return uint64(len(entries)), err
 }
 
+// Size counts the bytes of the values of the bucket, of all of them for the empty one, as stored
+func (i *ImmuDB) Size(b bucket.Bucket) (int64, error) {
+	var size int64
+	err := i.scanBucket(i.ctx, b, func(e *schema.Entry) (bool, error) {
+		size += int64(len(e.Value))
+		return true, nil
+	})
+
+	return size, err
+}
+
+// Buckets pages through all the keys, the scans are limited by the server
+func (i *ImmuDB) Buckets() ([]bucket.Bucket, error) {
+	seen := map[string]struct{}{}
+	var buckets []bucket.Bucket
+
+	var seek []byte
+	for {
+		entries, err := i.scanPage(i.ctx, nil, seek, false)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			name := bucketOf(e.Key)
+			if _, ok := seen[name]; ok || name == "" {
+				continue
+			}
+
+			seen[name] = struct{}{}
+			buckets = append(buckets, bucket.NewBucket(name))
+		}
+
+		if len(entries) < exportPageSize {
+			break
+		}
+		seek = entries[len(entries)-1].Key
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].String() < buckets[j].String() })
+
+	return buckets, nil
+}
+
+// Verify checks the inclusion and consistency proofs of every entry of the bucket against the server state,
+// the entries failing their proofs are listed, any other error, e.g. of the connection, fails the verification
+func (i *ImmuDB) Verify(b bucket.Bucket) (map[string]any, error) {
+	var verified int
+	failed := []string{}
+	err := i.scanBucket(i.ctx, b, func(e *schema.Entry) (bool, error) {
+		ok, err := i.verifyEntry(e.Key)
+		if err != nil {
+			return false, fmt.Errorf("verifying %s: %w", e.Key, err)
+		}
+
+		if ok {
+			verified++
+		} else {
+			failed = append(failed, string(e.Key))
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"verified": verified,
+		"failed":   failed,
+	}, nil
+}
+
+// verifyEntry tells whether the proofs of the entry hold
+func (i *ImmuDB) verifyEntry(key []byte) (bool, error) {
+	ctx, cancelFn := context.WithTimeout(i.ctx, defaultTimeout)
+	defer cancelFn()
+
+	_, err := i.client.VerifiedGet(ctx, key)
+	switch {
+	case errors.Is(err, store.ErrCorruptedData):
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+// State returns the current state of the database and the number of entries of the bucket committed up to it
+func (i *ImmuDB) State(b bucket.Bucket) (uint64, archive.State, error) {
+	ctx, cancelFn := context.WithTimeout(i.ctx, defaultTimeout)
+	defer cancelFn()
+
+	state, err := i.client.CurrentState(ctx)
+	if err != nil {
+		return 0, archive.State{}, err
+	}
+
+	var cnt uint64
+	err = i.scanBucket(i.ctx, b, func(e *schema.Entry) (bool, error) {
+		if e.Tx <= state.TxId {
+			cnt++
+		}
+		return true, nil
+	})
+	if err != nil {
+		return 0, archive.State{}, err
+	}
+
+	return cnt, archive.State{
+		Database: state.Db,
+		TxID:     state.TxId,
+		TxHash:   state.TxHash,
+	}, nil
+}
+
+// Export streams the entries of the bucket committed up to the tx, the current state if 0, each with the proof leading
+// to it, e.g. to the tx of a checkpoint so that the archive is verified against the checkpoint
+func (i *ImmuDB) Export(ctx context.Context, b bucket.Bucket, tx uint64, fn func(archive.Record) error) (*archive.State, error) {
+	state, err := i.stateAt(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = i.scanBucket(ctx, b, func(e *schema.Entry) (bool, error) {
+		// written after the state exported
+		if e.Tx > state.TxID {
+			return true, nil
+		}
+
+		r, err := i.exportRecord(ctx, e, state.TxID)
+		if err != nil {
+			return false, err
+		}
+
+		return true, fn(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// stateAt is the state of the database at the tx, the current one if 0
+func (i *ImmuDB) stateAt(ctx context.Context, tx uint64) (*archive.State, error) {
+	ctx, cancelFn := context.WithTimeout(ctx, defaultTimeout)
+	defer cancelFn()
+
+	current, err := i.client.CurrentState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case tx == 0 || tx == current.TxId:
+		return &archive.State{Database: current.Db, TxID: current.TxId, TxHash: current.TxHash}, nil
+	case tx > current.TxId:
+		return nil, fmt.Errorf("tx %d is past the current state at tx %d", tx, current.TxId)
+	}
+
+	t, err := i.client.TxByID(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	alh := schema.TxHeaderFromProto(t.Header).Alh()
+
+	return &archive.State{Database: current.Db, TxID: tx, TxHash: alh[:]}, nil
+}
+
+// scanBucket calls fn with the entries of the bucket in order, page by page, until it returns false,
+// the keys of the other buckets starting with the same prefix, e.g. "app_x_..." for "app", are skipped
+func (i *ImmuDB) scanBucket(ctx context.Context, b bucket.Bucket, fn func(e *schema.Entry) (bool, error)) error {
+	prefix := scanPrefix(b)
+
+	var seek []byte
+	for {
+		entries, err := i.scanPage(ctx, prefix, seek, false)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if !inBucket(e.Key, b) {
+				continue
+			}
+
+			if more, err := fn(e); err != nil || !more {
+				return err
+			}
+		}
+
+		if len(entries) < exportPageSize {
+			return nil
+		}
+		seek = entries[len(entries)-1].Key
+	}
+}
+
+// scanPage scans the keys following the seek one, or preceding it when desc, prefix limits them to a bucket,
+// none to all the keys
+func (i *ImmuDB) scanPage(ctx context.Context, prefix []byte, seek []byte, desc bool) ([]*schema.Entry, error) {
+	ctx, cancelFn := context.WithTimeout(ctx, defaultTimeout)
+	defer cancelFn()
+
+	scanned, err := i.client.Scan(ctx, &schema.ScanRequest{
+		Prefix:  prefix,
+		SeekKey: seek,
+		Desc:    desc,
+		Limit:   exportPageSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return scanned.Entries, nil
+}
+
+func (i *ImmuDB) exportRecord(ctx context.Context, e *schema.Entry, stateTx uint64) (archive.Record, error) {
+	ctx, cancelFn := context.WithTimeout(ctx, defaultTimeout)
+	defer cancelFn()
+
+	ve, err := i.client.VerifiableGet(ctx, &schema.VerifiableGetRequest{
+		KeyRequest:   &schema.KeyRequest{Key: e.Key, AtTx: e.Tx},
+		ProveSinceTx: stateTx,
+	})
+	if err != nil {
+		return archive.Record{}, fmt.Errorf("proving %s: %w", e.Key, err)
+	}
+
+	proof, err := protojson.Marshal(ve)
+	if err != nil {
+		return archive.Record{}, err
+	}
+
+	return archive.Record{
+		Key:   string(e.Key),
+		Tx:    e.Tx,
+		Value: string(e.Value),
+		Proof: proof,
+	}, nil
+}
+
+// key is made of the bucket and an ID which sorts in the order of writes, so that scans return entries in order
 func (i *ImmuDB) key(b bucket.Bucket) []byte {
-	return []byte(fmt.Sprintf("%s_%s", b.String(), uuid.NewString()))
+	return []byte(fmt.Sprintf("%s_%s", b.String(), i.ids.next()))
+}
+
+// scanPrefix starts the keys of the bucket, the separator keeps it from matching e.g. "apple" for "app",
+// there's none for the empty bucket which stands for all of them
+func scanPrefix(b bucket.Bucket) []byte {
+	if b.String() == "" {
+		return nil
+	}
+
+	return []byte(b.String() + "_")
+}
+
+// inBucket tells whether the key is one of the bucket, or of any bucket for the empty one
+func inBucket(key []byte, b bucket.Bucket) bool {
+	return b.String() == "" || bucketOf(key) == b.String()
+}
+
+// sortableIDs generates IDs shaped like UUIDs, see bucketOf, made of a strictly increasing timestamp and random bytes
+type sortableIDs struct {
+	mu   sync.Mutex
+	last uint64
+}
+
+func (s *sortableIDs) next() string {
+	s.mu.Lock()
+	now := uint64(time.Now().UnixNano())
+	if now <= s.last {
+		now = s.last + 1
+	}
+	s.last = now
+	s.mu.Unlock()
+
+	id := uuid.New()
+	binary.BigEndian.PutUint64(id[:8], now)
+
+	return id.String()
+}
+
+// idSuffix is the length of the separator and the UUID added to the bucket by key
+const idSuffix = len("_00000000-0000-0000-0000-000000000000")
+
+// bucketOf strips the UUID suffix added by key
+func bucketOf(key []byte) string {
+	if len(key) < idSuffix {
+		return ""
+	}
+
+	return string(key[:len(key)-idSuffix])
+}
+
+// idOf is the UUID suffix added by key, which sorts in the order of writes
+func idOf(key []byte) string {
+	if len(key) < idSuffix {
+		return string(key)
+	}
+
+	return string(key[len(key)-idSuffix:])
 }
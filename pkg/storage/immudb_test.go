@@ -1,12 +1,22 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
 	"testing"
 	"time"
 
+	"github.com/codenotary/immudb/embedded/store"
 	"github.com/codenotary/immudb/pkg/api/schema"
 	immudb "github.com/codenotary/immudb/pkg/client"
+	"github.com/codenotary/immudb/pkg/database"
+	"github.com/codenotary/immudb/pkg/logger"
+	"github.com/lootek/go-immulogs/pkg/archive"
+	"github.com/lootek/go-immulogs/pkg/checkpoint"
 	"github.com/lootek/go-immulogs/pkg/storage/bucket"
 	"github.com/lootek/go-immulogs/pkg/storage/log"
 	"github.com/stretchr/testify/require"
@@ -17,11 +27,20 @@ type item struct {
 	v []byte
 }
 
+// immuMockMaxScan is the most keys a scan returns, as the default max result size of the server
+const immuMockMaxScan = 1000
+
 type immuMock struct {
-	storage []item
+	storage  []item
+	database string
+
+	// corrupted keys fail their proofs, verifyErr fails the verification of any
+	corrupted map[string]bool
+	verifyErr error
 }
 
 func (i *immuMock) OpenSession(ctx context.Context, user []byte, pass []byte, database string) (err error) {
+	i.database = database
 	return nil
 }
 
@@ -39,9 +58,32 @@ func (i *immuMock) Set(ctx context.Context, key []byte, value []byte) (*schema.T
 	return &schema.TxHeader{Nentries: 1}, nil
 }
 
+// Scan returns the keys with the prefix in order, or in reverse order when desc, past the seek key, up to the limit,
+// capped as the server does
 func (i *immuMock) Scan(ctx context.Context, req *schema.ScanRequest) (*schema.Entries, error) {
+	items := append([]item(nil), i.storage...)
+	sort.SliceStable(items, func(a, b int) bool { return bytes.Compare(items[a].k, items[b].k) < 0 })
+	if req.Desc {
+		slices.Reverse(items)
+	}
+
+	limit := uint64(immuMockMaxScan)
+	if req.Limit > 0 && req.Limit < limit {
+		limit = req.Limit
+	}
+
 	var entries []*schema.Entry
-	for _, i := range i.storage {
+	for _, i := range items {
+		if !bytes.HasPrefix(i.k, req.Prefix) {
+			continue
+		}
+		if req.SeekKey != nil && ((!req.Desc && bytes.Compare(i.k, req.SeekKey) <= 0) || (req.Desc && bytes.Compare(i.k, req.SeekKey) >= 0)) {
+			continue
+		}
+		if uint64(len(entries)) == limit {
+			break
+		}
+
 		entries = append(entries, &schema.Entry{
 			Key:   i.k,
 			Value: i.v,
@@ -59,6 +101,74 @@ func (i *immuMock) SetAll(ctx context.Context, kvList *schema.SetRequest) (*sche
 	return &schema.TxHeader{Nentries: int32(len(kvList.KVs))}, nil
 }
 
+func (i *immuMock) VerifiedGet(ctx context.Context, key []byte, opts ...immudb.GetOption) (*schema.Entry, error) {
+	switch {
+	case i.verifyErr != nil:
+		return nil, i.verifyErr
+	case i.corrupted[string(key)]:
+		return nil, store.ErrCorruptedData
+	}
+
+	for _, it := range i.storage {
+		if bytes.Equal(it.k, key) {
+			return &schema.Entry{Key: it.k, Value: it.v}, nil
+		}
+	}
+
+	return nil, errors.New("key not found")
+}
+
+func (i *immuMock) VerifiableGet(ctx context.Context, req *schema.VerifiableGetRequest) (*schema.VerifiableEntry, error) {
+	e, err := i.VerifiedGet(ctx, req.KeyRequest.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schema.VerifiableEntry{Entry: e}, nil
+}
+
+func (i *immuMock) CurrentState(ctx context.Context) (*schema.ImmutableState, error) {
+	return &schema.ImmutableState{Db: "db", TxId: uint64(len(i.storage)), TxHash: []byte{0xca, 0xfe}}, nil
+}
+
+func (i *immuMock) TxByID(ctx context.Context, tx uint64) (*schema.Tx, error) {
+	return &schema.Tx{Header: &schema.TxHeader{Id: tx, Version: 1}}, nil
+}
+
+// immuEmbedded serves the client from an embedded database, so that the proofs are real ones
+type immuEmbedded struct {
+	immuMock
+	db database.DB
+}
+
+func (i *immuEmbedded) WithOptions(options *immudb.Options) ImmuClient {
+	return i
+}
+
+func (i *immuEmbedded) Set(ctx context.Context, key []byte, value []byte) (*schema.TxHeader, error) {
+	return i.db.Set(&schema.SetRequest{KVs: []*schema.KeyValue{{Key: key, Value: value}}})
+}
+
+func (i *immuEmbedded) SetAll(ctx context.Context, kvList *schema.SetRequest) (*schema.TxHeader, error) {
+	return i.db.Set(kvList)
+}
+
+func (i *immuEmbedded) Scan(ctx context.Context, req *schema.ScanRequest) (*schema.Entries, error) {
+	return i.db.Scan(req)
+}
+
+func (i *immuEmbedded) VerifiableGet(ctx context.Context, req *schema.VerifiableGetRequest) (*schema.VerifiableEntry, error) {
+	return i.db.VerifiableGet(req)
+}
+
+func (i *immuEmbedded) CurrentState(ctx context.Context) (*schema.ImmutableState, error) {
+	return i.db.CurrentState()
+}
+
+func (i *immuEmbedded) TxByID(ctx context.Context, tx uint64) (*schema.Tx, error) {
+	return i.db.TxByID(&schema.TxRequest{Tx: tx})
+}
+
 func TestImmuDB(t *testing.T) {
 	for testCase, bucketName := range map[string]string{
 		"globally":   "",
@@ -130,12 +240,280 @@ func TestImmuDB(t *testing.T) {
 
 				require.NoError(t, err)
 				require.Equal(t, []log.Entry{
-					log.FromString("a sample log entry"),
-					log.FromString("a sample log entry #1"),
 					log.FromString("a sample log entry #2"),
 					log.FromString("a sample log entry #3"),
 				}, got)
 			})
+
+			t.Run("list buckets", func(t *testing.T) {
+				got, err := r.Buckets()
+				require.NoError(t, err)
+
+				if bucketName != "" {
+					require.Equal(t, []bucket.Bucket{bucket.NewBucket(bucketName)}, got)
+				} else {
+					require.Empty(t, got)
+				}
+			})
+
+			t.Run("verify", func(t *testing.T) {
+				got, err := r.Verify(bucket.NewBucket(bucketName))
+				require.NoError(t, err)
+				require.Equal(t, map[string]any{"verified": 4, "failed": []string{}}, got)
+			})
+
+			t.Run("state", func(t *testing.T) {
+				cnt, state, err := r.State(bucket.NewBucket(bucketName))
+				require.NoError(t, err)
+				require.Equal(t, uint64(4), cnt)
+				require.Equal(t, archive.State{Database: "db", TxID: 4, TxHash: []byte{0xca, 0xfe}}, state)
+			})
+
+			t.Run("export", func(t *testing.T) {
+				var got []string
+				state, err := r.Export(ctx, bucket.NewBucket(bucketName), 0, func(r archive.Record) error {
+					require.Equal(t, bucketName, bucketOf([]byte(r.Key)))
+					require.Contains(t, string(r.Proof), `"entry":`)
+					got = append(got, r.Value)
+					return nil
+				})
+				require.NoError(t, err)
+				require.Equal(t, &archive.State{Database: "db", TxID: 4, TxHash: []byte{0xca, 0xfe}}, state)
+				require.Equal(t, []string{
+					"a sample log entry",
+					"a sample log entry #1",
+					"a sample log entry #2",
+					"a sample log entry #3",
+				}, got)
+			})
 		})
 	}
 }
+
+func TestImmuDBTenants(t *testing.T) {
+	r := NewImmuDB(&immudb.Options{Username: "user", Password: "pass", Database: "db"}).
+		WithTenants(map[string]string{"acme": "acme_logs", "globex": "globex_logs"})
+
+	mocks := map[string]*immuMock{"": {}}
+	r.client = mocks[""]
+	for _, tenant := range []string{"acme", "globex"} {
+		db, ok := r.Tenant(tenant)
+		require.True(t, ok)
+
+		mocks[tenant] = &immuMock{}
+		db.client = mocks[tenant]
+	}
+
+	_, ok := r.Tenant("initech")
+	require.False(t, ok)
+
+	require.NoError(t, r.Start(context.Background()))
+	defer r.Stop()
+
+	// a session per tenant, in its own database
+	require.Equal(t, "db", mocks[""].database)
+	require.Equal(t, "acme_logs", mocks["acme"].database)
+	require.Equal(t, "globex_logs", mocks["globex"].database)
+
+	acme, _ := r.Tenant("acme")
+	_, err := acme.WriteOne(bucket.NewBucket("app"), log.FromString(`a sample log entry`))
+	require.NoError(t, err)
+
+	require.Len(t, mocks["acme"].storage, 1)
+	require.Empty(t, mocks["globex"].storage)
+	require.Empty(t, mocks[""].storage)
+}
+
+func TestImmuDBStartContext(t *testing.T) {
+	r := NewImmuDB(&immudb.Options{Database: "db"}).WithTenants(map[string]string{"acme": "acme_logs"})
+	r.client = &immuMock{}
+	acme, _ := r.Tenant("acme")
+	acme.client = &immuMock{}
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	require.NoError(t, r.Start(ctx))
+	require.NoError(t, r.ctx.Err())
+
+	// the storage is stopped along with the context it was started with, tenants included
+	cancelFn()
+	require.Eventually(t, func() bool { return r.ctx.Err() != nil && acme.ctx.Err() != nil }, time.Second, time.Millisecond)
+}
+
+func TestImmuDBSharedPrefix(t *testing.T) {
+	r := NewImmuDB(&immudb.Options{Database: "db"})
+	r.client = &immuMock{}
+	require.NoError(t, r.Start(context.Background()))
+	defer r.Stop()
+
+	for _, name := range []string{"app", "apple", "app2", "app_x"} {
+		_, err := r.WriteBatch(bucket.NewBucket(name), []log.Entry{log.FromString(name + " #1"), log.FromString(name + " #2")})
+		require.NoError(t, err)
+	}
+
+	// the empty bucket stands for all of them
+	cnt, err := r.Count(bucket.NewBucket(""))
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), cnt)
+
+	for _, name := range []string{"app", "app_x"} {
+		b := bucket.NewBucket(name)
+		want := []log.Entry{log.FromString(name + " #1"), log.FromString(name + " #2")}
+
+		got, err := r.All(b)
+		require.NoError(t, err)
+		require.Equal(t, want, got, name)
+
+		got, err = r.Last(b, 1)
+		require.NoError(t, err)
+		require.Equal(t, want[1:], got, name)
+
+		cnt, err := r.Count(b)
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), cnt, name)
+
+		cnt, _, err = r.State(b)
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), cnt, name)
+
+		v, err := r.Verify(b)
+		require.NoError(t, err)
+		require.Equal(t, 2, v["verified"], name)
+
+		var exported []string
+		_, err = r.Export(context.Background(), b, 0, func(r archive.Record) error {
+			exported = append(exported, r.Value)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{name + " #1", name + " #2"}, exported, name)
+	}
+}
+
+func TestImmuDBBucketsPaged(t *testing.T) {
+	r := NewImmuDB(&immudb.Options{Database: "db"})
+	r.client = &immuMock{}
+	require.NoError(t, r.Start(context.Background()))
+	defer r.Stop()
+
+	var want []bucket.Bucket
+	for n := 0; n < 3*immuMockMaxScan/2; n++ {
+		b := bucket.NewBucket(fmt.Sprintf("bucket-%04d", n))
+		want = append(want, b)
+
+		_, err := r.WriteBatch(b, []log.Entry{log.FromString("#1"), log.FromString("#2")})
+		require.NoError(t, err)
+	}
+
+	got, err := r.Buckets()
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+func TestImmuDBVerify(t *testing.T) {
+	mock := &immuMock{}
+	r := NewImmuDB(&immudb.Options{Database: "db"})
+	r.client = mock
+	require.NoError(t, r.Start(context.Background()))
+	defer r.Stop()
+
+	b := bucket.NewBucket("app")
+	_, err := r.WriteBatch(b, []log.Entry{log.FromString("#1"), log.FromString("#2")})
+	require.NoError(t, err)
+
+	// only the entries failing their proofs are listed as failed
+	mock.corrupted = map[string]bool{string(mock.storage[1].k): true}
+	got, err := r.Verify(b)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"verified": 1, "failed": []string{string(mock.storage[1].k)}}, got)
+
+	mock.verifyErr = context.DeadlineExceeded
+	_, err = r.Verify(b)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestImmuDBLastPaged(t *testing.T) {
+	r := NewImmuDB(&immudb.Options{Database: "db"})
+	r.client = &immuMock{}
+	require.NoError(t, r.Start(context.Background()))
+	defer r.Stop()
+
+	var want []log.Entry
+	for n := 0; n < 3*immuMockMaxScan/2; n++ {
+		e := log.FromString(fmt.Sprintf("app #%d", n))
+		want = append(want, e)
+
+		_, err := r.WriteOne(bucket.NewBucket("app"), e)
+		require.NoError(t, err)
+		_, err = r.WriteOne(bucket.NewBucket("app_x"), log.FromString("app_x"))
+		require.NoError(t, err)
+	}
+	_, err := r.WriteOne(bucket.NewBucket("other"), log.FromString("other"))
+	require.NoError(t, err)
+
+	// the most recent ones, in order, the other buckets sharing the prefix aside
+	got, err := r.Last(bucket.NewBucket("app"), immuMockMaxScan+10)
+	require.NoError(t, err)
+	require.Equal(t, want[len(want)-immuMockMaxScan-10:], got)
+
+	got, err = r.Last(bucket.NewBucket(""), 2)
+	require.NoError(t, err)
+	require.Equal(t, []log.Entry{log.FromString("app_x"), log.FromString("other")}, got)
+}
+
+func TestSortableIDs(t *testing.T) {
+	var ids sortableIDs
+
+	prev := ids.next()
+	for n := 0; n < 1000; n++ {
+		id := ids.next()
+		require.Len(t, id, len(prev))
+		require.Less(t, prev, id)
+		prev = id
+	}
+
+	require.Equal(t, "my-bucket-name", bucketOf([]byte("my-bucket-name_"+prev)))
+}
+
+func TestImmuDBExportAtCheckpoint(t *testing.T) {
+	db, err := database.NewDB("db", nil, database.DefaultOption().WithDBRootPath(t.TempDir()), logger.NewMemoryLogger())
+	require.NoError(t, err)
+	defer db.Close()
+
+	r := NewImmuDB(&immudb.Options{Database: "db"})
+	r.client = &immuEmbedded{db: db}
+	require.NoError(t, r.Start(context.Background()))
+	defer r.Stop()
+
+	b := bucket.NewBucket("app")
+	for _, s := range []string{"#1", "#2", "#3"} {
+		_, err := r.WriteOne(b, log.FromString(s))
+		require.NoError(t, err)
+	}
+
+	cnt, state, err := r.State(b)
+	require.NoError(t, err)
+	cp := checkpoint.New(b.String(), cnt, state, time.Now())
+
+	for _, s := range []string{"#4", "#5"} {
+		_, err := r.WriteOne(b, log.FromString(s))
+		require.NoError(t, err)
+	}
+
+	var exported []string
+	exportedState, err := r.Export(context.Background(), b, cp.State().TxID, func(rec archive.Record) error {
+		exported = append(exported, rec.Value)
+		return archive.VerifyRecord(rec, cp.State())
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"#1", "#2", "#3"}, exported)
+	require.Equal(t, cp.State(), *exportedState)
+
+	// the current state doesn't match the checkpoint any longer
+	_, err = r.Export(context.Background(), b, 0, func(rec archive.Record) error {
+		return archive.VerifyRecord(rec, cp.State())
+	})
+	require.Error(t, err)
+
+	_, err = r.Export(context.Background(), b, 100, func(rec archive.Record) error { return nil })
+	require.ErrorContains(t, err, "tx 100 is past the current state")
+}
@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// masterKeySize is the size of the AES-256 master and data keys
+const masterKeySize = 32
+
+// ErrShredded is the error of entries whose data key was destroyed
+var ErrShredded = errors.New("the key of the entry was shredded")
+
+// DataKey encrypts the entries of a bucket of a tenant, it's stored wrapped by the master key
+type DataKey struct {
+	ID         string     `json:"id"`
+	Tenant     string     `json:"tenant,omitempty"`
+	Bucket     string     `json:"bucket"`
+	Wrapped    []byte     `json:"wrapped,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ShreddedAt *time.Time `json:"shredded_at,omitempty"`
+}
+
+// Keyring keeps the data keys in a JSON file, outside the immutable storage so that they can be destroyed
+type Keyring struct {
+	path   string
+	master cipher.AEAD
+
+	mu   sync.Mutex
+	keys []*DataKey
+	// aeads are the unwrapped data keys by ID
+	aeads map[string]cipher.AEAD
+}
+
+// LoadMasterKey reads a 32 bytes key, either raw or hex or base64 encoded, e.g. as written by openssl rand -hex 32
+func LoadMasterKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == masterKeySize {
+		return data, nil
+	}
+
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == masterKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == masterKeySize {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%s: not a %d bytes key", path, masterKeySize)
+}
+
+// NewKeyring loads the data keys saved at path, if any, they're kept in memory only if path is empty
+func NewKeyring(path string, master []byte) (*Keyring, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{path: path, master: aead, aeads: map[string]cipher.AEAD{}}
+	if path == "" {
+		return k, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &k.keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, key := range k.keys {
+		if key.ShreddedAt != nil {
+			continue
+		}
+
+		plain, err := open(k.master, key.Wrapped, []byte(key.ID))
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %s: wrong master key: %w", path, key.ID, err)
+		}
+
+		if k.aeads[key.ID], err = newAEAD(plain); err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+// current returns the key encrypting the new entries of the bucket, created on first use
+func (k *Keyring) current(tenant, bucket string) (string, cipher.AEAD, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		key := k.keys[i]
+		if key.Tenant == tenant && key.Bucket == bucket && key.ShreddedAt == nil {
+			return key.ID, k.aeads[key.ID], nil
+		}
+	}
+
+	plain := make([]byte, masterKeySize)
+	if _, err := rand.Read(plain); err != nil {
+		return "", nil, err
+	}
+
+	id := make([]byte, keyIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+
+	key := &DataKey{
+		ID:        hex.EncodeToString(id),
+		Tenant:    tenant,
+		Bucket:    bucket,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	var err error
+	if key.Wrapped, err = seal(k.master, plain, []byte(key.ID)); err != nil {
+		return "", nil, err
+	}
+
+	aead, err := newAEAD(plain)
+	if err != nil {
+		return "", nil, err
+	}
+
+	k.keys = append(k.keys, key)
+	if err := k.save(); err != nil {
+		k.keys = k.keys[:len(k.keys)-1]
+		return "", nil, err
+	}
+	k.aeads[key.ID] = aead
+
+	return key.ID, aead, nil
+}
+
+// get returns the key with the given ID along with its bucket, provided it's one of the tenant,
+// ErrShredded if it was destroyed
+func (k *Keyring) get(tenant, id string) (string, cipher.AEAD, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, key := range k.keys {
+		if key.ID != id {
+			continue
+		}
+
+		if key.Tenant != tenant {
+			return "", nil, fmt.Errorf("key %s is not one of the tenant", id)
+		}
+		if key.ShreddedAt != nil {
+			return key.Bucket, nil, ErrShredded
+		}
+
+		return key.Bucket, k.aeads[id], nil
+	}
+
+	return "", nil, fmt.Errorf("unknown key %s", id)
+}
+
+// Shred destroys the keys of the bucket, which makes its entries unreadable for good, the next writes
+// get a new key, it returns the number of keys destroyed
+// copies of the keyring file, e.g. backups, must be destroyed as well
+func (k *Keyring) Shred(tenant, bucket string) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var shredded []*DataKey
+	var wrapped [][]byte
+	for _, key := range k.keys {
+		if key.Tenant == tenant && key.Bucket == bucket && key.ShreddedAt == nil {
+			shredded = append(shredded, key)
+			wrapped = append(wrapped, key.Wrapped)
+			key.Wrapped, key.ShreddedAt = nil, &now
+		}
+	}
+	if len(shredded) == 0 {
+		return 0, nil
+	}
+
+	if err := k.save(); err != nil {
+		for i, key := range shredded {
+			key.Wrapped, key.ShreddedAt = wrapped[i], nil
+		}
+		return 0, err
+	}
+
+	for _, key := range shredded {
+		delete(k.aeads, key.ID)
+	}
+
+	return len(shredded), nil
+}
+
+func (k *Keyring) save() error {
+	if k.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(k.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), filepath.Base(k.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), k.path)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("keys must be %d bytes long", masterKeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with a random nonce, which prefixes the result
+func seal(aead cipher.AEAD, plain, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plain, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
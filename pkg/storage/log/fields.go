@@ -0,0 +1,44 @@
+package log
+
+import (
+	"encoding/json"
+)
+
+// Well-known fields of structured entries, shared by all inputs
+const (
+	// TimestampKey holds the original timestamp of an entry in RFC 3339 format
+	TimestampKey = "timestamp"
+	// MessageKey holds the human-readable message
+	MessageKey = "message"
+	// LevelKey holds the severity name, e.g. "error"
+	LevelKey = "level"
+	// HostKey holds the name of the host an entry originates from
+	HostKey = "host"
+	// RawKey holds the original line an entry was parsed from
+	RawKey = "raw"
+)
+
+// Structured is an Entry made of named fields, stored as a JSON object
+type Structured interface {
+	Entry
+	Fields() map[string]any
+}
+
+type fields map[string]any
+
+func FromFields(f map[string]any) Structured {
+	return fields(f)
+}
+
+func (f fields) Fields() map[string]any {
+	return f
+}
+
+func (f fields) String() string {
+	return string(f.Bytes())
+}
+
+func (f fields) Bytes() []byte {
+	b, _ := json.Marshal(map[string]any(f))
+	return b
+}
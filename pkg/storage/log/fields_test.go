@@ -0,0 +1,29 @@
+package log
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]any
+		want   string
+	}{
+		{"empty", map[string]any{}, `{}`},
+		{"nil", nil, `null`},
+		{"flat", map[string]any{"level": "info", "msg": "ßąś"}, `{"level":"info","msg":"ßąś"}`},
+		{"nested", map[string]any{"a": map[string]any{"b": []any{1, "2"}}}, `{"a":{"b":[1,"2"]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromFields(tt.fields)
+			require.Equal(t, fields(tt.fields), got)
+			require.Equal(t, tt.fields, got.Fields())
+			require.Equal(t, tt.want, got.String())
+			require.Equal(t, []byte(tt.want), got.Bytes())
+		})
+	}
+}
@@ -2,9 +2,13 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
+	"sort"
 	"sync"
 
+	"github.com/lootek/go-immulogs/pkg/archive"
 	"github.com/lootek/go-immulogs/pkg/storage/bucket"
 	"github.com/lootek/go-immulogs/pkg/storage/log"
 )
@@ -12,6 +16,13 @@ import (
 type Memory struct {
 	dataMu sync.RWMutex
 	data   map[bucket.Bucket][]log.Entry
+
+	// every write is a transaction, hashes are chained so that the state covers all the writes so far
+	tx     uint64
+	txHash []byte
+
+	// tenants have their own namespace, i.e. their own Memory
+	tenants map[string]*Memory
 }
 
 func (m *Memory) Start(_ context.Context) error {
@@ -26,6 +37,22 @@ func NewMemory() *Memory {
 	return &Memory{data: map[bucket.Bucket][]log.Entry{}}
 }
 
+// WithTenants isolates every tenant in its own namespace, the databases are irrelevant in memory
+func (m *Memory) WithTenants(databases map[string]string) *Memory {
+	m.tenants = make(map[string]*Memory, len(databases))
+	for tenant := range databases {
+		m.tenants[tenant] = NewMemory()
+	}
+
+	return m
+}
+
+// Tenant is the storage of the tenant, false if there is no such tenant
+func (m *Memory) Tenant(name string) (*Memory, bool) {
+	t, ok := m.tenants[name]
+	return t, ok
+}
+
 func (m *Memory) WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error) {
 	m.dataMu.Lock()
 	defer m.dataMu.Unlock()
@@ -33,6 +60,7 @@ func (m *Memory) WriteOne(b bucket.Bucket, e log.Entry) (map[string]any, error)
 	entries := m.data[b]
 	entries = append(entries, e)
 	m.data[b] = entries
+	m.commit(b, e)
 
 	return map[string]any{"written": 1}, nil
 }
@@ -44,6 +72,7 @@ func (m *Memory) WriteBatch(b bucket.Bucket, e []log.Entry) (map[string]any, err
 	entries := m.data[b]
 	entries = append(entries, e...)
 	m.data[b] = entries
+	m.commit(b, e...)
 
 	return map[string]any{"written": len(e)}, nil
 }
@@ -52,6 +81,10 @@ func (m *Memory) All(b bucket.Bucket) ([]log.Entry, error) {
 	m.dataMu.RLock()
 	defer m.dataMu.RUnlock()
 
+	if b.String() != "" {
+		return append([]log.Entry(nil), m.data[b]...), nil
+	}
+
 	var entries []log.Entry
 	for _, e := range m.data {
 		entries = append(entries, e...)
@@ -81,6 +114,10 @@ func (m *Memory) Count(b bucket.Bucket) (uint64, error) {
 	m.dataMu.RLock()
 	defer m.dataMu.RUnlock()
 
+	if b.String() != "" {
+		return uint64(len(m.data[b])), nil
+	}
+
 	var cnt uint64
 	for _, e := range m.data {
 		cnt += uint64(len(e))
@@ -88,3 +125,100 @@ func (m *Memory) Count(b bucket.Bucket) (uint64, error) {
 
 	return cnt, nil
 }
+
+// Size counts the bytes of the entries of the bucket, of all of them for the empty one
+func (m *Memory) Size(b bucket.Bucket) (int64, error) {
+	m.dataMu.RLock()
+	defer m.dataMu.RUnlock()
+
+	var size int64
+	for name, entries := range m.data {
+		if b.String() != "" && name != b {
+			continue
+		}
+
+		for _, e := range entries {
+			size += int64(len(e.Bytes()))
+		}
+	}
+
+	return size, nil
+}
+
+func (m *Memory) Buckets() ([]bucket.Bucket, error) {
+	m.dataMu.RLock()
+	defer m.dataMu.RUnlock()
+
+	var buckets []bucket.Bucket
+	for b := range m.data {
+		if b.String() != "" {
+			buckets = append(buckets, b)
+		}
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].String() < buckets[j].String() })
+
+	return buckets, nil
+}
+
+// Export streams the entries of the bucket, without proofs, so not up to a given tx
+func (m *Memory) Export(ctx context.Context, b bucket.Bucket, tx uint64, fn func(archive.Record) error) (*archive.State, error) {
+	if tx != 0 {
+		return nil, errors.New("exporting up to a tx is not supported by the in-memory storage")
+	}
+
+	entries, err := m.All(b)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := fn(archive.Record{Value: string(e.Bytes())}); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// commit chains the hash of a write to the previous one, the caller holds the lock
+func (m *Memory) commit(b bucket.Bucket, entries ...log.Entry) {
+	h := sha256.New()
+	h.Write(m.txHash)
+
+	// every part is prefixed with its length, so that no two writes hash the same
+	var size [8]byte
+	for _, data := range append([][]byte{b.Bytes()}, entryBytes(entries)...) {
+		binary.BigEndian.PutUint64(size[:], uint64(len(data)))
+		h.Write(size[:])
+		h.Write(data)
+	}
+
+	m.tx++
+	m.txHash = h.Sum(nil)
+}
+
+func entryBytes(entries []log.Entry) [][]byte {
+	data := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		data = append(data, e.Bytes())
+	}
+
+	return data
+}
+
+// State returns the number of entries of the bucket and the hash chained over all the writes so far
+func (m *Memory) State(b bucket.Bucket) (uint64, archive.State, error) {
+	m.dataMu.RLock()
+	defer m.dataMu.RUnlock()
+
+	return uint64(len(m.data[b])), archive.State{
+		Database: "memory",
+		TxID:     m.tx,
+		TxHash:   append([]byte(nil), m.txHash...),
+	}, nil
+}
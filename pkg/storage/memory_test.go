@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/lootek/go-immulogs/pkg/archive"
 	"github.com/lootek/go-immulogs/pkg/storage/bucket"
 	"github.com/lootek/go-immulogs/pkg/storage/log"
 	"github.com/stretchr/testify/require"
@@ -85,6 +86,74 @@ func TestMemory(t *testing.T) {
 					require.Equal(t, []log.Entry(nil), got)
 				}
 			})
+
+			t.Run("list buckets", func(t *testing.T) {
+				got, err := r.Buckets()
+				require.NoError(t, err)
+
+				if bucketName != "" {
+					require.Equal(t, []bucket.Bucket{bucket.NewBucket(bucketName)}, got)
+				} else {
+					require.Empty(t, got)
+				}
+			})
+
+			t.Run("export", func(t *testing.T) {
+				var got []archive.Record
+				state, err := r.Export(ctx, bucket.NewBucket(bucketName), 0, func(r archive.Record) error {
+					got = append(got, r)
+					return nil
+				})
+				require.NoError(t, err)
+				require.Nil(t, state)
+				require.Equal(t, []archive.Record{
+					{Value: "a sample log entry"},
+					{Value: "a sample log entry #1"},
+					{Value: "a sample log entry #2"},
+					{Value: "a sample log entry #3"},
+				}, got)
+			})
+
+			t.Run("state", func(t *testing.T) {
+				cnt, state, err := r.State(bucket.NewBucket(bucketName))
+				require.NoError(t, err)
+				require.Equal(t, uint64(4), cnt)
+				require.Equal(t, uint64(2), state.TxID)
+				require.Len(t, state.TxHash, 32)
+
+				_, err = r.WriteOne(bucket.NewBucket("other"), log.FromString(`a sample log entry`))
+				require.NoError(t, err)
+
+				cnt, next, err := r.State(bucket.NewBucket(bucketName))
+				require.NoError(t, err)
+				require.Equal(t, uint64(4), cnt)
+				require.Equal(t, uint64(3), next.TxID)
+				require.NotEqual(t, state.TxHash, next.TxHash)
+			})
 		})
 	}
 }
+
+func TestMemoryTenants(t *testing.T) {
+	m := NewMemory().WithTenants(map[string]string{"acme": "", "globex": ""})
+
+	acme, ok := m.Tenant("acme")
+	require.True(t, ok)
+	globex, ok := m.Tenant("globex")
+	require.True(t, ok)
+	_, ok = m.Tenant("initech")
+	require.False(t, ok)
+
+	_, err := acme.WriteOne(bucket.NewBucket("app"), log.FromString(`a sample log entry`))
+	require.NoError(t, err)
+
+	for _, s := range []*Memory{m, globex} {
+		cnt, err := s.Count(bucket.NewBucket("app"))
+		require.NoError(t, err)
+		require.Zero(t, cnt)
+	}
+
+	cnt, err := acme.Count(bucket.NewBucket("app"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), cnt)
+}